@@ -0,0 +1,91 @@
+package websocket
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// AckEvent is the type of the envelopes acknowledging an event sent with SendEventAck.
+const AckEvent = "ack"
+
+// SendEventAck sends an envelope of type t carrying the JSON encoding of v, like SendEvent,
+// and waits for the peer to acknowledge it. The acknowledgment is received by the Router
+// dispatching the messages of the connection, so one must be running concurrently.
+// It fails with AckTimeout if ctx is done first, and with ErrConnectionClosed if the
+// connection closes first.
+func (ws *Websocket) SendEventAck(ctx context.Context, t string, v any) error {
+	data, _, err := JSONCodec{Options: ws.jsonOptions}.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	_, err = ws.roundTrip(ctx, Envelope{Type: t, Ack: true, Data: data})
+	if err != nil && ctx.Err() != nil {
+		return fmt.Errorf("%w: %w", AckTimeout, err)
+	}
+
+	return err
+}
+
+// roundTrip sends the envelope under a new ID and waits for the reply carrying that ID,
+// until ctx is done or the connection closes.
+func (ws *Websocket) roundTrip(ctx context.Context, envelope Envelope) (Envelope, error) {
+	envelope.ID = randomID()
+	reply := ws.replies.expect(envelope.ID)
+	defer ws.replies.forget(envelope.ID)
+	err := ws.WriteJSON(ctx, envelope)
+	if err != nil {
+		return Envelope{}, err
+	}
+
+	select {
+	case envelope = <-reply:
+		return envelope, nil
+	case <-ctx.Done():
+		return Envelope{}, ctx.Err()
+	case <-ws.done:
+		return Envelope{}, ErrConnectionClosed
+	}
+}
+
+// replies hands the envelopes answering those sent on a connection, acknowledgments and
+// responses, to the callers waiting for them.
+type replies struct {
+	mu      sync.Mutex
+	pending map[string]chan Envelope
+}
+
+// expect registers a wait for the reply to the envelope with the given ID.
+func (r *replies) expect(id string) <-chan Envelope {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.pending == nil {
+		r.pending = map[string]chan Envelope{}
+	}
+
+	reply := make(chan Envelope, 1)
+	r.pending[id] = reply
+	return reply
+}
+
+// forget stops waiting for the reply to the envelope with the given ID.
+func (r *replies) forget(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.pending, id)
+}
+
+// resolve hands the reply to its caller, if it is still waiting. Replies nobody
+// waits for, because their caller gave up or they are duplicates, are dropped.
+func (r *replies) resolve(envelope Envelope) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	reply, ok := r.pending[envelope.ID]
+	if !ok {
+		return
+	}
+
+	delete(r.pending, envelope.ID)
+	reply <- envelope
+}
@@ -0,0 +1,93 @@
+package websocket
+
+import (
+	"context"
+)
+
+// Bridge relays hub broadcasts between server instances, for example over a message
+// broker, so a message broadcast to a room reaches the members connected to other
+// instances too. Implementations live in the bridge subdirectories, in their own
+// modules.
+type Bridge interface {
+	// Publish sends the broadcast to the hubs of every instance, including the sender.
+	Publish(ctx context.Context, msg BridgeMessage) error
+
+	// Subscribe calls handle with every broadcast published, until ctx is done or the
+	// subscription fails. It returns nil when ctx is done.
+	Subscribe(ctx context.Context, handle func(msg BridgeMessage)) error
+}
+
+// BridgeMessage is a broadcast relayed between hubs.
+type BridgeMessage struct {
+	// Origin is the ID of the hub the message was broadcast on.
+	Origin string `json:"origin"`
+
+	// Room and Tags select the members the message is delivered to, as in Target.
+	Room string   `json:"room,omitempty"`
+	Tags []string `json:"tags,omitempty"`
+
+	// Type and Data are the message.
+	Type MessageType `json:"type"`
+	Data []byte      `json:"data"`
+}
+
+// ID gives the random identifier of the hub, telling the broadcasts it published apart
+// from those of other hubs.
+func (h *Hub) ID() string {
+	h.idOnce.Do(func() {
+		h.id = randomID()
+	})
+
+	return h.id
+}
+
+// Bridge connects the hub to the other instances through b until ctx is done or the
+// subscription fails: broadcasts made on the hub are published through b, and those
+// published by other hubs are delivered to the local members they target. Broadcasts
+// received through the bridge do not run the BeforeBroadcast hooks again. Bridge
+// returns nil when ctx is done, and is meant to run in its own goroutine.
+func (h *Hub) Bridge(ctx context.Context, b Bridge) error {
+	h.hooksMu.Lock()
+	h.bridge = b
+	h.hooksMu.Unlock()
+
+	defer func() {
+		h.hooksMu.Lock()
+		if h.bridge == b {
+			h.bridge = nil
+		}
+		h.hooksMu.Unlock()
+	}()
+
+	return b.Subscribe(ctx, func(msg BridgeMessage) {
+		if msg.Origin == h.ID() {
+			// delivered locally when it was broadcast
+			return
+		}
+
+		pm, err := NewPreparedMessage(msg.Type, msg.Data)
+		if err != nil {
+			return
+		}
+
+		_, _ = h.deliverTo(ctx, Target{Room: msg.Room, Tags: msg.Tags}, pm)
+	})
+}
+
+// publish sends a broadcast made on the hub to the other hubs, if it is bridged.
+func (h *Hub) publish(ctx context.Context, target Target, pm *PreparedMessage) error {
+	h.hooksMu.RLock()
+	b := h.bridge
+	h.hooksMu.RUnlock()
+	if b == nil {
+		return nil
+	}
+
+	return b.Publish(ctx, BridgeMessage{
+		Origin: h.ID(),
+		Room:   target.Room,
+		Tags:   target.Tags,
+		Type:   pm.t,
+		Data:   pm.data,
+	})
+}
@@ -0,0 +1,131 @@
+// Package kafka plugs hubs into Kafka pipelines: a Consumer broadcasts the records of
+// Kafka topics to hub rooms, and a Producer writes the messages clients send to Kafka.
+//
+// It lives in its own module so the websocket package itself stays free of
+// external dependencies.
+package kafka
+
+import (
+	"context"
+
+	"github.com/ajsqr/websocket"
+	"github.com/segmentio/kafka-go"
+)
+
+// Consumer broadcasts the records read from Kafka to the rooms of a hub.
+type Consumer struct {
+	// Reader reads the records. With a GroupID, records are committed once broadcast,
+	// so a restarted consumer resumes after the last record delivered.
+	Reader *kafka.Reader
+
+	// Hub is the hub the records are broadcast on.
+	Hub *websocket.Hub
+
+	// Room, if set, gives the room a record is broadcast to; an empty room broadcasts
+	// to the whole hub. By default the record is broadcast to the room named after its topic.
+	Room func(m kafka.Message) string
+
+	// Type is the type of the messages broadcast. It defaults to text.
+	Type websocket.MessageType
+}
+
+// Run reads records and broadcasts them until ctx is done or reading fails.
+// It returns nil when ctx is done.
+func (c *Consumer) Run(ctx context.Context) error {
+	commit := c.Reader.Config().GroupID != ""
+	for {
+		var m kafka.Message
+		var err error
+		if commit {
+			m, err = c.Reader.FetchMessage(ctx)
+		} else {
+			m, err = c.Reader.ReadMessage(ctx)
+		}
+
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return err
+		}
+
+		err = c.Hub.BroadcastToRoom(ctx, c.room(m), c.messageType(), m.Value)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return err
+		}
+
+		if commit {
+			err = c.Reader.CommitMessages(ctx, m)
+			if err != nil && ctx.Err() == nil {
+				return err
+			}
+		}
+	}
+}
+
+func (c *Consumer) room(m kafka.Message) string {
+	if c.Room != nil {
+		return c.Room(m)
+	}
+
+	return m.Topic
+}
+
+func (c *Consumer) messageType() websocket.MessageType {
+	if c.Type == "" {
+		return websocket.TextMessage
+	}
+
+	return c.Type
+}
+
+// Producer writes the messages received from clients to Kafka.
+type Producer struct {
+	// Writer writes the records. Its Topic, or the one returned by Topic, receives them.
+	Writer *kafka.Writer
+
+	// Topic, if set, gives the topic of the record for a message; the Writer must then
+	// have no Topic of its own.
+	Topic func(ws *websocket.Websocket, msg websocket.Message) string
+
+	// Key, if set, gives the key of the record for a message, choosing its partition.
+	// By default records have no key.
+	Key func(ws *websocket.Websocket, msg websocket.Message) []byte
+}
+
+// Produce writes the message received from ws as a record.
+func (p *Producer) Produce(ctx context.Context, ws *websocket.Websocket, msg websocket.Message) error {
+	record := kafka.Message{
+		Value: msg.Data,
+		Headers: []kafka.Header{
+			{Key: "websocket-message-type", Value: []byte(msg.Type)},
+		},
+	}
+
+	if p.Topic != nil {
+		record.Topic = p.Topic(ws, msg)
+	}
+
+	if p.Key != nil {
+		record.Key = p.Key(ws, msg)
+	}
+
+	return p.Writer.WriteMessages(ctx, record)
+}
+
+// Handlers gives handlers for Websocket.Listen that produce every message received from ws.
+func (p *Producer) Handlers(ws *websocket.Websocket) websocket.Handlers {
+	return websocket.Handlers{
+		OnText: func(ctx context.Context, data []byte) error {
+			return p.Produce(ctx, ws, websocket.Message{Type: websocket.TextMessage, Data: data})
+		},
+		OnBinary: func(ctx context.Context, data []byte) error {
+			return p.Produce(ctx, ws, websocket.Message{Type: websocket.BinaryMessage, Data: data})
+		},
+	}
+}
@@ -0,0 +1,113 @@
+// Package nats provides a websocket.Bridge over NATS, so the hubs of several server
+// instances broadcast to each other's members.
+//
+// It lives in its own module so the websocket package itself stays free of
+// external dependencies.
+package nats
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/ajsqr/websocket"
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// defaultPrefix is the subject prefix used when Bridge.Prefix is not set.
+	defaultPrefix = "websocket"
+
+	// pending is the number of received broadcasts buffered while one is being delivered.
+	pending = 1024
+)
+
+// Bridge relays hub broadcasts through NATS subjects. Broadcasts to the whole hub are
+// published on the subject named by Prefix, and broadcasts to a room on Prefix.room,
+// unless Subject maps rooms differently.
+type Bridge struct {
+	// Conn is the connection to NATS.
+	Conn *nats.Conn
+
+	// Prefix is the subject of the whole hub, and the prefix of the room subjects.
+	// It defaults to "websocket".
+	Prefix string
+
+	// Subject, if set, maps a room to the subject broadcasts to it are published on,
+	// for rooms whose names are not valid subject tokens. The subjects must stay under
+	// Prefix for Subscribe to receive them. It is not called for the whole hub.
+	Subject func(room string) string
+
+	// Queue, if set, subscribes as a member of this queue group, so each broadcast is
+	// delivered to only one of the subscribers sharing it. This suits instances that
+	// split the work of consuming broadcasts rather than hubs that must all deliver
+	// them, which should leave it empty.
+	Queue string
+}
+
+// Publish sends the broadcast on the subject of its room.
+func (b *Bridge) Publish(ctx context.Context, msg websocket.BridgeMessage) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	return b.Conn.Publish(b.SubjectFor(msg.Room), payload)
+}
+
+// Subscribe listens on the subject of the whole hub and of every room until ctx is done.
+// Messages that cannot be decoded are skipped.
+func (b *Bridge) Subscribe(ctx context.Context, handle func(msg websocket.BridgeMessage)) error {
+	messages := make(chan *nats.Msg, pending)
+	prefix := b.prefix()
+	for _, subject := range []string{prefix, prefix + ".>"} {
+		var sub *nats.Subscription
+		var err error
+		if b.Queue != "" {
+			sub, err = b.Conn.ChanQueueSubscribe(subject, b.Queue, messages)
+		} else {
+			sub, err = b.Conn.ChanSubscribe(subject, messages)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		defer sub.Unsubscribe()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case m := <-messages:
+			msg := websocket.BridgeMessage{}
+			err := json.Unmarshal(m.Data, &msg)
+			if err != nil {
+				continue
+			}
+
+			handle(msg)
+		}
+	}
+}
+
+// SubjectFor gives the subject broadcasts to the room are published on.
+func (b *Bridge) SubjectFor(room string) string {
+	if room == "" {
+		return b.prefix()
+	}
+
+	if b.Subject != nil {
+		return b.Subject(room)
+	}
+
+	return b.prefix() + "." + room
+}
+
+func (b *Bridge) prefix() string {
+	if b.Prefix == "" {
+		return defaultPrefix
+	}
+
+	return b.Prefix
+}
@@ -0,0 +1,93 @@
+// Package redis provides a websocket.Bridge over Redis pub/sub, so the hubs of several
+// server instances broadcast to each other's members.
+//
+// It lives in its own module so the websocket package itself stays free of
+// external dependencies.
+package redis
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/ajsqr/websocket"
+	"github.com/redis/go-redis/v9"
+)
+
+// defaultPrefix is the channel prefix used when Bridge.Prefix is not set.
+const defaultPrefix = "websocket"
+
+// Bridge relays hub broadcasts through Redis channels. Broadcasts to the whole hub are
+// published on the channel named by Prefix, and broadcasts to a room on Prefix:room.
+type Bridge struct {
+	// Client is the connection to Redis.
+	Client redis.UniversalClient
+
+	// Prefix is the name of the channel, and the prefix of the room channels.
+	// It defaults to "websocket".
+	Prefix string
+}
+
+// Publish sends the broadcast on the channel of its room.
+func (b *Bridge) Publish(ctx context.Context, msg websocket.BridgeMessage) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	return b.Client.Publish(ctx, b.Channel(msg.Room), payload).Err()
+}
+
+// Subscribe listens on the channel of the whole hub and of every room until ctx is done.
+// Messages that cannot be decoded are skipped.
+func (b *Bridge) Subscribe(ctx context.Context, handle func(msg websocket.BridgeMessage)) error {
+	prefix := b.prefix()
+	sub := b.Client.PSubscribe(ctx, prefix, prefix+":*")
+	defer sub.Close()
+
+	// wait for the subscription to be confirmed, so broadcasts published afterwards are not missed
+	_, err := sub.Receive(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		return err
+	}
+
+	messages := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case m, ok := <-messages:
+			if !ok {
+				return redis.ErrClosed
+			}
+
+			msg := websocket.BridgeMessage{}
+			err := json.Unmarshal([]byte(m.Payload), &msg)
+			if err != nil {
+				continue
+			}
+
+			handle(msg)
+		}
+	}
+}
+
+// Channel gives the name of the channel broadcasts to the room are published on.
+func (b *Bridge) Channel(room string) string {
+	if room == "" {
+		return b.prefix()
+	}
+
+	return b.prefix() + ":" + room
+}
+
+func (b *Bridge) prefix() string {
+	if b.Prefix == "" {
+		return defaultPrefix
+	}
+
+	return b.Prefix
+}
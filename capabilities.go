@@ -0,0 +1,94 @@
+package websocket
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxMessageSizeHeader    = "X-Websocket-Max-Message-Size"
+	heartbeatIntervalHeader = "X-Websocket-Heartbeat-Interval"
+	codecsHeader            = "X-Websocket-Codecs"
+)
+
+// Capabilities describes the settings a server advertises to its clients
+// in the handshake response, so both ends agree on them without out-of-band
+// configuration.
+type Capabilities struct {
+	// MaxMessageSize is the largest message, in bytes, the server accepts.
+	// Zero advertises the ReadLimit of the WSOpener, or no limit if it has none.
+	MaxMessageSize int64
+
+	// HeartbeatInterval is how often the server expects to hear from the client.
+	// Zero advertises the PingInterval of the WSOpener, or no interval if it has none.
+	HeartbeatInterval time.Duration
+
+	// Codecs lists the message encodings the server understands, in order of preference.
+	Codecs []string
+}
+
+// capabilities gives the capabilities the opener advertises, taking the settings left
+// at zero from its own configuration, so they cannot drift apart from it.
+func (wso *WSOpener) capabilities() *Capabilities {
+	c := *wso.Capabilities
+	if c.MaxMessageSize == 0 {
+		c.MaxMessageSize = wso.ReadLimit
+	}
+
+	if c.HeartbeatInterval == 0 {
+		c.HeartbeatInterval = wso.PingInterval
+	}
+
+	return &c
+}
+
+// writeHeaders adds the advertised capabilities to the handshake response headers.
+func (c *Capabilities) writeHeaders(h http.Header) {
+	if c.MaxMessageSize > 0 {
+		h.Set(maxMessageSizeHeader, strconv.FormatInt(c.MaxMessageSize, 10))
+	}
+
+	if c.HeartbeatInterval > 0 {
+		h.Set(heartbeatIntervalHeader, strconv.FormatInt(c.HeartbeatInterval.Milliseconds(), 10))
+	}
+
+	if len(c.Codecs) > 0 {
+		h.Set(codecsHeader, strings.Join(c.Codecs, ", "))
+	}
+}
+
+// ParseCapabilities reads the capabilities advertised in a handshake response.
+// Capabilities that are absent are left at their zero value.
+func ParseCapabilities(h http.Header) (*Capabilities, error) {
+	c := Capabilities{}
+	if v := h.Get(maxMessageSizeHeader); v != "" {
+		size, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || size < 0 {
+			return nil, InvalidCapability
+		}
+
+		c.MaxMessageSize = size
+	}
+
+	if v := h.Get(heartbeatIntervalHeader); v != "" {
+		ms, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || ms < 0 {
+			return nil, InvalidCapability
+		}
+
+		c.HeartbeatInterval = time.Duration(ms) * time.Millisecond
+	}
+
+	if v := h.Get(codecsHeader); v != "" {
+		for _, codec := range strings.Split(v, ",") {
+			codec = strings.TrimSpace(codec)
+			if codec != "" {
+				c.Codecs = append(c.Codecs, codec)
+			}
+		}
+	}
+
+	return &c, nil
+}
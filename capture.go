@@ -0,0 +1,170 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"sync"
+	"time"
+)
+
+// captureHeaderSize is the size of the header of a capture record: its time in unix
+// nanoseconds, its direction and the length of its data.
+const captureHeaderSize = 8 + 1 + 4
+
+// The directions of capture records.
+const (
+	captureReceived = 0
+	captureSent     = 1
+)
+
+// CaptureRecord is a chunk of the bytes exchanged by a captured connection, as read from
+// or written to the network at once. The data of the records of one direction, put end
+// to end, are the frames as they were on the wire, masked, malformed or not, so they can
+// be parsed or sent to a server again offline.
+type CaptureRecord struct {
+	// Time is when the bytes were read or written.
+	Time time.Time
+
+	// Sent is true for the bytes the server sent, and false for those it received.
+	Sent bool
+
+	Data []byte
+}
+
+// CaptureReader reads the records of a capture written for WSOpener.Capture.
+type CaptureReader struct {
+	r *bufio.Reader
+}
+
+// NewCaptureReader returns a CaptureReader reading the records of a capture from r.
+func NewCaptureReader(r io.Reader) *CaptureReader {
+	return &CaptureReader{r: bufio.NewReader(r)}
+}
+
+// Next reads the next record of the capture. It fails with io.EOF at the end of the
+// capture, and with io.ErrUnexpectedEOF if the capture ends within a record.
+func (cr *CaptureReader) Next() (CaptureRecord, error) {
+	var header [captureHeaderSize]byte
+	_, err := io.ReadFull(cr.r, header[:])
+	if err != nil {
+		return CaptureRecord{}, err
+	}
+
+	data := make([]byte, binary.BigEndian.Uint32(header[9:]))
+	_, err = io.ReadFull(cr.r, data)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+
+	if err != nil {
+		return CaptureRecord{}, err
+	}
+
+	return CaptureRecord{
+		Time: time.Unix(0, int64(binary.BigEndian.Uint64(header[:8]))),
+		Sent: header[8] == captureSent,
+		Data: data,
+	}, nil
+}
+
+// wireCapture writes the records of a captured connection.
+type wireCapture struct {
+	mu     sync.Mutex
+	w      io.Writer
+	failed bool
+}
+
+// record writes the data moved in the direction as records. Once writing the capture
+// failed it is given up, the connection itself being unaffected.
+func (c *wireCapture) record(direction byte, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.failed {
+		return
+	}
+
+	now := time.Now().UnixNano()
+	for len(data) > 0 {
+		chunk := data[:min(len(data), math.MaxUint32)]
+		data = data[len(chunk):]
+
+		var header [captureHeaderSize]byte
+		binary.BigEndian.PutUint64(header[:8], uint64(now))
+		header[8] = direction
+		binary.BigEndian.PutUint32(header[9:], uint32(len(chunk)))
+		_, err := c.w.Write(header[:])
+		if err == nil {
+			_, err = c.w.Write(chunk)
+		}
+
+		if err != nil {
+			c.failed = true
+			return
+		}
+	}
+}
+
+// close closes the writer of the capture, if it is an io.Closer.
+func (c *wireCapture) close() {
+	closer, ok := c.w.(io.Closer)
+	if !ok {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failed = true
+	_ = closer.Close()
+}
+
+// captureReader records the bytes read from a connection.
+type captureReader struct {
+	r io.Reader
+	c *wireCapture
+}
+
+func (cr captureReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	if n > 0 {
+		cr.c.record(captureReceived, p[:n])
+	}
+
+	return n, err
+}
+
+// captureWriter records the bytes written to a connection.
+type captureWriter struct {
+	w io.Writer
+	c *wireCapture
+}
+
+func (cw captureWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	if n > 0 {
+		cw.c.record(captureSent, p[:n])
+	}
+
+	return n, err
+}
+
+// startCapture makes the connection, whose handshake was just written, record the bytes
+// it exchanges from now on to w. The bytes the client sent after its request and that
+// were already buffered are recorded first.
+func (ws *Websocket) startCapture(w io.Writer) error {
+	buffered, err := ws.reader.Peek(ws.reader.Buffered())
+	if err != nil {
+		return err
+	}
+
+	c := &wireCapture{w: w}
+	pipelined := bytes.Clone(buffered)
+	c.record(captureReceived, pipelined)
+
+	ws.reader = bufio.NewReaderSize(io.MultiReader(bytes.NewReader(pipelined), captureReader{r: ws.conn, c: c}), ws.reader.Size())
+	ws.writer = bufio.NewWriterSize(captureWriter{w: ws.conn, c: c}, ws.writer.Size())
+	ws.capture = c
+	return nil
+}
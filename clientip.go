@@ -0,0 +1,77 @@
+package websocket
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// RemoteIP gives the IP address of the peer of the request's connection.
+// It is the default WSOpener.ClientIP.
+func RemoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// ForwardedIP returns a WSOpener.ClientIP function for servers behind reverse proxies.
+// When the request comes from one of the trusted proxies, the client is the last
+// address of the X-Forwarded-For header that is not itself a trusted proxy; otherwise
+// the header could be forged and the peer address is used, as RemoteIP does.
+func ForwardedIP(trusted ...netip.Prefix) func(r *http.Request) string {
+	isTrusted := func(ip string) bool {
+		addr, err := netip.ParseAddr(ip)
+		if err != nil {
+			return false
+		}
+
+		addr = addr.Unmap()
+		for _, prefix := range trusted {
+			if prefix.Contains(addr) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return func(r *http.Request) string {
+		ip := RemoteIP(r)
+		if !isTrusted(ip) {
+			return ip
+		}
+
+		var hops []string
+		for _, header := range r.Header.Values("X-Forwarded-For") {
+			hops = append(hops, strings.Split(header, ",")...)
+		}
+
+		// proxies append the address they received the request from, so walk back from the closest
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if hop == "" {
+				continue
+			}
+
+			ip = hop
+			if !isTrusted(hop) {
+				break
+			}
+		}
+
+		return ip
+	}
+}
+
+// clientIP gives the IP address of the client that sent the upgrade request.
+func (wso *WSOpener) clientIP(r *http.Request) string {
+	if wso.ClientIP != nil {
+		return wso.ClientIP(r)
+	}
+
+	return RemoteIP(r)
+}
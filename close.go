@@ -0,0 +1,168 @@
+package websocket
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"unicode/utf8"
+)
+
+// CloseCode is the status code carried in the body of a Close frame.
+// See Section 7.4 of RFC 6455.
+type CloseCode int
+
+var (
+	// NormalClosure indicates that the purpose for which the connection was established has been fulfilled.
+	NormalClosure CloseCode = 1000
+
+	// GoingAway indicates that an endpoint is going away, such as a server going down.
+	GoingAway CloseCode = 1001
+
+	// ProtocolError indicates that an endpoint is terminating the connection due to a protocol error.
+	ProtocolError CloseCode = 1002
+
+	// UnsupportedData indicates that an endpoint received a type of data it cannot accept.
+	UnsupportedData CloseCode = 1003
+
+	// NoStatusReceived is reported when a Close frame carried no status code. It is never sent.
+	NoStatusReceived CloseCode = 1005
+
+	// AbnormalClosure is reported when the connection was lost without a Close frame. It is never sent.
+	AbnormalClosure CloseCode = 1006
+
+	// InvalidPayloadData indicates that an endpoint received data inconsistent with the type of the message.
+	InvalidPayloadData CloseCode = 1007
+
+	// PolicyViolation indicates that an endpoint received a message that violates its policy.
+	PolicyViolation CloseCode = 1008
+
+	// MessageTooBig indicates that an endpoint received a message too big for it to process.
+	MessageTooBig CloseCode = 1009
+
+	// MandatoryExtension indicates that the client expected the server to negotiate an extension.
+	MandatoryExtension CloseCode = 1010
+
+	// InternalServerError indicates that the server encountered an unexpected condition.
+	InternalServerError CloseCode = 1011
+
+	// TryAgainLater indicates that the server is overloaded and the client should reconnect later.
+	TryAgainLater CloseCode = 1013
+)
+
+// CloseError is returned when the peer closes the connection with a Close frame.
+type CloseError struct {
+	// Code is the status code sent by the peer, or NoStatusReceived if there was none.
+	Code CloseCode
+
+	// Reason is the UTF-8 reason sent by the peer, if any.
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("websocket closed: %d", e.Code)
+	}
+
+	return fmt.Sprintf("websocket closed: %d %s", e.Code, e.Reason)
+}
+
+// parseClosePayload reads the status code and reason out of a Close frame payload.
+func parseClosePayload(payload []byte) (*CloseError, error) {
+	switch {
+	case len(payload) == 0:
+		return &CloseError{Code: NoStatusReceived}, nil
+	case len(payload) == 1:
+		return nil, InvalidLength
+	}
+
+	return &CloseError{
+		Code:   CloseCode(binary.BigEndian.Uint16(payload[:2])),
+		Reason: string(payload[2:]),
+	}, nil
+}
+
+// CloseWithCode sends a Close frame carrying the status code and reason, then closes
+// the underlying connection. The reason must be valid UTF-8 of at most 123 bytes.
+// Codes that are reserved for reporting, such as NoStatusReceived and AbnormalClosure,
+// cannot be sent. It is safe to call concurrently with Send and Ping.
+func (ws *Websocket) CloseWithCode(code CloseCode, reason string) error {
+	if !code.sendable() {
+		return InvalidCloseCode
+	}
+
+	if !utf8.ValidString(reason) {
+		return InvalidCloseReason
+	}
+
+	// checked before the state changes, so a reason too long leaves the connection open
+	if len(reason) > 123 {
+		return InvalidLength
+	}
+
+	if !ws.setState(StateClosing, code, nil) {
+		// a Close frame was already sent or received, only the connection is left to close
+		if ws.State() == StateClosed {
+			return ErrConnectionClosed
+		}
+
+		return ws.terminate(code, nil)
+	}
+
+	err := ws.writeClose(code, reason)
+	closeErr := ws.terminate(code, nil)
+	if err != nil {
+		return err
+	}
+
+	return closeErr
+}
+
+// startClose starts the closing handshake by sending a Close frame, leaving the
+// connection open until the peer answers with its own Close frame, which is read like
+// any other frame. If the Close frame cannot be sent the connection is closed.
+func (ws *Websocket) startClose(code CloseCode, reason string) {
+	if !ws.setState(StateClosing, code, nil) {
+		return
+	}
+
+	err := ws.writeClose(code, reason)
+	if err != nil {
+		_ = ws.terminate(code, err)
+	}
+}
+
+// sendable reports whether the code may be sent in a Close frame.
+// See Section 7.4 of RFC 6455.
+func (c CloseCode) sendable() bool {
+	switch {
+	case c < 1000 || c > 4999:
+		return false
+	case c == 1004 || c == NoStatusReceived || c == AbnormalClosure || c == 1015:
+		// reserved, or only meant to report a condition locally
+		return false
+	case c >= 1016 && c < 3000:
+		// reserved for future revisions of the protocol
+		return false
+	default:
+		return true
+	}
+}
+
+// writeClose sends a Close frame carrying the status code and reason.
+// The reason must fit, with the code, in the 125 bytes allowed for control frames.
+func (ws *Websocket) writeClose(code CloseCode, reason string) error {
+	if len(reason) > 123 {
+		return InvalidLength
+	}
+
+	if ws.eventStream {
+		// the stream just ends, there is no closing handshake
+		return nil
+	}
+
+	payload := make([]byte, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	copy(payload[2:], reason)
+
+	return ws.writeControl(context.Background(), ConnectionClose, payload)
+}
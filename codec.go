@@ -0,0 +1,116 @@
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+)
+
+// Codec defines how values are serialized into messages and back.
+type Codec interface {
+	// Marshal encodes v and gives the type of message it should be sent as.
+	Marshal(v any) ([]byte, MessageType, error)
+
+	// Unmarshal decodes the payload of a message into v.
+	Unmarshal(data []byte, v any) error
+}
+
+// JSONCodec encodes values as JSON text messages.
+type JSONCodec struct {
+	Options JSONOptions
+}
+
+// Marshal encodes v as JSON.
+func (c JSONCodec) Marshal(v any) ([]byte, MessageType, error) {
+	buf := bytes.Buffer{}
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(!c.Options.DisableHTMLEscape)
+	if c.Options.Indent != "" {
+		encoder.SetIndent("", c.Options.Indent)
+	}
+
+	err := encoder.Encode(v)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// the encoder terminates every value with a newline, which is not part of the message
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), TextMessage, nil
+}
+
+// Unmarshal decodes JSON data into v.
+func (c JSONCodec) Unmarshal(data []byte, v any) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if c.Options.DisallowUnknownFields {
+		decoder.DisallowUnknownFields()
+	}
+
+	if c.Options.UseNumber {
+		decoder.UseNumber()
+	}
+
+	return decoder.Decode(v)
+}
+
+// SetCodec replaces the codec used by SendValue and ReceiveValue.
+func (ws *Websocket) SetCodec(c Codec) {
+	ws.codec = c
+}
+
+// SendValue encodes v with the codec of the connection and sends it as a single message.
+// Connections without a codec use JSONCodec with the connection's JSON options.
+func (ws *Websocket) SendValue(ctx context.Context, v any) error {
+	return ws.sendValue(ctx, ws.valueCodec(), v)
+}
+
+// ReceiveValue waits for the next message and decodes it into v with the codec of the connection.
+// Connections without a codec use JSONCodec with the connection's JSON options.
+func (ws *Websocket) ReceiveValue(ctx context.Context, v any) error {
+	return ws.receiveValue(ctx, ws.valueCodec(), v)
+}
+
+// sendValue encodes v with c and sends it as a single message.
+func (ws *Websocket) sendValue(ctx context.Context, c Codec, v any) error {
+	data, t, err := c.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	opcode, err := t.opcode()
+	if err != nil {
+		return err
+	}
+
+	return ws.send(ctx, opcode, data)
+}
+
+// receiveValue waits for the next message and decodes it into v with c.
+func (ws *Websocket) receiveValue(ctx context.Context, c Codec, v any) (err error) {
+	if ws.State() == StateClosed {
+		return ErrConnectionClosed
+	}
+
+	defer ws.checkError(&err)
+	defer ws.readDeadline.bind(ctx)(&err)
+	_, r, err := ws.nextReader()
+	if err != nil {
+		return err
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	return c.Unmarshal(data, v)
+}
+
+// valueCodec gives the codec used for SendValue and ReceiveValue.
+func (ws *Websocket) valueCodec() Codec {
+	if ws.codec != nil {
+		return ws.codec
+	}
+
+	return JSONCodec{Options: ws.jsonOptions}
+}
@@ -0,0 +1,48 @@
+// Package cbor provides a websocket.Codec for CBOR (RFC 8949).
+//
+// It lives in its own module so the websocket package itself stays free of
+// external dependencies.
+package cbor
+
+import (
+	"github.com/ajsqr/websocket"
+	"github.com/fxamacker/cbor/v2"
+)
+
+// Codec encodes values as CBOR binary messages.
+// The zero value uses the default encoding and decoding options of fxamacker/cbor.
+type Codec struct {
+	// EncMode, if set, overrides the encoding options, e.g. cbor.CoreDetEncOptions for deterministic output.
+	EncMode cbor.EncMode
+
+	// DecMode, if set, overrides the decoding options.
+	DecMode cbor.DecMode
+}
+
+// Marshal encodes v as CBOR.
+func (c Codec) Marshal(v any) ([]byte, websocket.MessageType, error) {
+	var data []byte
+	var err error
+	if c.EncMode != nil {
+		data, err = c.EncMode.Marshal(v)
+	} else {
+		data, err = cbor.Marshal(v)
+	}
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	return data, websocket.BinaryMessage, nil
+}
+
+// Unmarshal decodes CBOR data into v.
+func (c Codec) Unmarshal(data []byte, v any) error {
+	if c.DecMode != nil {
+		return c.DecMode.Unmarshal(data, v)
+	}
+
+	return cbor.Unmarshal(data, v)
+}
+
+var _ websocket.Codec = Codec{}
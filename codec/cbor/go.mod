@@ -0,0 +1,12 @@
+module github.com/ajsqr/websocket/codec/cbor
+
+go 1.23.4
+
+require (
+	github.com/ajsqr/websocket v0.0.0
+	github.com/fxamacker/cbor/v2 v2.9.4
+)
+
+require github.com/x448/float16 v0.8.4 // indirect
+
+replace github.com/ajsqr/websocket => ../../
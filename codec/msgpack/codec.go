@@ -0,0 +1,46 @@
+// Package msgpack provides a websocket.Codec for MessagePack.
+//
+// It lives in its own module so the websocket package itself stays free of
+// external dependencies.
+package msgpack
+
+import (
+	"bytes"
+
+	"github.com/ajsqr/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec encodes values as MessagePack binary messages.
+type Codec struct {
+	// UseJSONTags makes struct fields fall back to their json tags when they have no msgpack tag.
+	UseJSONTags bool
+}
+
+// Marshal encodes v as MessagePack.
+func (c Codec) Marshal(v any) ([]byte, websocket.MessageType, error) {
+	buf := bytes.Buffer{}
+	encoder := msgpack.NewEncoder(&buf)
+	if c.UseJSONTags {
+		encoder.SetCustomStructTag("json")
+	}
+
+	err := encoder.Encode(v)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return buf.Bytes(), websocket.BinaryMessage, nil
+}
+
+// Unmarshal decodes MessagePack data into v.
+func (c Codec) Unmarshal(data []byte, v any) error {
+	decoder := msgpack.NewDecoder(bytes.NewReader(data))
+	if c.UseJSONTags {
+		decoder.SetCustomStructTag("json")
+	}
+
+	return decoder.Decode(v)
+}
+
+var _ websocket.Codec = Codec{}
@@ -0,0 +1,12 @@
+module github.com/ajsqr/websocket/codec/msgpack
+
+go 1.23.4
+
+require (
+	github.com/ajsqr/websocket v0.0.0
+	github.com/vmihailenco/msgpack/v5 v5.4.1
+)
+
+require github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
+
+replace github.com/ajsqr/websocket => ../../
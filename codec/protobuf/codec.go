@@ -0,0 +1,59 @@
+// Package protobuf provides a websocket.Codec for protocol buffer messages.
+//
+// It lives in its own module so the websocket package itself stays free of
+// external dependencies.
+package protobuf
+
+import (
+	"errors"
+
+	"github.com/ajsqr/websocket"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	NotProtoMessage = errors.New("value does not implement proto.Message")
+)
+
+// Codec marshals proto.Message values. By default they are sent as binary messages
+// in the protobuf wire format; with JSON set they are sent as protojson text messages.
+type Codec struct {
+	// JSON selects the protojson encoding over the binary wire format.
+	JSON bool
+
+	// DiscardUnknown drops unknown fields instead of failing (protojson) or keeping them (binary).
+	DiscardUnknown bool
+}
+
+// Marshal encodes v, which must be a proto.Message.
+func (c Codec) Marshal(v any) ([]byte, websocket.MessageType, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, "", NotProtoMessage
+	}
+
+	if c.JSON {
+		data, err := protojson.Marshal(m)
+		return data, websocket.TextMessage, err
+	}
+
+	data, err := proto.Marshal(m)
+	return data, websocket.BinaryMessage, err
+}
+
+// Unmarshal decodes data into v, which must be a proto.Message.
+func (c Codec) Unmarshal(data []byte, v any) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return NotProtoMessage
+	}
+
+	if c.JSON {
+		return protojson.UnmarshalOptions{DiscardUnknown: c.DiscardUnknown}.Unmarshal(data, m)
+	}
+
+	return proto.UnmarshalOptions{DiscardUnknown: c.DiscardUnknown}.Unmarshal(data, m)
+}
+
+var _ websocket.Codec = Codec{}
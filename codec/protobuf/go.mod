@@ -0,0 +1,10 @@
+module github.com/ajsqr/websocket/codec/protobuf
+
+go 1.23.4
+
+require (
+	github.com/ajsqr/websocket v0.0.0
+	google.golang.org/protobuf v1.36.5
+)
+
+replace github.com/ajsqr/websocket => ../../
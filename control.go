@@ -0,0 +1,124 @@
+package websocket
+
+import (
+	"context"
+	"encoding/binary"
+)
+
+// Ping sends a Ping frame carrying up to 125 bytes of application data.
+// It is safe to call concurrently with Send and Close.
+func (ws *Websocket) Ping(ctx context.Context, data []byte) (err error) {
+	if ws.State() != StateOpen {
+		return ErrConnectionClosed
+	}
+
+	defer ws.checkError(&err)
+	return ws.writeControl(ctx, Ping, data)
+}
+
+// SetPingHandler sets the function called with the application data of every Ping
+// frame received while reading messages. The default handler answers with a Pong
+// carrying the same data; a custom handler is responsible for that itself.
+// An error returned by the handler fails the read. A nil handler restores the default.
+func (ws *Websocket) SetPingHandler(h func(appData []byte) error) {
+	ws.pingHandler = h
+}
+
+// SetPongHandler sets the function called with the application data of every Pong
+// frame received while reading messages. The default handler does nothing.
+// An error returned by the handler fails the read. A nil handler restores the default.
+func (ws *Websocket) SetPongHandler(h func(appData []byte) error) {
+	ws.pongHandler = h
+}
+
+// SetCloseHandler sets the function called with the status code and reason of a Close
+// frame received while reading messages. The default handler answers with a Close frame
+// echoing the status code; a custom handler is responsible for that itself.
+// The read then fails with a *CloseError, or with the error returned by the handler.
+// A nil handler restores the default.
+func (ws *Websocket) SetCloseHandler(h func(code CloseCode, reason string) error) {
+	ws.closeHandler = h
+}
+
+// handleControl dispatches a control frame, whose payload has been read, to its handler.
+// A Close frame always results in an error, since the peer will send no further messages.
+func (ws *Websocket) handleControl(frame *Frame) error {
+	switch frame.Opcode {
+	case Ping:
+		if ws.pingHandler != nil {
+			return ws.pingHandler(frame.ApplicationData)
+		}
+
+		return ws.writeControl(context.Background(), Pong, frame.ApplicationData)
+	case Pong:
+		ws.matchPong(frame.ApplicationData)
+		if ws.pongHandler != nil {
+			return ws.pongHandler(frame.ApplicationData)
+		}
+
+		return nil
+	case ConnectionClose:
+		closeErr, err := parseClosePayload(frame.ApplicationData)
+		if err != nil {
+			return err
+		}
+
+		if !ws.setState(StateClosing, closeErr.Code, closeErr) {
+			// the peer is answering the Close frame we sent, the closing handshake is complete
+			return closeErr
+		}
+
+		if ws.closeHandler != nil {
+			err = ws.closeHandler(closeErr.Code, closeErr.Reason)
+		} else {
+			err = ws.echoClose(closeErr.Code)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		return closeErr
+	default:
+		return InvalidOpcode
+	}
+}
+
+// echoClose completes the closing handshake started by the peer by answering its Close frame.
+func (ws *Websocket) echoClose(code CloseCode) error {
+	if code == NoStatusReceived {
+		// the peer sent no status, and NoStatusReceived must not be sent on the wire
+		return ws.writeControl(context.Background(), ConnectionClose, nil)
+	}
+
+	payload := make([]byte, 2)
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	return ws.writeControl(context.Background(), ConnectionClose, payload)
+}
+
+// writeControl sends a single control frame carrying the payload, within the deadline of
+// ctx. It does not wait for queued messages, nor for the remaining fragments of a message
+// being sent.
+func (ws *Websocket) writeControl(ctx context.Context, opcode Opcode, payload []byte) (err error) {
+	if len(payload) > 125 {
+		return InvalidLength
+	}
+
+	frame := getFrame()
+	defer putFrame(frame)
+	frame.FIN = true
+	frame.Opcode = opcode
+	frame.ApplicationData = payload
+	err = frame.setPayloadLength(len(payload))
+	if err != nil {
+		return err
+	}
+
+	unlock, err := ws.lockWrite(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer unlock(&err)
+	return ws.writeFrame(frame)
+}
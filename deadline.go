@@ -0,0 +1,159 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// aLongTimeAgo is a deadline in the past, used to interrupt a blocked read or write.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// SetReadDeadline sets the deadline for reads from the underlying connection.
+// A zero value means reads do not time out. Once a read times out the connection
+// may be positioned in the middle of a frame and should be closed.
+func (ws *Websocket) SetReadDeadline(t time.Time) error {
+	return ws.readDeadline.setDeadline(t)
+}
+
+// SetWriteDeadline sets the deadline for writes to the underlying connection.
+// A zero value means writes do not time out. Once a write times out the peer
+// may have received part of a frame, so the connection is closed.
+func (ws *Websocket) SetWriteDeadline(t time.Time) error {
+	return ws.writeDeadline.setDeadline(t)
+}
+
+// SetDeadline sets both the read and the write deadline.
+func (ws *Websocket) SetDeadline(t time.Time) error {
+	err := ws.SetReadDeadline(t)
+	if err != nil {
+		return err
+	}
+
+	return ws.SetWriteDeadline(t)
+}
+
+// lockWrite takes writeMu and binds the write deadline to ctx for the frame about to be
+// written. If ctx is done before writeMu is free, because another frame is still being
+// written, it fails without writing anything. The returned function restores the
+// deadline, as bind does, and releases writeMu.
+func (ws *Websocket) lockWrite(ctx context.Context) (unlock func(err *error), err error) {
+	switch {
+	case ctx.Done() == nil:
+		ws.writeMu.Lock()
+	case ws.writeMu.TryLock():
+	default:
+		locked := make(chan struct{})
+		go func() {
+			ws.writeMu.Lock()
+			close(locked)
+		}()
+
+		select {
+		case <-locked:
+		case <-ctx.Done():
+			go func() {
+				<-locked
+				ws.writeMu.Unlock()
+			}()
+
+			return nil, ctx.Err()
+		}
+	}
+
+	err = ctx.Err()
+	if err != nil {
+		ws.writeMu.Unlock()
+		return nil, err
+	}
+
+	restore := ws.writeDeadline.bind(ctx)
+	return func(err *error) {
+		restore(err)
+		ws.writeMu.Unlock()
+	}, nil
+}
+
+// deadline manages one direction of the connection's deadline, combining the
+// deadline set by the user with the deadline and cancellation of the context
+// passed to each call.
+type deadline struct {
+	mu sync.Mutex
+
+	// set applies a deadline to the underlying connection, nil if there is none.
+	set func(time.Time) error
+
+	// t is the deadline set by the user.
+	t time.Time
+}
+
+func newDeadline(set func(time.Time) error) *deadline {
+	return &deadline{set: set}
+}
+
+func (d *deadline) setDeadline(t time.Time) error {
+	if d == nil || d.set == nil {
+		return DeadlinesNotSupported
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.t = t
+	return d.set(t)
+}
+
+// bind applies the deadline and cancellation of ctx to the connection until the
+// returned function is called. The returned function restores the user deadline
+// and replaces a timeout caused by ctx in *err with the error of ctx. The write
+// deadline is only bound with writeMu held, around the write of a single frame, so
+// the context of a call never interrupts the frames of another.
+func (d *deadline) bind(ctx context.Context) func(err *error) {
+	if d == nil || d.set == nil {
+		return func(err *error) {}
+	}
+
+	t, hasDeadline := ctx.Deadline()
+	if hasDeadline {
+		d.mu.Lock()
+		if d.t.IsZero() || t.Before(d.t) {
+			_ = d.set(t)
+		}
+		d.mu.Unlock()
+	}
+
+	if ctx.Done() == nil && !hasDeadline {
+		return func(err *error) {}
+	}
+
+	fired := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		defer close(fired)
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		_ = d.set(aLongTimeAgo)
+	})
+
+	return func(err *error) {
+		if !stop() && ctx.Err() != nil {
+			// the interruption may still be in flight, it must not outlive the call
+			<-fired
+		}
+
+		d.mu.Lock()
+		_ = d.set(d.t)
+		d.mu.Unlock()
+
+		if *err == nil || !errors.Is(*err, os.ErrDeadlineExceeded) {
+			return
+		}
+
+		if ctx.Err() != nil {
+			*err = ctx.Err()
+		} else if hasDeadline && !time.Now().Before(t) {
+			// the connection deadline can fire a moment before the context notices its own
+			*err = context.DeadlineExceeded
+		}
+	}
+}
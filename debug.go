@@ -0,0 +1,142 @@
+package websocket
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ConnectionInfo describes an open connection, as listed by ConnectionManager.DebugHandler.
+type ConnectionInfo struct {
+	ID          string          `json:"id"`
+	RemoteAddr  string          `json:"remote_addr"`
+	Subprotocol string          `json:"subprotocol"`
+	State       ConnectionState `json:"state"`
+
+	// Opened is when the connection was opened, and Uptime how long ago that was.
+	Opened time.Time `json:"opened"`
+	Uptime string    `json:"uptime"`
+
+	// LastActivity is when application data was last sent or received.
+	LastActivity time.Time `json:"last_activity"`
+
+	// QueueDepth and WriteLatency are the send statistics of the connection.
+	QueueDepth   int    `json:"queue_depth"`
+	WriteLatency string `json:"write_latency"`
+}
+
+// debugPage renders the connections listed by DebugHandler as HTML.
+var debugPage = template.Must(template.New("connections").Parse(`<!DOCTYPE html>
+<html>
+<head><title>websocket connections</title></head>
+<body>
+<h1>{{len .Conns}} websocket connections</h1>
+<table border="1" cellpadding="4">
+<tr><th>ID</th><th>Remote address</th><th>Subprotocol</th><th>State</th><th>Uptime</th><th>Last activity</th><th>Queue depth</th><th>Write latency</th>{{if .AllowClose}}<th></th>{{end}}</tr>
+{{range .Conns}}<tr><td>{{.ID}}</td><td>{{.RemoteAddr}}</td><td>{{.Subprotocol}}</td><td>{{.State}}</td><td>{{.Uptime}}</td><td>{{.LastActivity.Format "2006-01-02 15:04:05.000"}}</td><td>{{.QueueDepth}}</td><td>{{.WriteLatency}}</td>{{if $.AllowClose}}<td><form method="post"><input type="hidden" name="id" value="{{.ID}}"><button>Close</button></form></td>{{end}}</tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// Connections describes the open connections, oldest first.
+func (m *ConnectionManager) Connections() []ConnectionInfo {
+	type entry struct {
+		ws     *Websocket
+		opened time.Time
+	}
+
+	m.mu.Lock()
+	entries := make([]entry, 0, len(m.conns))
+	for ws, c := range m.conns {
+		entries = append(entries, entry{ws: ws, opened: c.opened})
+	}
+	m.mu.Unlock()
+
+	slices.SortFunc(entries, func(a, b entry) int {
+		return a.opened.Compare(b.opened)
+	})
+
+	now := time.Now()
+	conns := make([]ConnectionInfo, 0, len(entries))
+	for _, e := range entries {
+		stats := e.ws.SendStats()
+		conns = append(conns, ConnectionInfo{
+			ID:           e.ws.ID(),
+			RemoteAddr:   e.ws.remoteAddr(),
+			Subprotocol:  e.ws.Subprotocol(),
+			State:        e.ws.State(),
+			Opened:       e.opened,
+			Uptime:       now.Sub(e.opened).Round(time.Millisecond).String(),
+			LastActivity: time.Unix(0, e.ws.lastActivity.Load()),
+			QueueDepth:   stats.QueueDepth,
+			WriteLatency: stats.WriteLatency.Round(time.Microsecond).String(),
+		})
+	}
+
+	return conns
+}
+
+// DebugHandler returns an http.Handler listing the open connections for live
+// troubleshooting, as HTML, or as JSON for requests accepting application/json or with
+// ?format=json. If allowClose is set, POST and DELETE requests close the connection whose
+// ID is given by their id form value with GoingAway, and the HTML page has a button to
+// close each connection. The handler exposes the addresses of clients and lets anyone
+// reaching it close their connections, so it must only be served to operators.
+func (m *ConnectionManager) DebugHandler(allowClose bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+		case http.MethodPost, http.MethodDelete:
+			if allowClose {
+				m.debugClose(w, r)
+				return
+			}
+
+			fallthrough
+		default:
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		conns := m.Connections()
+		if wantsJSON(r) {
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(conns)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_ = debugPage.Execute(w, struct {
+			Conns      []ConnectionInfo
+			AllowClose bool
+		}{conns, allowClose})
+	})
+}
+
+// debugClose closes the connection whose ID is the id form value of the request.
+func (m *ConnectionManager) debugClose(w http.ResponseWriter, r *http.Request) {
+	ws, ok := m.Lookup(r.FormValue("id"))
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	// the connection is closed either way, whether the client heard why does not matter
+	_ = ws.CloseWithCode(GoingAway, "closed by an operator")
+	if r.Method == http.MethodPost && !wantsJSON(r) {
+		// back to the list the close button was on
+		http.Redirect(w, r, r.RequestURI, http.StatusSeeOther)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// wantsJSON reports whether the request asks for JSON rather than HTML.
+func wantsJSON(r *http.Request) bool {
+	return r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json")
+}
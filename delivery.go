@@ -0,0 +1,229 @@
+package websocket
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultAttemptTimeout is how long Deliver waits for each acknowledgment when
+	// AttemptTimeout is not given.
+	defaultAttemptTimeout = 5 * time.Second
+
+	// defaultDedupWindow is how long a Deduplicator remembers events when Window is not set.
+	defaultDedupWindow = 5 * time.Minute
+
+	// defaultDedupSize is the number of events a Deduplicator remembers when Size is not set.
+	defaultDedupSize = 10000
+)
+
+// DeliveryOption customizes a single call to Deliver.
+type DeliveryOption func(*deliveryConfig)
+
+// deliveryConfig is the configuration of a call to Deliver.
+type deliveryConfig struct {
+	id       string
+	timeout  time.Duration
+	attempts int
+}
+
+// WithMessageID sends the event under the given ID instead of a random one, so an
+// application redelivering it on another connection, for example after a reconnection,
+// has it recognized as the same event. IDs must be unique for each event.
+func WithMessageID(id string) DeliveryOption {
+	return func(d *deliveryConfig) {
+		d.id = id
+	}
+}
+
+// AttemptTimeout sets how long each attempt waits for the acknowledgment before the event
+// is sent again. It defaults to 5 seconds.
+func AttemptTimeout(timeout time.Duration) DeliveryOption {
+	return func(d *deliveryConfig) {
+		d.timeout = timeout
+	}
+}
+
+// MaxAttempts limits the number of times the event is sent. By default it is sent again
+// until it is acknowledged or ctx is done.
+func MaxAttempts(n int) DeliveryOption {
+	return func(d *deliveryConfig) {
+		d.attempts = n
+	}
+}
+
+// Deliver sends an envelope of type t carrying the JSON encoding of v, like SendEventAck,
+// and sends it again under the same ID until the peer acknowledges it, so the event is
+// received at least once. A peer dispatching with a Router whose Dedup is set handles it
+// only once. Deliver fails with AckTimeout once ctx is done or the attempts are exhausted,
+// and with ErrConnectionClosed if the connection closes first.
+func (ws *Websocket) Deliver(ctx context.Context, t string, v any, opts ...DeliveryOption) error {
+	d := deliveryConfig{id: randomID(), timeout: defaultAttemptTimeout}
+	for _, opt := range opts {
+		opt(&d)
+	}
+
+	data, _, err := JSONCodec{Options: ws.jsonOptions}.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	reply := ws.replies.expect(d.id)
+	defer ws.replies.forget(d.id)
+	envelope := Envelope{Type: t, ID: d.id, Ack: true, Data: data}
+	for attempt := 1; ; attempt++ {
+		err = ws.WriteJSON(ctx, envelope)
+		if err != nil {
+			if ctx.Err() != nil {
+				return fmt.Errorf("%w: %w", AckTimeout, err)
+			}
+
+			return err
+		}
+
+		timer := time.NewTimer(d.timeout)
+		select {
+		case <-reply:
+			timer.Stop()
+			return nil
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%w: %w", AckTimeout, ctx.Err())
+		case <-ws.done:
+			timer.Stop()
+			return ErrConnectionClosed
+		case <-timer.C:
+		}
+
+		if d.attempts > 0 && attempt >= d.attempts {
+			return AckTimeout
+		}
+	}
+}
+
+// Deduplicator remembers the IDs of the events a Router handled, so events redelivered
+// by Deliver are acknowledged again without being handled twice. It is safe to share
+// between routers and connections.
+type Deduplicator struct {
+	// Window is how long an event is remembered after it was handled. It defaults to
+	// five minutes, and should exceed the time senders keep redelivering.
+	Window time.Duration
+
+	// Size is the number of events remembered, the oldest being forgotten first.
+	// It defaults to 10000.
+	Size int
+
+	// Scope, if set, gives the scope IDs are unique within for the connection, such as
+	// the authenticated user, so events redelivered on a new connection of the same user
+	// are recognized. By default IDs are only compared within a connection, so peers
+	// cannot suppress the events of each other.
+	Scope func(ws *Websocket) string
+
+	mu sync.Mutex
+	// handling are the events whose handler is running, by key.
+	handling map[string]struct{}
+	// handled are the events handled, by key, and order them oldest first.
+	handled map[string]*list.Element
+	order   list.List
+}
+
+// handledEvent is an event a Deduplicator remembers.
+type handledEvent struct {
+	key string
+	at  time.Time
+}
+
+// The states in which a Deduplicator finds an event.
+const (
+	// eventNew is an event not seen before, or whose handler failed.
+	eventNew = iota
+
+	// eventHandling is an event whose handler is still running; its acknowledgment
+	// is left to the first delivery.
+	eventHandling
+
+	// eventHandled is an event handled already, which is only acknowledged again.
+	eventHandled
+)
+
+// NewDeduplicator returns a Deduplicator remembering no events.
+func NewDeduplicator() *Deduplicator {
+	return &Deduplicator{
+		handling: map[string]struct{}{},
+		handled:  map[string]*list.Element{},
+	}
+}
+
+// key gives the key under which the event is remembered.
+func (dd *Deduplicator) key(e *Event) string {
+	scope := e.Conn.ID()
+	if dd.Scope != nil {
+		scope = dd.Scope(e.Conn)
+	}
+
+	return scope + "\x00" + e.ID
+}
+
+// begin reports the state of the event, marking new events as being handled.
+func (dd *Deduplicator) begin(key string) int {
+	dd.mu.Lock()
+	defer dd.mu.Unlock()
+	if dd.handling == nil {
+		dd.handling = map[string]struct{}{}
+		dd.handled = map[string]*list.Element{}
+	}
+
+	dd.expire(time.Now())
+	if _, ok := dd.handled[key]; ok {
+		return eventHandled
+	}
+
+	if _, ok := dd.handling[key]; ok {
+		return eventHandling
+	}
+
+	dd.handling[key] = struct{}{}
+	return eventNew
+}
+
+// end records the outcome of handling the event; events whose handler failed are
+// forgotten, so their redelivery is handled again.
+func (dd *Deduplicator) end(key string, handled bool) {
+	dd.mu.Lock()
+	defer dd.mu.Unlock()
+	delete(dd.handling, key)
+	if !handled {
+		return
+	}
+
+	dd.handled[key] = dd.order.PushBack(handledEvent{key: key, at: time.Now()})
+	size := dd.Size
+	if size <= 0 {
+		size = defaultDedupSize
+	}
+
+	for dd.order.Len() > size {
+		dd.forget(dd.order.Front())
+	}
+}
+
+// expire forgets the events handled before the window. dd.mu must be held.
+func (dd *Deduplicator) expire(now time.Time) {
+	window := dd.Window
+	if window <= 0 {
+		window = defaultDedupWindow
+	}
+
+	for e := dd.order.Front(); e != nil && now.Sub(e.Value.(handledEvent).at) > window; e = dd.order.Front() {
+		dd.forget(e)
+	}
+}
+
+// forget forgets a handled event. dd.mu must be held.
+func (dd *Deduplicator) forget(e *list.Element) {
+	dd.order.Remove(e)
+	delete(dd.handled, e.Value.(handledEvent).key)
+}
@@ -0,0 +1,138 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// defaultStoredMessages is the number of messages MemoryMessageStore keeps for every room
+// when its Limit is not set.
+const defaultStoredMessages = 1000
+
+// StoredMessage is a message published with Hub.PublishDurable, as kept by a MessageStore.
+type StoredMessage struct {
+	// ID identifies the message, so that a publication retried after an error is stored
+	// and delivered once.
+	ID string `json:"id"`
+
+	// Type and Data are the message.
+	Type MessageType `json:"type"`
+	Data []byte      `json:"data"`
+
+	// Time is when the message was published.
+	Time time.Time `json:"time"`
+}
+
+// MessageStore durably keeps the messages published with Hub.PublishDurable, so they
+// survive the members they are broadcast to, for example for consumers catching up later.
+type MessageStore interface {
+	// Append stores the message published to the room, and returns once it is durable.
+	// It fails with DuplicateMessage if a message with the same ID is already stored.
+	Append(ctx context.Context, room string, msg StoredMessage) error
+}
+
+// WithMessageStore makes the hub keep the messages published with PublishDurable in store.
+func WithMessageStore(store MessageStore) HubOption {
+	return func(h *Hub) {
+		h.store = store
+	}
+}
+
+// PublishDurable stores the message in the MessageStore of the hub, then broadcasts it
+// to the room, as BroadcastToRoom does. It returns nil only once the store acknowledged
+// the message, so producers get a durability guarantee whether or not the room has
+// members: an error from the store means the message was not broadcast either, and an
+// error after it was stored is that of the broadcast.
+//
+// Publishing is safe to retry after an error with the same msg.ID: a message already
+// stored is not broadcast again, and PublishDurable returns nil. A message without an ID
+// is given a random one, and is not retry safe. It fails with NoMessageStore if the hub
+// was created without WithMessageStore.
+func (h *Hub) PublishDurable(ctx context.Context, room string, msg StoredMessage) error {
+	if h.store == nil {
+		return NoMessageStore
+	}
+
+	if msg.ID == "" {
+		msg.ID = randomID()
+	}
+
+	if msg.Time.IsZero() {
+		msg.Time = time.Now()
+	}
+
+	err := h.store.Append(ctx, room, msg)
+	if errors.Is(err, DuplicateMessage) {
+		// stored by an earlier attempt, which also broadcast it or reported why not
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return h.BroadcastToRoom(ctx, room, msg.Type, msg.Data)
+}
+
+// MemoryMessageStore is a MessageStore keeping messages in memory, for tests and single
+// instances. Its messages are lost when the process exits, so it is not durable.
+type MemoryMessageStore struct {
+	// Limit is the number of messages kept for a room, the oldest being dropped to make
+	// room for new ones. It defaults to 1000.
+	Limit int
+
+	mu    sync.Mutex
+	rooms map[string][]StoredMessage
+	ids   map[string]struct{}
+}
+
+// NewMemoryMessageStore returns an empty MemoryMessageStore.
+func NewMemoryMessageStore() *MemoryMessageStore {
+	return &MemoryMessageStore{
+		rooms: map[string][]StoredMessage{},
+		ids:   map[string]struct{}{},
+	}
+}
+
+// Append adds a message to the room, dropping the oldest one when the limit is reached.
+// Messages are told apart by their ID as long as they are kept.
+func (s *MemoryMessageStore) Append(ctx context.Context, room string, msg StoredMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.rooms == nil {
+		s.rooms = map[string][]StoredMessage{}
+		s.ids = map[string]struct{}{}
+	}
+
+	if _, ok := s.ids[msg.ID]; ok {
+		return DuplicateMessage
+	}
+
+	limit := s.Limit
+	if limit <= 0 {
+		limit = defaultStoredMessages
+	}
+
+	messages := append(s.rooms[room], msg)
+	if len(messages) > limit {
+		for _, dropped := range messages[:len(messages)-limit] {
+			delete(s.ids, dropped.ID)
+		}
+
+		// copied so the backing array does not keep growing
+		messages = append([]StoredMessage(nil), messages[len(messages)-limit:]...)
+	}
+
+	s.rooms[room] = messages
+	s.ids[msg.ID] = struct{}{}
+	return nil
+}
+
+// Messages gives a copy of the messages kept for the room, oldest first.
+func (s *MemoryMessageStore) Messages(room string) []StoredMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]StoredMessage(nil), s.rooms[room]...)
+}
@@ -16,4 +16,72 @@ var (
 
 	InvalidLength  = errors.New("invalid length")
 
+	InvalidCapability = errors.New("invalid capability header")
+
+	UnexpectedMessageType = errors.New("unexpected message type")
+
+	DeadlinesNotSupported = errors.New("deadlines are not supported")
+
+	FrameRateExceeded = errors.New("frame rate exceeded")
+
+	ReadLimitExceeded = errors.New("message exceeds read limit")
+
+	InvalidCloseCode = errors.New("invalid close code")
+
+	InvalidCloseReason = errors.New("close reason is not valid UTF-8")
+
+	ErrConnectionClosed = errors.New("connection closed")
+
+	MissedPong = errors.New("no pong received in time")
+
+	ConnectionIdle = errors.New("connection idle for too long")
+
+	BadHandshake = errors.New("bad handshake")
+
+	OriginNotAllowed = errors.New("origin not allowed")
+
+	Unauthorized = errors.New("unauthorized")
+
+	Forbidden = errors.New("forbidden")
+
+	InvalidMaskingKey = errors.New("masking key must be 4 bytes")
+
+	ReservedBitsSet = errors.New("reserved bits set without a negotiated extension")
+
+	InvalidEnvelope = errors.New("invalid event envelope")
+
+	UnknownEvent = errors.New("no handler for event")
+
+	QueueFull = errors.New("send queue is full")
+
+	SlowClient = errors.New("client cannot keep up with the messages sent to it")
+
+	HubClosed = errors.New("hub closed")
+
+	NoMessageStore = errors.New("hub has no message store")
+
+	DuplicateMessage = errors.New("message already stored")
+
+	DropMessage = errors.New("message dropped")
+
+	ShuttingDown = errors.New("server is shutting down")
+
+	TooManyConnections = errors.New("too many connections")
+
+	IPQuotaExceeded = errors.New("too many connections from the client address")
+
+	TooManyHandshakes = errors.New("too many upgrade requests")
+
+	AckTimeout = errors.New("message not acknowledged")
+
+	FlushingNotSupported = errors.New("response writer does not support flushing")
+
+	ReceiveOnly = errors.New("event stream connections only receive queued messages")
+
+	PollingNotSupported = errors.New("connection polling not supported")
+
+	PollerClosed = errors.New("poller closed")
+
+	FrameTooLarge = errors.New("frame exceeds maximum frame size")
+
 )
\ No newline at end of file
@@ -0,0 +1,106 @@
+package websocket
+
+import (
+	"expvar"
+	"net/http"
+	"time"
+)
+
+// ExpvarMetrics is a Metrics keeping aggregate counters of the connections in an expvar
+// map, for operators scraping /debug/vars rather than running a metrics backend:
+//
+//   - connections, the number of open connections;
+//   - connections_opened, connections_closed and handshake_failures;
+//   - messages_received and messages_sent, the data messages;
+//   - bytes_received and bytes_sent, the payload of every frame;
+//   - frames_received and frames_sent, control frames included;
+//   - reconnects, the sessions of a SessionStore resumed by reconnecting clients.
+//
+// The counters are only kept for the connections of a WSOpener whose Metrics is the
+// ExpvarMetrics, possibly combined with others by MultiMetrics.
+type ExpvarMetrics struct {
+	vars expvar.Map
+
+	connections       expvar.Int
+	opened            expvar.Int
+	closed            expvar.Int
+	handshakeFailures expvar.Int
+	messagesReceived  expvar.Int
+	messagesSent      expvar.Int
+	bytesReceived     expvar.Int
+	bytesSent         expvar.Int
+	framesReceived    expvar.Int
+	framesSent        expvar.Int
+	reconnects        expvar.Int
+}
+
+// NewExpvarMetrics returns an ExpvarMetrics with every counter at zero, published under
+// name unless it is empty. As with expvar.Publish, publishing the same name twice panics.
+func NewExpvarMetrics(name string) *ExpvarMetrics {
+	em := &ExpvarMetrics{}
+	em.vars.Set("connections", &em.connections)
+	em.vars.Set("connections_opened", &em.opened)
+	em.vars.Set("connections_closed", &em.closed)
+	em.vars.Set("handshake_failures", &em.handshakeFailures)
+	em.vars.Set("messages_received", &em.messagesReceived)
+	em.vars.Set("messages_sent", &em.messagesSent)
+	em.vars.Set("bytes_received", &em.bytesReceived)
+	em.vars.Set("bytes_sent", &em.bytesSent)
+	em.vars.Set("frames_received", &em.framesReceived)
+	em.vars.Set("frames_sent", &em.framesSent)
+	em.vars.Set("reconnects", &em.reconnects)
+	if name != "" {
+		expvar.Publish(name, em)
+	}
+
+	return em
+}
+
+// String gives the counters as a JSON object, making the ExpvarMetrics an expvar.Var.
+func (em *ExpvarMetrics) String() string {
+	return em.vars.String()
+}
+
+// ConnectionOpened counts the connection as open.
+func (em *ExpvarMetrics) ConnectionOpened(ws *Websocket) {
+	em.opened.Add(1)
+	em.connections.Add(1)
+}
+
+// ConnectionClosed counts the connection as closed.
+func (em *ExpvarMetrics) ConnectionClosed(ws *Websocket, code CloseCode, err error) {
+	em.closed.Add(1)
+	em.connections.Add(-1)
+}
+
+// HandshakeFailed counts the failed upgrade request.
+func (em *ExpvarMetrics) HandshakeFailed(r *http.Request, status int, err error) {
+	em.handshakeFailures.Add(1)
+}
+
+// MessageReceived counts the message received.
+func (em *ExpvarMetrics) MessageReceived(ws *Websocket, t MessageType, size int) {
+	em.messagesReceived.Add(1)
+}
+
+// MessageSent counts the message sent.
+func (em *ExpvarMetrics) MessageSent(ws *Websocket, t MessageType, size int, latency time.Duration) {
+	em.messagesSent.Add(1)
+}
+
+// FrameReceived counts the frame received and its payload bytes.
+func (em *ExpvarMetrics) FrameReceived(ws *Websocket, opcode Opcode, size int) {
+	em.framesReceived.Add(1)
+	em.bytesReceived.Add(int64(size))
+}
+
+// FrameSent counts the frame sent and its payload bytes.
+func (em *ExpvarMetrics) FrameSent(ws *Websocket, opcode Opcode, size int) {
+	em.framesSent.Add(1)
+	em.bytesSent.Add(int64(size))
+}
+
+// SessionResumed counts the reconnection.
+func (em *ExpvarMetrics) SessionResumed(ws *Websocket, lossless bool) {
+	em.reconnects.Add(1)
+}
@@ -0,0 +1,105 @@
+package websocket
+
+import (
+	"context"
+	"time"
+)
+
+// SetWriteCoalescing makes the connection coalesce the data frames written to it: rather
+// than each message being written to the connection as soon as it is sent, frames are
+// left in the write buffer until it holds size bytes, delay passed since the first of
+// them was buffered, or Flush is called, so bursts of small messages go out in a single
+// write. A size of zero or less defaults to the size of the write buffer. Control frames
+// are always written at once, together with the frames buffered before them, and so are
+// frames too large to be buffered. A delay of zero or less writes every message as soon
+// as it is sent again.
+//
+// A sent message may thus not have reached the connection when Send returns, and a
+// failure to write it closes the connection without being reported to the sender.
+func (ws *Websocket) SetWriteCoalescing(delay time.Duration, size int) {
+	ws.writeMu.Lock()
+	defer ws.writeMu.Unlock()
+	// frames already buffered are still flushed by the timer armed for them
+	ws.flushDelay = delay
+	ws.flushSize = size
+}
+
+// Flush writes the frames left in the write buffer by write coalescing to the connection.
+func (ws *Websocket) Flush(ctx context.Context) (err error) {
+	if ws.State() != StateOpen {
+		return ErrConnectionClosed
+	}
+
+	defer ws.checkError(&err)
+	unlock, err := ws.lockWrite(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer unlock(&err)
+	err = ws.flush()
+	if err != nil {
+		ws.writeFailed.Store(true)
+	}
+
+	return err
+}
+
+// flushFrame flushes the frame just written, unless it is a data frame and the connection
+// coalesces writes, in which case the write buffer is only flushed once it holds enough
+// bytes, the timer being armed for the first frame buffered. writeMu must be held.
+func (ws *Websocket) flushFrame(frame *Frame) error {
+	if ws.flushDelay <= 0 || frame.isControl() {
+		return ws.flush()
+	}
+
+	size := ws.flushSize
+	if size <= 0 {
+		size = ws.writer.Size()
+	}
+
+	buffered := ws.writer.Buffered()
+	if buffered >= size {
+		return ws.flush()
+	}
+
+	if buffered == 0 || ws.flushPending {
+		return nil
+	}
+
+	if ws.flushTimer == nil {
+		ws.flushTimer = time.AfterFunc(ws.flushDelay, ws.flushBuffered)
+	} else {
+		ws.flushTimer.Reset(ws.flushDelay)
+	}
+
+	ws.flushPending = true
+	return nil
+}
+
+// flush writes the write buffer to the connection. writeMu must be held.
+func (ws *Websocket) flush() error {
+	if ws.flushPending {
+		ws.flushTimer.Stop()
+		ws.flushPending = false
+	}
+
+	return ws.writer.Flush()
+}
+
+// flushBuffered flushes the coalesced frames once the flush delay passed since the first
+// of them was buffered. Nobody waits for the write, so a failure closes the connection.
+func (ws *Websocket) flushBuffered() {
+	ws.writeMu.Lock()
+	if !ws.flushPending || ws.State() == StateClosed {
+		// flushed in the meantime, or there is no one left to write to
+		ws.writeMu.Unlock()
+		return
+	}
+
+	err := ws.flush()
+	ws.writeMu.Unlock()
+	if err != nil {
+		_ = ws.terminate(AbnormalClosure, err)
+	}
+}
@@ -1,5 +1,10 @@
 package websocket 
 
+import (
+	"encoding/binary"
+	"math"
+)
+
 // Defines the interpretation of the "Payload data".  If an unknown
 // opcode is received, the receiving endpoint MUST _Fail the
 // WebSocket Connection_.  The following values are defined.
@@ -97,6 +102,18 @@ type Frame struct {
 	// is equal to the payload length minus the length of the "Extension
 	// data".
 	ApplicationData []byte
+
+	// lengths and key hold the payload length and masking key of frames built by the
+	// read and write paths, so they point into the frame rather than separate allocations.
+	lengths frameLengths
+	key     [4]byte
+}
+
+// frameLengths is the storage of the payload length encodings of a frame.
+type frameLengths struct {
+	short  uint
+	medium uint16
+	long   uint64
 }
 
 // PayloadLength gives the length of the payload in a frame.
@@ -117,15 +134,76 @@ func (f *Frame) PayloadLength() uint64 {
 	return 0
 }
 
-// umask will decode the frame using the mask associated with it.
-func (f *Frame) umask() ([]byte, error) {
-	payload := f.ApplicationData
-	umasked := make([]byte, len(payload))
-	mask := f.MaskingKey 
-	for i, b := range payload{
-		j := i % 4
-		umasked = append(umasked,b^mask[j])
+// setPayloadLength records the payload length of the frame using
+// the smallest of the length encodings that fits.
+func (f *Frame) setPayloadLength(length int) error {
+	f.payloadLengthInt = nil
+	f.payloadLengthInt16 = nil
+	f.payloadLengthInt64 = nil
+	if length < 0 {
+		return InvalidLength
+	} else if length <= 125 {
+		f.lengths.short = uint(length)
+		f.payloadLengthInt = &f.lengths.short
+	} else if length <= math.MaxUint16 {
+		f.lengths.medium = uint16(length)
+		f.payloadLengthInt16 = &f.lengths.medium
+	} else {
+		f.lengths.long = uint64(length)
+		f.payloadLengthInt64 = &f.lengths.long
+	}
+
+	return nil
+}
+
+// isControl reports whether the frame is a control frame.
+// Control frames are identified by opcodes where the most significant bit of the opcode is 1.
+func (f *Frame) isControl() bool {
+	switch f.Opcode {
+	case ConnectionClose, Ping, Pong, ControlFrame:
+		return true
+	default:
+		return false
+	}
+}
+
+// MaskBytes applies the masking key to b in place, as described in Section 5.3 of RFC 6455,
+// starting at position pos of the key. Masking is its own inverse, so the same function
+// unmasks. It returns the position to continue from for the following bytes of the same
+// payload, so a payload can be masked in pieces.
+//
+// Long payloads are masked eight bytes at a time, masking being the bulk of the work of
+// reading from clients, which mask every frame.
+func MaskBytes(key [4]byte, pos int, b []byte) int {
+	pos %= 4
+	if len(b) >= 8 {
+		// the key repeated over a word, starting at pos; words are multiples of the key
+		// length, so pos is the same after each of them
+		var rotated [4]byte
+		for i := range rotated {
+			rotated[i] = key[(pos+i)%4]
+		}
+
+		k := uint64(binary.LittleEndian.Uint32(rotated[:]))
+		k |= k << 32
+		for len(b) >= 32 {
+			binary.LittleEndian.PutUint64(b, binary.LittleEndian.Uint64(b)^k)
+			binary.LittleEndian.PutUint64(b[8:], binary.LittleEndian.Uint64(b[8:])^k)
+			binary.LittleEndian.PutUint64(b[16:], binary.LittleEndian.Uint64(b[16:])^k)
+			binary.LittleEndian.PutUint64(b[24:], binary.LittleEndian.Uint64(b[24:])^k)
+			b = b[32:]
+		}
+
+		for len(b) >= 8 {
+			binary.LittleEndian.PutUint64(b, binary.LittleEndian.Uint64(b)^k)
+			b = b[8:]
+		}
+	}
+
+	for i := range b {
+		b[i] ^= key[pos]
+		pos = (pos + 1) % 4
 	}
 
-	return umasked, nil
+	return pos
 }
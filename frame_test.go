@@ -0,0 +1,87 @@
+package websocket
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// maskBytesByByte is the byte-wise masking MaskBytes is checked and benchmarked against.
+func maskBytesByByte(key [4]byte, pos int, b []byte) int {
+	for i := range b {
+		b[i] ^= key[pos%4]
+		pos++
+	}
+
+	return pos % 4
+}
+
+// TestMaskBytes checks MaskBytes against byte-wise masking for lengths around the word
+// and unrolled loop sizes, at every position of the key.
+func TestMaskBytes(t *testing.T) {
+	key := [4]byte{0xa1, 0xb2, 0xc3, 0xd4}
+	for n := 0; n <= 80; n++ {
+		for pos := range 4 {
+			data := make([]byte, n)
+			for i := range data {
+				data[i] = byte(i * 7)
+			}
+
+			got, want := bytes.Clone(data), bytes.Clone(data)
+			gotPos := MaskBytes(key, pos, got)
+			wantPos := maskBytesByByte(key, pos, want)
+			if !bytes.Equal(got, want) {
+				t.Fatalf("MaskBytes(%d bytes, pos %d) = %x, want %x", n, pos, got, want)
+			}
+
+			if gotPos != wantPos {
+				t.Fatalf("MaskBytes(%d bytes, pos %d) returned position %d, want %d", n, pos, gotPos, wantPos)
+			}
+		}
+	}
+}
+
+// TestMaskBytesPieces checks that a payload masked in pieces is masked as a whole.
+func TestMaskBytesPieces(t *testing.T) {
+	key := [4]byte{1, 2, 3, 4}
+	data := bytes.Repeat([]byte("websocket"), 20)
+	want := bytes.Clone(data)
+	maskBytesByByte(key, 0, want)
+
+	got := bytes.Clone(data)
+	rest, pos := got, 0
+	for _, size := range []int{3, 17, 1, 40, 9, 64} {
+		pos = MaskBytes(key, pos, rest[:size])
+		rest = rest[size:]
+	}
+
+	MaskBytes(key, pos, rest)
+	if !bytes.Equal(got, want) {
+		t.Fatalf("masking in pieces gave %x, want %x", got, want)
+	}
+}
+
+// BenchmarkMaskBytes compares MaskBytes to byte-wise masking, for lengths that are
+// multiples of the word size and lengths that leave a tail of single bytes.
+func BenchmarkMaskBytes(b *testing.B) {
+	key := [4]byte{0xa1, 0xb2, 0xc3, 0xd4}
+	masks := []struct {
+		name string
+		mask func(key [4]byte, pos int, b []byte) int
+	}{
+		{"word", MaskBytes},
+		{"byte", maskBytesByByte},
+	}
+
+	for _, size := range []int{16, 61, 512, 1021, 4096, 65539} {
+		data := make([]byte, size)
+		for _, m := range masks {
+			b.Run(fmt.Sprintf("%s/%d", m.name, size), func(b *testing.B) {
+				b.SetBytes(int64(size))
+				for range b.N {
+					m.mask(key, 1, data)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package websocket
+
+import (
+	"context"
+)
+
+// NewFrame creates a frame carrying the payload as application data, with the
+// payload length set using the smallest encoding that fits.
+func NewFrame(opcode Opcode, fin bool, payload []byte) *Frame {
+	f := Frame{
+		FIN:             fin,
+		Opcode:          opcode,
+		ApplicationData: payload,
+	}
+
+	// the length of a slice is never negative, so this cannot fail
+	_ = f.setPayloadLength(len(payload))
+	return &f
+}
+
+// ReadFrame reads the next frame from the connection as is, without handling control
+// frames or reassembling fragments, for extensions, proxies and conformance tooling.
+// The ApplicationData of the returned frame is already unmasked; Mask and MaskingKey
+// tell how it was sent. ReadFrame must not be used concurrently with the message level
+// read methods, and a message must be read either frame by frame or as a whole.
+func (ws *Websocket) ReadFrame(ctx context.Context) (frame *Frame, err error) {
+	if ws.State() == StateClosed {
+		return nil, ErrConnectionClosed
+	}
+
+	defer ws.checkError(&err)
+	defer ws.readDeadline.bind(ctx)(&err)
+	frame, err = ws.readFrame()
+	if err != nil {
+		return nil, err
+	}
+
+	if frame.Mask {
+		MaskBytes([4]byte(frame.MaskingKey), 0, frame.ApplicationData)
+	}
+
+	return frame, nil
+}
+
+// WriteFrame writes a single frame to the connection as is. The payload length is
+// taken from the ExtensionData and ApplicationData of the frame; if Mask is set the
+// payload is masked with MaskingKey on the wire. The caller is responsible for the
+// frame sequence making sense to the peer. It is safe to call concurrently with the
+// other write methods, which never interleave their frames with it.
+func (ws *Websocket) WriteFrame(ctx context.Context, frame *Frame) (err error) {
+	if ws.State() == StateClosed {
+		return ErrConnectionClosed
+	}
+
+	defer ws.checkError(&err)
+	ws.messageMu.Lock()
+	defer ws.messageMu.Unlock()
+	unlock, err := ws.lockWrite(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer unlock(&err)
+	return ws.writeFrame(frame)
+}
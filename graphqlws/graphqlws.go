@@ -0,0 +1,391 @@
+// Package graphqlws serves GraphQL operations over websocket connections with the
+// graphql-transport-ws protocol, as spoken by the graphql-ws client library. The
+// execution of operations is left to the GraphQL engine, plugged in as Server.Execute.
+//
+// Servers select the protocol by listing Subprotocol in WSOpener.Subprotocols.
+package graphqlws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ajsqr/websocket"
+)
+
+// Subprotocol is the name under which clients request the protocol in the
+// Sec-WebSocket-Protocol header.
+const Subprotocol = "graphql-transport-ws"
+
+// maxReasonLength is the longest reason a Close frame can carry.
+const maxReasonLength = 123
+
+// defaultInitTimeout is how long Server waits for connection_init when InitTimeout is not set.
+const defaultInitTimeout = 3 * time.Second
+
+// The types of the messages of the protocol.
+const (
+	ConnectionInit = "connection_init"
+	ConnectionAck  = "connection_ack"
+	Ping           = "ping"
+	Pong           = "pong"
+	Subscribe      = "subscribe"
+	Next           = "next"
+	Error          = "error"
+	Complete       = "complete"
+)
+
+// The close codes defined by the protocol.
+var (
+	// BadRequest closes connections sending messages that are malformed or unexpected.
+	BadRequest websocket.CloseCode = 4400
+
+	// Unauthorized closes connections subscribing before their connection_init was acknowledged.
+	Unauthorized websocket.CloseCode = 4401
+
+	// Forbidden closes connections whose connection_init was rejected by Server.Init.
+	Forbidden websocket.CloseCode = 4403
+
+	// InitTimeout closes connections not sending connection_init in time.
+	InitTimeout websocket.CloseCode = 4408
+
+	// SubscriberExists closes connections subscribing twice with the same ID.
+	SubscriberExists websocket.CloseCode = 4409
+
+	// TooManyInitRequests closes connections sending connection_init more than once.
+	TooManyInitRequests websocket.CloseCode = 4429
+)
+
+// Message is a message of the protocol.
+type Message struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// Operation is the payload of a subscribe message: the GraphQL operation to execute.
+type Operation struct {
+	OperationName string          `json:"operationName,omitempty"`
+	Query         string          `json:"query"`
+	Variables     map[string]any  `json:"variables,omitempty"`
+	Extensions    map[string]any  `json:"extensions,omitempty"`
+	InitPayload   json.RawMessage `json:"-"`
+}
+
+// Result is an execution result, sent to the client in a next message.
+type Result struct {
+	Data       any            `json:"data,omitempty"`
+	Errors     []GraphQLError `json:"errors,omitempty"`
+	Extensions map[string]any `json:"extensions,omitempty"`
+}
+
+// GraphQLError is an error as formatted by the GraphQL specification.
+type GraphQLError struct {
+	Message    string         `json:"message"`
+	Locations  []Location     `json:"locations,omitempty"`
+	Path       []any          `json:"path,omitempty"`
+	Extensions map[string]any `json:"extensions,omitempty"`
+}
+
+func (e GraphQLError) Error() string {
+	return e.Message
+}
+
+// Location is a position in a GraphQL document.
+type Location struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// Errors is returned by Server.Execute when an operation cannot be executed at all,
+// for instance because it does not validate. It is sent to the client in an error message.
+type Errors []GraphQLError
+
+func (e Errors) Error() string {
+	if len(e) == 0 {
+		return "graphql: no errors"
+	}
+
+	return e[0].Message
+}
+
+// Server runs the graphql-transport-ws protocol on connections, handing the operations
+// subscribed to by clients to Execute.
+type Server struct {
+	// Init, if set, decides whether to accept a connection from the payload of its
+	// connection_init message. The returned value, if not nil, is the payload of the
+	// connection_ack message. An error closes the connection with Forbidden.
+	Init func(ctx context.Context, payload json.RawMessage) (any, error)
+
+	// Execute executes an operation, sending its results on the returned channel and
+	// closing it once done; queries and mutations send one result, subscriptions any
+	// number. ctx is cancelled once the client completes the operation or disconnects.
+	// An error, preferably of type Errors, is sent to the client in an error message.
+	Execute func(ctx context.Context, op *Operation) (<-chan *Result, error)
+
+	// InitTimeout is how long the client has to send connection_init after connecting.
+	// It defaults to three seconds.
+	InitTimeout time.Duration
+}
+
+// Serve runs the protocol on ws until the connection closes or ctx is done. It returns
+// nil when the connection was closed cleanly, and the *websocket.CloseError it was closed
+// with when the client broke the protocol.
+func (s *Server) Serve(ctx context.Context, ws *websocket.Websocket) error {
+	ctx, cancel := context.WithCancel(ctx)
+	c := &conn{
+		server:     s,
+		ws:         ws,
+		operations: map[string]*operation{},
+		initDone:   make(chan struct{}),
+	}
+
+	defer c.wg.Wait()
+	defer cancel()
+	go c.awaitInit(ctx)
+	for msg, err := range ws.Messages(ctx) {
+		if err != nil {
+			return err
+		}
+
+		err = c.handle(ctx, msg.Data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// conn is the state of the protocol on a connection.
+type conn struct {
+	server *Server
+	ws     *websocket.Websocket
+	wg     sync.WaitGroup
+
+	// initPayload is the payload of connection_init, set once it was received.
+	initPayload json.RawMessage
+	initDone    chan struct{}
+	acked       bool
+
+	mu         sync.Mutex
+	operations map[string]*operation
+}
+
+// operation is an operation being executed.
+type operation struct {
+	// stop cancels the context of the execution.
+	stop context.CancelFunc
+}
+
+// awaitInit closes the connection if connection_init is not received in time.
+func (c *conn) awaitInit(ctx context.Context) {
+	timeout := c.server.InitTimeout
+	if timeout <= 0 {
+		timeout = defaultInitTimeout
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		_ = c.ws.CloseWithCode(InitTimeout, "Connection initialisation timeout")
+	case <-c.initDone:
+	case <-ctx.Done():
+	}
+}
+
+// handle handles a message from the client. It returns an error once the connection
+// had to be closed.
+func (c *conn) handle(ctx context.Context, data []byte) error {
+	msg := Message{}
+	err := json.Unmarshal(data, &msg)
+	if err != nil || msg.Type == "" {
+		return c.close(BadRequest, "Invalid message received")
+	}
+
+	switch msg.Type {
+	case ConnectionInit:
+		return c.init(ctx, msg)
+	case Ping:
+		return c.send(ctx, Message{Type: Pong, Payload: msg.Payload})
+	case Pong:
+		return nil
+	case Subscribe:
+		if !c.acked {
+			return c.close(Unauthorized, "Unauthorized")
+		}
+
+		return c.subscribe(ctx, msg)
+	case Complete:
+		c.complete(msg.ID)
+		return nil
+	default:
+		return c.close(BadRequest, fmt.Sprintf("Unexpected message of type %s received", msg.Type))
+	}
+}
+
+// init accepts or rejects the connection from its connection_init message.
+func (c *conn) init(ctx context.Context, msg Message) error {
+	if c.initPayload != nil {
+		return c.close(TooManyInitRequests, "Too many initialisation requests")
+	}
+
+	c.initPayload = msg.Payload
+	if c.initPayload == nil {
+		c.initPayload = json.RawMessage("null")
+	}
+
+	close(c.initDone)
+	var ack any
+	if c.server.Init != nil {
+		var err error
+		ack, err = c.server.Init(ctx, msg.Payload)
+		if err != nil {
+			return c.close(Forbidden, "Forbidden")
+		}
+	}
+
+	reply := Message{Type: ConnectionAck}
+	if ack != nil {
+		payload, err := json.Marshal(ack)
+		if err != nil {
+			return err
+		}
+
+		reply.Payload = payload
+	}
+
+	c.acked = true
+	return c.send(ctx, reply)
+}
+
+// subscribe starts executing the operation of a subscribe message.
+func (c *conn) subscribe(ctx context.Context, msg Message) error {
+	op := &Operation{}
+	err := json.Unmarshal(msg.Payload, op)
+	if err != nil || msg.ID == "" {
+		return c.close(BadRequest, "Invalid message received")
+	}
+
+	op.InitPayload = c.initPayload
+	ctx, cancel := context.WithCancel(ctx)
+	running := &operation{stop: cancel}
+	c.mu.Lock()
+	_, exists := c.operations[msg.ID]
+	if !exists {
+		c.operations[msg.ID] = running
+	}
+	c.mu.Unlock()
+	if exists {
+		cancel()
+		return c.close(SubscriberExists, "Subscriber for "+msg.ID+" already exists")
+	}
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		defer cancel()
+		c.execute(ctx, msg.ID, running, op)
+	}()
+
+	return nil
+}
+
+// execute runs an operation and sends its results, then completes it unless the client did.
+func (c *conn) execute(ctx context.Context, id string, running *operation, op *Operation) {
+	results, err := c.server.Execute(ctx, op)
+	if err != nil {
+		if !c.finish(id, running) {
+			return
+		}
+
+		var errs Errors
+		if !errors.As(err, &errs) {
+			errs = Errors{{Message: err.Error()}}
+		}
+
+		payload, _ := json.Marshal(errs)
+		_ = c.send(ctx, Message{ID: id, Type: Error, Payload: payload})
+		return
+	}
+
+	for {
+		select {
+		case result, ok := <-results:
+			if !ok {
+				if c.finish(id, running) {
+					_ = c.send(ctx, Message{ID: id, Type: Complete})
+				}
+
+				return
+			}
+
+			payload, err := json.Marshal(result)
+			if err != nil {
+				continue
+			}
+
+			_ = c.send(ctx, Message{ID: id, Type: Next, Payload: payload})
+		case <-ctx.Done():
+			// the client completed the operation, or the connection is gone
+			c.finish(id, running)
+			return
+		}
+	}
+}
+
+// complete stops the operation the client completed.
+func (c *conn) complete(id string) {
+	c.mu.Lock()
+	running, ok := c.operations[id]
+	delete(c.operations, id)
+	c.mu.Unlock()
+	if ok {
+		running.stop()
+	}
+}
+
+// finish forgets the operation once it is done, and reports whether it was still running,
+// in which case the server has to tell the client it is done. The client may have
+// completed it and reused its ID meanwhile, for an operation that is left alone.
+func (c *conn) finish(id string, running *operation) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.operations[id] != running {
+		return false
+	}
+
+	delete(c.operations, id)
+	return true
+}
+
+func (c *conn) send(ctx context.Context, msg Message) error {
+	return c.ws.WriteJSON(ctx, msg)
+}
+
+// close closes the connection with a code of the protocol and returns the reason as an error.
+// Reasons quoting the client are cut to fit in the Close frame.
+func (c *conn) close(code websocket.CloseCode, reason string) error {
+	_ = c.ws.CloseWithCode(code, truncateReason(reason))
+	return &websocket.CloseError{Code: code, Reason: reason}
+}
+
+// truncateReason cuts the reason to the 123 bytes a Close frame has room for, without
+// splitting a character.
+func truncateReason(reason string) string {
+	if len(reason) <= maxReasonLength {
+		return reason
+	}
+
+	end := maxReasonLength
+	for end > 0 && !utf8.RuneStart(reason[end]) {
+		end--
+	}
+
+	return reason[:end]
+}
@@ -0,0 +1,58 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"log"
+	"log/slog"
+	"net/http"
+	"runtime/pprof"
+)
+
+// Handler returns an http.Handler that upgrades each request with a zero WSOpener
+// into a text websocket and runs fn with it. See WSOpener.Handler.
+func Handler(fn func(ctx context.Context, ws *Websocket)) http.Handler {
+	opener := WSOpener{}
+	return opener.Handler(TextWebsocket, fn)
+}
+
+// Handler returns an http.Handler that upgrades each request into a websocket of type t
+// and runs fn with it and the context of the connection. The connection is closed
+// when fn returns, if fn did not close it.
+// Failed upgrades and failures to close are logged to the Logger of the opener, or to
+// the standard logger if it has none.
+func (wso *WSOpener) Handler(t WebsocketType, fn func(ctx context.Context, ws *Websocket)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := wso.Open(w, r, t)
+		if err != nil {
+			if wso.Logger == nil {
+				// otherwise Open logged it already
+				log.Printf("websocket: upgrade of %s failed: %v", r.RemoteAddr, err)
+			}
+
+			return
+		}
+
+		defer func() {
+			err := ws.Close()
+			if err == nil || errors.Is(err, ErrConnectionClosed) {
+				return
+			}
+
+			if wso.Logger != nil {
+				ws.log(slog.LevelWarn, "websocket close failed", slog.Any("error", err))
+				return
+			}
+
+			log.Printf("websocket: closing connection from %s failed: %v", r.RemoteAddr, err)
+		}()
+
+		if ws.profileLabels {
+			// the goroutine only serves the connection until fn returns
+			ws.labelGoroutine()
+			defer pprof.SetGoroutineLabels(r.Context())
+		}
+
+		fn(ws.Context(), ws)
+	})
+}
@@ -0,0 +1,32 @@
+package websocket
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+)
+
+// ComputeAcceptKey returns the value of the Sec-WebSocket-Accept header field for
+// the Sec-WebSocket-Key sent by a client. The value is constructed by concatenating
+// the key with the string "258EAFA5-E914-47DA-95CA-C5AB0DC85B11", taking the SHA-1
+// hash of this concatenated value to obtain a 20-byte value and base64-encoding
+// (see Section 4 of [RFC4648]) this 20-byte hash. See Section 4.2.2 of RFC 6455.
+//
+// It is exported for servers that do not build on net/http and for test harnesses.
+func ComputeAcceptKey(secKey string) string {
+	hash := sha1.Sum([]byte(secKey + websocketGUID))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// GenerateChallengeKey returns a value for the Sec-WebSocket-Key header field of an
+// opening handshake: a randomly selected 16-byte nonce, base64-encoded.
+// See Section 4.1 of RFC 6455.
+func GenerateChallengeKey() (string, error) {
+	nonce := make([]byte, 16)
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(nonce), nil
+}
@@ -0,0 +1,101 @@
+package websocket
+
+import (
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often the per address limiters of a HandshakeLimiter are pruned.
+const sweepInterval = time.Minute
+
+// HandshakeLimiter limits the rate of upgrade requests, per client IP address and
+// across all clients, of the WSOpeners it is set on. Requests beyond the limits are
+// rejected with 429 Too Many Requests before any other check.
+type HandshakeLimiter struct {
+	// Rate is the number of upgrade requests accepted per second across all clients.
+	// Zero disables the global limit.
+	Rate float64
+
+	// Burst is the number of upgrade requests accepted at once before Rate applies.
+	// It defaults to one second worth of requests.
+	Burst int
+
+	// RatePerIP is the number of upgrade requests accepted per second from a single
+	// client IP address, as given by WSOpener.ClientIP. Zero disables the limit.
+	RatePerIP float64
+
+	// BurstPerIP is the number of upgrade requests a client may send at once before
+	// RatePerIP applies. It defaults to one second worth of requests.
+	BurstPerIP int
+
+	mu        sync.Mutex
+	global    *rateLimiter
+	perIP     map[string]*rateLimiter
+	lastSweep time.Time
+}
+
+// allow takes a token for an upgrade request from the client IP address and reports
+// whether the request is within the limits.
+func (l *HandshakeLimiter) allow(ip string) bool {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+	var limiter *rateLimiter
+	if l.RatePerIP > 0 {
+		if l.perIP == nil {
+			l.perIP = map[string]*rateLimiter{}
+		}
+
+		limiter = l.perIP[ip]
+		if limiter == nil {
+			limiter = newRateLimiter(l.RatePerIP, defaultBurst(l.RatePerIP, l.BurstPerIP))
+			l.perIP[ip] = limiter
+		}
+
+		if !limiter.allow(1) {
+			return false
+		}
+	}
+
+	if l.Rate > 0 {
+		if l.global == nil {
+			l.global = newRateLimiter(l.Rate, defaultBurst(l.Rate, l.Burst))
+		}
+
+		if !l.global.allow(1) {
+			if limiter != nil {
+				// the request did not go through, so it is not held against the client
+				limiter.give(1)
+			}
+
+			return false
+		}
+	}
+
+	return true
+}
+
+// sweep forgets the clients whose bucket has refilled, so addresses seen once do not
+// accumulate. l.mu must be held.
+func (l *HandshakeLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+
+	l.lastSweep = now
+	for ip, limiter := range l.perIP {
+		if limiter.full(now) {
+			delete(l.perIP, ip)
+		}
+	}
+}
+
+// defaultBurst gives the configured burst, or one second worth of the rate if it is not set.
+func defaultBurst(rate float64, burst int) int {
+	if burst > 0 {
+		return burst
+	}
+
+	return int(rate)
+}
@@ -0,0 +1,275 @@
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+// HistoryMessage is a message broadcast to a room, kept for replay.
+type HistoryMessage struct {
+	// Type and Data are the message.
+	Type MessageType `json:"type"`
+	Data []byte      `json:"data"`
+
+	// Time is when the message was broadcast.
+	Time time.Time `json:"time"`
+}
+
+// HistoryStore keeps the recent messages of rooms for WithHistory. A store shared by
+// bridged hubs records every broadcast once per hub, since each hub records the
+// broadcasts it delivers. The hub calls it without holding any lock, and tells the
+// messages it gives apart by their type, data and time, which must be kept to the
+// nanosecond.
+type HistoryStore interface {
+	// Record adds a message broadcast to the room.
+	Record(ctx context.Context, room string, msg HistoryMessage) error
+
+	// Recent gives the messages of the room to replay to a new member, oldest first.
+	Recent(ctx context.Context, room string) ([]HistoryMessage, error)
+}
+
+// WithHistory makes the hub record the messages broadcast to rooms in store and replay
+// them to the connections joining the room, so late joiners get context. Broadcasts
+// targeting tags within a room are not recorded. Replayed messages are queued before any
+// broadcast made after the join, and none is both replayed and delivered. An error
+// recording a broadcast is returned by the broadcast, which is delivered regardless.
+func WithHistory(store HistoryStore) HubOption {
+	return func(h *Hub) {
+		h.history = store
+	}
+}
+
+// roomHistory orders the broadcasts to a room with the joins replaying its history, so
+// the store is used without any lock held. Its mutex is taken before that of the hub.
+type roomHistory struct {
+	mu sync.Mutex
+
+	// refs is the number of broadcasts and joins using the roomHistory, which is dropped
+	// with the last of them. It is guarded by the historyMu of the hub.
+	refs int
+
+	// recording are the messages of the broadcasts whose members were chosen, but which
+	// the store may not have recorded yet.
+	recording []*HistoryMessage
+
+	// replaying are the members the history is being replayed to, with the broadcasts
+	// chosen for them meanwhile, delivered once the history is.
+	replaying map[*Websocket][]pendingBroadcast
+}
+
+// pendingBroadcast is a broadcast held back for a member the history is being replayed to.
+type pendingBroadcast struct {
+	msg   *HistoryMessage
+	pm    *PreparedMessage
+	match func(ws *Websocket) bool
+}
+
+// records reports whether the broadcast to target is recorded in the history.
+func (h *Hub) records(target Target) bool {
+	return h.history != nil && target.Room != "" && len(target.Tags) == 0
+}
+
+// acquireHistory gives the roomHistory of the room, created if no broadcast or join
+// uses it yet. It must be released with releaseHistory.
+func (h *Hub) acquireHistory(room string) *roomHistory {
+	h.historyMu.Lock()
+	defer h.historyMu.Unlock()
+	if h.histories == nil {
+		h.histories = map[string]*roomHistory{}
+	}
+
+	rh, ok := h.histories[room]
+	if !ok {
+		rh = &roomHistory{replaying: map[*Websocket][]pendingBroadcast{}}
+		h.histories[room] = rh
+	}
+
+	rh.refs++
+	return rh
+}
+
+// releaseHistory releases the roomHistory of the room, dropping it once unused.
+func (h *Hub) releaseHistory(room string, rh *roomHistory) {
+	h.historyMu.Lock()
+	defer h.historyMu.Unlock()
+	rh.refs--
+	if rh.refs == 0 {
+		delete(h.histories, room)
+	}
+}
+
+// hold takes ws out of the members chosen for a broadcast if the history is being
+// replayed to it, queueing the broadcast for after the history instead. rh.mu must be held.
+func (rh *roomHistory) hold(ws *Websocket, msg *HistoryMessage, pm *PreparedMessage, match func(ws *Websocket) bool) bool {
+	pending, ok := rh.replaying[ws]
+	if ok {
+		rh.replaying[ws] = append(pending, pendingBroadcast{msg: msg, pm: pm, match: match})
+	}
+
+	return ok
+}
+
+// record records the broadcast in the store, once its members were chosen, rh.mu being
+// released. Until the store has recorded it, joins replay it from memory.
+func (h *Hub) record(ctx context.Context, room string, rh *roomHistory, msg *HistoryMessage) error {
+	rh.recording = append(rh.recording, msg)
+	rh.mu.Unlock()
+	err := h.history.Record(ctx, room, *msg)
+	rh.mu.Lock()
+	defer rh.mu.Unlock()
+	rh.recording = slices.DeleteFunc(rh.recording, func(m *HistoryMessage) bool {
+		return m == msg
+	})
+
+	return err
+}
+
+// replay queues the history of the room to ws, which just joined it, then the broadcasts
+// to the room held back for ws meanwhile. recording are the broadcasts made just before
+// ws joined, which the store may not have recorded yet.
+func (h *Hub) replay(room string, ws *Websocket, rh *roomHistory, recording []*HistoryMessage) error {
+	defer func() {
+		rh.mu.Lock()
+		delete(rh.replaying, ws)
+		rh.mu.Unlock()
+	}()
+
+	messages, err := h.history.Recent(ws.Context(), room)
+	if err != nil {
+		return err
+	}
+
+	for _, msg := range recording {
+		if !contains(messages, msg) {
+			messages = append(messages, *msg)
+		}
+	}
+
+	for _, msg := range messages {
+		err = ws.Enqueue(ws.Context(), Message{Type: msg.Type, Data: msg.Data})
+		if err != nil {
+			return err
+		}
+	}
+
+	for {
+		rh.mu.Lock()
+		pending := rh.replaying[ws]
+		if len(pending) == 0 {
+			// the broadcasts made from now on are delivered to ws directly
+			delete(rh.replaying, ws)
+			rh.mu.Unlock()
+			return nil
+		}
+
+		rh.replaying[ws] = nil
+		rh.mu.Unlock()
+		for _, p := range pending {
+			// the store may have recorded the broadcast before it gave the history
+			if contains(messages, p.msg) || (p.match != nil && !p.match(ws)) {
+				continue
+			}
+
+			err = ws.EnqueuePrepared(ws.Context(), p.pm)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// contains reports whether msg is among the messages given by the store.
+func contains(messages []HistoryMessage, msg *HistoryMessage) bool {
+	for _, m := range slices.Backward(messages) {
+		if m.Time.Equal(msg.Time) && m.Type == msg.Type && bytes.Equal(m.Data, msg.Data) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// defaultHistorySize is the number of messages MemoryHistory keeps for every room when
+// neither its Size nor its Window is set.
+const defaultHistorySize = 100
+
+// MemoryHistory is a HistoryStore keeping the last messages of each room in memory.
+type MemoryHistory struct {
+	// Size is the number of messages kept for every room. Zero keeps every message
+	// within Window, or the last 100 messages if Window is not set either.
+	Size int
+
+	// Window, if set, is how long messages are kept.
+	Window time.Duration
+
+	mu    sync.Mutex
+	rooms map[string][]HistoryMessage
+}
+
+// NewMemoryHistory returns a MemoryHistory keeping the last size messages of every room,
+// sent within window if it is not zero. With neither a size nor a window, the last 100
+// messages are kept.
+func NewMemoryHistory(size int, window time.Duration) *MemoryHistory {
+	return &MemoryHistory{
+		Size:   size,
+		Window: window,
+		rooms:  map[string][]HistoryMessage{},
+	}
+}
+
+// Record adds a message to the room, dropping the messages no longer kept.
+func (mh *MemoryHistory) Record(ctx context.Context, room string, msg HistoryMessage) error {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+	if mh.rooms == nil {
+		mh.rooms = map[string][]HistoryMessage{}
+	}
+
+	size := mh.Size
+	if size <= 0 && mh.Window <= 0 {
+		// a history without bounds would keep every message ever broadcast
+		size = defaultHistorySize
+	}
+
+	messages := append(mh.rooms[room], msg)
+	if size > 0 && len(messages) > size {
+		// copied so the backing array does not keep growing
+		messages = append([]HistoryMessage(nil), messages[len(messages)-size:]...)
+	}
+
+	mh.rooms[room] = mh.expire(messages)
+	return nil
+}
+
+// Recent gives a copy of the messages of the room still kept.
+func (mh *MemoryHistory) Recent(ctx context.Context, room string) ([]HistoryMessage, error) {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+	messages := mh.expire(mh.rooms[room])
+	if len(messages) == 0 {
+		// rooms do not outlive their history
+		delete(mh.rooms, room)
+		return nil, nil
+	}
+
+	mh.rooms[room] = messages
+	return append([]HistoryMessage(nil), messages...), nil
+}
+
+// expire drops the messages older than the window.
+func (mh *MemoryHistory) expire(messages []HistoryMessage) []HistoryMessage {
+	if mh.Window <= 0 {
+		return messages
+	}
+
+	cutoff := time.Now().Add(-mh.Window)
+	i := 0
+	for i < len(messages) && messages[i].Time.Before(cutoff) {
+		i++
+	}
+
+	return messages[i:]
+}
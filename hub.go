@@ -0,0 +1,895 @@
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"iter"
+	"runtime/pprof"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Hub is a set of connections that messages can be broadcast to, optionally grouped
+// in named rooms. Connections leave the hub, and all of its rooms, when they are
+// unregistered or closed.
+type Hub struct {
+	mu      sync.RWMutex
+	members map[*Websocket]*member
+	rooms   map[string]map[*Websocket]struct{}
+	closed  bool
+
+	// shards split the members for delivery; with more than one, each has a worker
+	// delivering broadcasts to its members in parallel with the others.
+	shards   []*shard
+	next     int
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	// delivering counts the broadcasts between choosing their members and enqueueing the
+	// message to them, which Shutdown waits for.
+	delivering sync.WaitGroup
+
+	// enqueueTimeout bounds the wait of a delivery for room in the queues of a shard,
+	// see WithEnqueueTimeout.
+	enqueueTimeout time.Duration
+
+	idOnce sync.Once
+	id     string
+
+	// hooksMu guards the hooks and the bridge.
+	hooksMu        sync.RWMutex
+	bridge         Bridge
+	joinHooks      []func(ws *Websocket, room string) error
+	leaveHooks     []func(ws *Websocket, room string)
+	broadcastHooks []func(ctx context.Context, msg *Outbound) error
+	shutdownHooks  []func(ctx context.Context, stage ShutdownStage) error
+
+	// history keeps the messages broadcast to rooms, if set by WithHistory. histories
+	// order the broadcasts to each room with the joins replaying its history, and are
+	// guarded by historyMu.
+	history   HistoryStore
+	historyMu sync.Mutex
+	histories map[string]*roomHistory
+
+	// profileLabels tags the deliveries to rooms with profiler labels, if set by
+	// WithProfileLabels.
+	profileLabels bool
+
+	// store keeps the messages published with PublishDurable, if set by WithMessageStore.
+	store MessageStore
+
+	// identify and thresholds configure usage accounting, see WithUsageAccounting.
+	// usageMu guards the usage of each identity.
+	identify   func(ws *Websocket) string
+	thresholds []usageThreshold
+	usageMu    sync.Mutex
+	usage      map[string]*identityUsage
+}
+
+// Outbound is a message about to be broadcast, as seen by the BeforeBroadcast hooks.
+type Outbound struct {
+	// Room is the room the message is broadcast to, empty for the whole hub.
+	Room string
+
+	// Type and Data are the message; hooks may replace them.
+	Type MessageType
+	Data []byte
+}
+
+// member is the state the hub keeps for a connection.
+type member struct {
+	// stop cancels the removal of the member once its connection closes.
+	stop func() bool
+
+	// rooms are the names of the rooms the member joined.
+	rooms map[string]struct{}
+
+	// shard is the index of the shard the member is delivered by.
+	shard int
+
+	// tags are the tags attached to the member with Tag.
+	tags map[string]struct{}
+
+	// usage, if set, is the usage of the identity of the member, see WithUsageAccounting.
+	usage *identityUsage
+}
+
+// HubOption configures a Hub created by NewHub.
+type HubOption func(*Hub)
+
+// WithShards splits the members of the hub across n shards, each with a worker goroutine
+// delivering broadcasts to its members, so broadcasting to hundreds of thousands of
+// connections does not run on one goroutine. At most n deliveries run at once.
+// The workers run until the hub is closed.
+func WithShards(n int) HubOption {
+	return func(h *Hub) {
+		h.shards = make([]*shard, max(n, 1))
+	}
+}
+
+// defaultEnqueueTimeout is how long a delivery waits for room in the full send queues
+// of the members of a shard when WithEnqueueTimeout is not given.
+const defaultEnqueueTimeout = time.Second
+
+// WithEnqueueTimeout bounds how long a broadcast waits for room in the send queues of
+// the members of a shard whose overflow policy is OverflowBlock. The timeout runs from
+// the start of the delivery to the shard, so it is shared by all its members rather
+// than added up over them. Members whose queue is still full then are closed with
+// TryAgainLater and removed from the hub. A timeout of zero or less does not wait at
+// all. It defaults to one second.
+func WithEnqueueTimeout(d time.Duration) HubOption {
+	return func(h *Hub) {
+		h.enqueueTimeout = max(d, 0)
+	}
+}
+
+// NewHub returns an empty Hub.
+func NewHub(opts ...HubOption) *Hub {
+	h := &Hub{
+		members: map[*Websocket]*member{},
+		rooms:   map[string]map[*Websocket]struct{}{},
+		shards:  make([]*shard, 1),
+		stop:    make(chan struct{}),
+
+		enqueueTimeout: defaultEnqueueTimeout,
+	}
+
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	for i := range h.shards {
+		h.shards[i] = &shard{jobs: make(chan delivery)}
+		if len(h.shards) > 1 {
+			go h.shards[i].work(h)
+		}
+	}
+
+	return h
+}
+
+// ShardStats describe the deliveries made by a shard of a hub.
+type ShardStats struct {
+	// Members is the number of connections assigned to the shard.
+	Members int
+
+	// Broadcasts is the number of broadcasts the shard delivered.
+	Broadcasts uint64
+
+	// Delivered is the number of messages added to the send queue of a member.
+	Delivered uint64
+
+	// Dropped is the number of messages not added to the send queue of an open member,
+	// because it was full or the broadcast was cancelled.
+	Dropped uint64
+
+	// Removed is the number of members removed because they were found closed, or were
+	// closed for their queue staying full.
+	Removed uint64
+
+	// LastDuration is how long the last broadcast took to deliver.
+	LastDuration time.Duration
+}
+
+// ShardStats gives the statistics of each shard of the hub. A hub created without
+// WithShards has a single shard.
+func (h *Hub) ShardStats() []ShardStats {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	stats := make([]ShardStats, len(h.shards))
+	for i, sh := range h.shards {
+		stats[i] = ShardStats{
+			Members:      sh.members,
+			Broadcasts:   sh.broadcasts.Load(),
+			Delivered:    sh.delivered.Load(),
+			Dropped:      sh.dropped.Load(),
+			Removed:      sh.removed.Load(),
+			LastDuration: time.Duration(sh.lastDuration.Load()),
+		}
+	}
+
+	return stats
+}
+
+// OnJoin registers a hook called before ws is registered with the hub, with an empty
+// room, and before it joins a room. An error stops the connection from joining and is
+// returned by Register or Join. Hooks run in the order they were registered.
+func (h *Hub) OnJoin(f func(ws *Websocket, room string) error) {
+	h.hooksMu.Lock()
+	defer h.hooksMu.Unlock()
+	h.joinHooks = append(h.joinHooks, f)
+}
+
+// OnLeave registers a hook called after ws left a room, and with an empty room after
+// it left the hub, whether it was unregistered or its connection closed.
+func (h *Hub) OnLeave(f func(ws *Websocket, room string)) {
+	h.hooksMu.Lock()
+	defer h.hooksMu.Unlock()
+	h.leaveHooks = append(h.leaveHooks, f)
+}
+
+// BeforeBroadcast registers a hook called with every message before it is broadcast,
+// to filter, enrich or audit it. The hook may replace the type and data of the message.
+// An error stops the broadcast and is returned by it, except DropMessage, which
+// silently drops the message.
+func (h *Hub) BeforeBroadcast(f func(ctx context.Context, msg *Outbound) error) {
+	h.hooksMu.Lock()
+	defer h.hooksMu.Unlock()
+	h.broadcastHooks = append(h.broadcastHooks, f)
+}
+
+// Register adds ws to the hub. It is removed again once its connection is closed.
+// Registering a connection twice has no effect.
+func (h *Hub) Register(ws *Websocket) error {
+	h.mu.RLock()
+	_, registered := h.members[ws]
+	h.mu.RUnlock()
+	if registered {
+		return nil
+	}
+
+	err := h.joining(ws, "")
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err = h.register(ws)
+	return err
+}
+
+// register adds ws to the hub if it is not a member yet. h.mu must be held.
+func (h *Hub) register(ws *Websocket) (*member, error) {
+	if h.closed {
+		return nil, HubClosed
+	}
+
+	if ws.State() == StateClosed {
+		return nil, ErrConnectionClosed
+	}
+
+	m, ok := h.members[ws]
+	if ok {
+		return m, nil
+	}
+
+	if h.members == nil {
+		h.members = map[*Websocket]*member{}
+	}
+
+	m = &member{
+		stop: context.AfterFunc(ws.Context(), func() {
+			h.Unregister(ws)
+		}),
+		rooms: map[string]struct{}{},
+	}
+
+	if len(h.shards) > 0 {
+		m.shard = h.next % len(h.shards)
+		h.next++
+		h.shards[m.shard].members++
+	}
+
+	h.account(ws, m)
+	h.members[ws] = m
+	return m, nil
+}
+
+// Unregister removes ws from the hub and its rooms, without closing it.
+func (h *Hub) Unregister(ws *Websocket) {
+	h.mu.Lock()
+	m, ok := h.members[ws]
+	if !ok {
+		h.mu.Unlock()
+		return
+	}
+
+	m.stop()
+	h.unaccount(ws, m)
+	if len(h.shards) > 0 {
+		h.shards[m.shard].members--
+	}
+
+	rooms := make([]string, 0, len(m.rooms))
+	for room := range m.rooms {
+		h.leave(room, ws)
+		rooms = append(rooms, room)
+	}
+
+	delete(h.members, ws)
+	h.mu.Unlock()
+
+	for _, room := range rooms {
+		h.left(ws, room)
+	}
+
+	h.left(ws, "")
+}
+
+// Join adds ws to the room, registering it with the hub if needed. Rooms are created
+// on first join. If the hub keeps history, the history of the room is queued to ws; an
+// error loading it is returned, ws having joined the room regardless.
+func (h *Hub) Join(room string, ws *Websocket) error {
+	h.mu.RLock()
+	_, registered := h.members[ws]
+	_, joined := h.rooms[room][ws]
+	h.mu.RUnlock()
+	if joined {
+		return nil
+	}
+
+	if !registered {
+		err := h.joining(ws, "")
+		if err != nil {
+			return err
+		}
+	}
+
+	err := h.joining(ws, room)
+	if err != nil {
+		return err
+	}
+
+	if h.history == nil {
+		_, err = h.join(room, ws)
+		return err
+	}
+
+	rh := h.acquireHistory(room)
+	defer h.releaseHistory(room, rh)
+	rh.mu.Lock()
+	joined, err = h.join(room, ws)
+	if err != nil || !joined {
+		rh.mu.Unlock()
+		return err
+	}
+
+	// the broadcasts made from now on are held back until the history is replayed
+	rh.replaying[ws] = nil
+	recording := slices.Clone(rh.recording)
+	rh.mu.Unlock()
+	return h.replay(room, ws, rh, recording)
+}
+
+// join adds ws to the members of the room, and reports whether it was not one already.
+func (h *Hub) join(room string, ws *Websocket) (bool, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	m, err := h.register(ws)
+	if err != nil {
+		return false, err
+	}
+
+	if h.rooms == nil {
+		h.rooms = map[string]map[*Websocket]struct{}{}
+	}
+
+	members, ok := h.rooms[room]
+	if !ok {
+		members = map[*Websocket]struct{}{}
+		h.rooms[room] = members
+	}
+
+	_, joined := members[ws]
+	members[ws] = struct{}{}
+	m.rooms[room] = struct{}{}
+	return !joined, nil
+}
+
+// Leave removes ws from the room; it stays registered with the hub.
+// Rooms are deleted once their last member leaves.
+func (h *Hub) Leave(room string, ws *Websocket) {
+	h.mu.Lock()
+	m, ok := h.members[ws]
+	if !ok {
+		h.mu.Unlock()
+		return
+	}
+
+	_, joined := m.rooms[room]
+	delete(m.rooms, room)
+	h.leave(room, ws)
+	h.mu.Unlock()
+
+	if joined {
+		h.left(ws, room)
+	}
+}
+
+// leave removes ws from the members of the room, deleting the room if it is empty. h.mu must be held.
+func (h *Hub) leave(room string, ws *Websocket) {
+	members, ok := h.rooms[room]
+	if !ok {
+		return
+	}
+
+	delete(members, ws)
+	if len(members) == 0 {
+		delete(h.rooms, room)
+	}
+}
+
+// Len gives the number of connections in the hub.
+func (h *Hub) Len() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.members)
+}
+
+// Members gives the connections in the hub, in no particular order.
+func (h *Hub) Members() []*Websocket {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	members := make([]*Websocket, 0, len(h.members))
+	for ws := range h.members {
+		members = append(members, ws)
+	}
+
+	return members
+}
+
+// Rooms gives the names of the rooms that have members, in no particular order.
+func (h *Hub) Rooms() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	rooms := make([]string, 0, len(h.rooms))
+	for room := range h.rooms {
+		rooms = append(rooms, room)
+	}
+
+	return rooms
+}
+
+// RoomMembers gives the connections in the room, in no particular order.
+func (h *Hub) RoomMembers(room string) []*Websocket {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	members := make([]*Websocket, 0, len(h.rooms[room]))
+	for ws := range h.rooms[room] {
+		members = append(members, ws)
+	}
+
+	return members
+}
+
+// Broadcast sends data as a message of type t to every connection in the hub.
+// See BroadcastPrepared.
+func (h *Hub) Broadcast(ctx context.Context, t MessageType, data []byte) error {
+	pm, err := NewPreparedMessage(t, data)
+	if err != nil {
+		return err
+	}
+
+	return h.BroadcastPrepared(ctx, pm)
+}
+
+// BroadcastPrepared adds the prepared message to the send queue of every connection
+// in the hub, so the frames are built once and a slow member does not delay the others.
+// Members whose queue is full are handled by their overflow policy; with OverflowBlock
+// the broadcast waits for room within ctx and the enqueue timeout of the hub, after
+// which the member is closed as a slow client. Members that fail are skipped, and
+// removed from the hub if they are closed.
+func (h *Hub) BroadcastPrepared(ctx context.Context, pm *PreparedMessage) error {
+	return h.BroadcastPreparedTo(ctx, Target{}, pm)
+}
+
+// BroadcastToRoom sends data as a message of type t to every connection in the room,
+// like Broadcast. Broadcasting to a room without members does nothing, besides
+// recording the message if the hub keeps history.
+func (h *Hub) BroadcastToRoom(ctx context.Context, room string, t MessageType, data []byte) error {
+	return h.BroadcastTo(ctx, Target{Room: room}, t, data)
+}
+
+// Target selects the members of a hub a broadcast is delivered to, for example
+// everyone in a room except the sender, or only the connections tagged admin.
+// The zero Target selects every member.
+type Target struct {
+	// Room, if set, restricts the broadcast to the members of the room.
+	Room string
+
+	// Tags, if set, restricts the broadcast to the members carrying all of the tags.
+	Tags []string
+
+	// Except are members left out of the broadcast, such as the sender of the message.
+	Except []*Websocket
+
+	// Match, if set, is called for every member left by the fields above, and only
+	// those it reports true for receive the broadcast. It is called without any lock
+	// of the hub held, so it may use the hub.
+	Match func(ws *Websocket) bool
+}
+
+// BroadcastTo sends data as a message of type t to the members selected by target,
+// like Broadcast.
+func (h *Hub) BroadcastTo(ctx context.Context, target Target, t MessageType, data []byte) error {
+	pm, err := NewPreparedMessage(t, data)
+	if err != nil {
+		return err
+	}
+
+	return h.BroadcastPreparedTo(ctx, target, pm)
+}
+
+// BroadcastPreparedTo adds the prepared message to the send queue of the members selected
+// by target, like BroadcastPrepared. If the hub is bridged, the message is also published
+// to the other hubs, unless target has a Match function, which only applies locally.
+func (h *Hub) BroadcastPreparedTo(ctx context.Context, target Target, pm *PreparedMessage) error {
+	pm, err := h.beforeBroadcast(ctx, target.Room, pm)
+	if pm == nil || err != nil {
+		return err
+	}
+
+	recordErr, err := h.deliverTo(ctx, target, pm)
+	if err == nil && target.Match == nil {
+		err = h.publish(ctx, target, pm)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return recordErr
+}
+
+// deliverTo enqueues the prepared message to the local members selected by target, and
+// records it in the history. An error recording it is returned apart, the message being
+// delivered regardless.
+func (h *Hub) deliverTo(ctx context.Context, target Target, pm *PreparedMessage) (recordErr, err error) {
+	except := make(map[*Websocket]struct{}, len(target.Except))
+	for _, ws := range target.Except {
+		except[ws] = struct{}{}
+	}
+
+	var rh *roomHistory
+	var msg *HistoryMessage
+	if h.records(target) {
+		rh = h.acquireHistory(target.Room)
+		defer h.releaseHistory(target.Room, rh)
+		msg = &HistoryMessage{Type: pm.t, Data: pm.data, Time: time.Now()}
+		rh.mu.Lock()
+	}
+
+	h.mu.RLock()
+	if h.closed {
+		h.mu.RUnlock()
+		if rh != nil {
+			rh.mu.Unlock()
+		}
+
+		return nil, HubClosed
+	}
+
+	h.delivering.Add(1)
+	defer h.delivering.Done()
+	candidates := func(yield func(*Websocket, *member) bool) {
+		for ws, m := range h.members {
+			if !yield(ws, m) {
+				return
+			}
+		}
+	}
+
+	if target.Room != "" {
+		candidates = func(yield func(*Websocket, *member) bool) {
+			for ws := range h.rooms[target.Room] {
+				if !yield(ws, h.members[ws]) {
+					return
+				}
+			}
+		}
+	}
+
+	groups := h.group(func(yield func(*Websocket, *member) bool) {
+		for ws, m := range candidates {
+			if _, ok := except[ws]; ok || !m.tagged(target.Tags) {
+				continue
+			}
+
+			if rh != nil && rh.hold(ws, msg, pm, target.Match) {
+				continue
+			}
+
+			if !yield(ws, m) {
+				return
+			}
+		}
+	})
+	h.mu.RUnlock()
+
+	if rh != nil {
+		recordErr = h.record(ctx, target.Room, rh, msg)
+	}
+
+	if target.Match != nil {
+		for i, members := range groups {
+			groups[i] = slices.DeleteFunc(members, func(ws *Websocket) bool {
+				return !target.Match(ws)
+			})
+		}
+	}
+
+	return recordErr, h.deliverToRoom(ctx, target.Room, pm, groups)
+}
+
+// group splits the members by the shard delivering to them. h.mu must be held.
+func (h *Hub) group(members iter.Seq2[*Websocket, *member]) [][]*Websocket {
+	groups := make([][]*Websocket, max(len(h.shards), 1))
+	for ws, m := range members {
+		groups[m.shard] = append(groups[m.shard], ws)
+	}
+
+	return groups
+}
+
+// Tag attaches the tags to ws, which must be registered with the hub, so broadcasts can
+// target it with Target.Tags.
+func (h *Hub) Tag(ws *Websocket, tags ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	m, ok := h.members[ws]
+	if !ok {
+		return
+	}
+
+	if m.tags == nil {
+		m.tags = map[string]struct{}{}
+	}
+
+	for _, tag := range tags {
+		m.tags[tag] = struct{}{}
+	}
+}
+
+// Untag removes the tags from ws.
+func (h *Hub) Untag(ws *Websocket, tags ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	m, ok := h.members[ws]
+	if !ok {
+		return
+	}
+
+	for _, tag := range tags {
+		delete(m.tags, tag)
+	}
+}
+
+// Tags gives the tags attached to ws, in no particular order.
+func (h *Hub) Tags(ws *Websocket) []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	m, ok := h.members[ws]
+	if !ok {
+		return nil
+	}
+
+	tags := make([]string, 0, len(m.tags))
+	for tag := range m.tags {
+		tags = append(tags, tag)
+	}
+
+	return tags
+}
+
+// tagged reports whether the member carries all of the tags.
+func (m *member) tagged(tags []string) bool {
+	for _, tag := range tags {
+		if _, ok := m.tags[tag]; !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// joining runs the join hooks for ws joining the room.
+func (h *Hub) joining(ws *Websocket, room string) error {
+	h.hooksMu.RLock()
+	hooks := h.joinHooks
+	h.hooksMu.RUnlock()
+	for _, hook := range hooks {
+		err := hook(ws, room)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// left runs the leave hooks for ws having left the room.
+func (h *Hub) left(ws *Websocket, room string) {
+	h.hooksMu.RLock()
+	hooks := h.leaveHooks
+	h.hooksMu.RUnlock()
+	for _, hook := range hooks {
+		hook(ws, room)
+	}
+}
+
+// beforeBroadcast runs the broadcast hooks for the prepared message, giving the message
+// to broadcast: pm itself, a new one if a hook replaced it, or nil if it was dropped.
+func (h *Hub) beforeBroadcast(ctx context.Context, room string, pm *PreparedMessage) (*PreparedMessage, error) {
+	h.hooksMu.RLock()
+	hooks := h.broadcastHooks
+	h.hooksMu.RUnlock()
+	if len(hooks) == 0 {
+		return pm, nil
+	}
+
+	msg := Outbound{Room: room, Type: pm.t, Data: pm.data}
+	for _, hook := range hooks {
+		err := hook(ctx, &msg)
+		if errors.Is(err, DropMessage) {
+			return nil, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if msg.Type == pm.t && bytes.Equal(msg.Data, pm.data) {
+		return pm, nil
+	}
+
+	return NewPreparedMessage(msg.Type, msg.Data)
+}
+
+// deliver enqueues the prepared message to the members grouped by shard, in parallel
+// if the hub has several shards.
+func (h *Hub) deliver(ctx context.Context, pm *PreparedMessage, groups [][]*Websocket) error {
+	if len(h.shards) <= 1 {
+		var sh *shard
+		if len(h.shards) == 1 {
+			sh = h.shards[0]
+		}
+
+		sh.deliver(h, delivery{ctx: ctx, pm: pm, members: groups[0]})
+		return ctx.Err()
+	}
+
+	wg := sync.WaitGroup{}
+	for i, members := range groups {
+		if len(members) == 0 {
+			continue
+		}
+
+		wg.Add(1)
+		job := delivery{ctx: ctx, pm: pm, members: members, wg: &wg}
+		select {
+		case h.shards[i].jobs <- job:
+		case <-h.stop:
+			wg.Done()
+		case <-ctx.Done():
+			wg.Done()
+		}
+	}
+
+	wg.Wait()
+	return ctx.Err()
+}
+
+// delivery is a broadcast to the members of a shard.
+type delivery struct {
+	ctx     context.Context
+	pm      *PreparedMessage
+	members []*Websocket
+	wg      *sync.WaitGroup
+}
+
+// shard is a group of members of a hub, delivered to by the same worker.
+type shard struct {
+	jobs chan delivery
+
+	// members is the number of members assigned to the shard, guarded by the mutex of the hub.
+	members int
+
+	broadcasts   atomic.Uint64
+	delivered    atomic.Uint64
+	dropped      atomic.Uint64
+	removed      atomic.Uint64
+	lastDuration atomic.Int64
+}
+
+// work delivers the broadcasts sent to the shard until the hub is closed.
+func (sh *shard) work(h *Hub) {
+	for {
+		select {
+		case job := <-sh.jobs:
+			if h.profileLabels {
+				pprof.SetGoroutineLabels(job.ctx)
+			}
+
+			sh.deliver(h, job)
+		case <-h.stop:
+			return
+		}
+	}
+}
+
+// deliver enqueues the message of the job to its members, removing those that are closed
+// and those whose queue stays full for the enqueue timeout of the hub. A nil shard
+// delivers without recording statistics.
+func (sh *shard) deliver(h *Hub, job delivery) {
+	if job.wg != nil {
+		defer job.wg.Done()
+	}
+
+	started := time.Now()
+	// one member blocking the worker would hold up the broadcast for all the others
+	blockUntil := func(m *queuedMessage) {
+		m.blockUntil = started.Add(h.enqueueTimeout)
+	}
+
+	var delivered, dropped, removed uint64
+	for _, ws := range job.members {
+		err := ws.EnqueuePrepared(job.ctx, job.pm, blockUntil)
+		switch {
+		case err == nil:
+			delivered++
+		case errors.Is(err, ErrConnectionClosed):
+			h.Unregister(ws)
+			removed++
+		case errors.Is(err, SlowClient):
+			// the Close frame waits for the writes ahead of it, which the worker must not
+			go ws.fail(TryAgainLater, err)
+			h.Unregister(ws)
+			removed++
+		default:
+			dropped++
+		}
+	}
+
+	if sh == nil {
+		return
+	}
+
+	sh.broadcasts.Add(1)
+	sh.delivered.Add(delivered)
+	sh.dropped.Add(dropped)
+	sh.removed.Add(removed)
+	sh.lastDuration.Store(int64(time.Since(started)))
+}
+
+// Close closes every connection in the hub with GoingAway and empties it, for shutdown.
+// Connections can no longer be registered nor messages broadcast afterwards.
+func (h *Hub) Close() error {
+	h.mu.Lock()
+	if h.stop != nil {
+		h.stopOnce.Do(func() {
+			close(h.stop)
+		})
+	}
+
+	h.closed = true
+	members := h.members
+	h.members = nil
+	h.rooms = nil
+	for _, sh := range h.shards {
+		sh.members = 0
+	}
+	h.mu.Unlock()
+
+	var errs []error
+	for ws, m := range members {
+		m.stop()
+		h.unaccount(ws, m)
+		err := ws.CloseWithCode(GoingAway, "")
+		if err != nil && !errors.Is(err, ErrConnectionClosed) {
+			errs = append(errs, err)
+		}
+
+		for room := range m.rooms {
+			h.left(ws, room)
+		}
+
+		h.left(ws, "")
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,113 @@
+package websocket
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"net/http"
+)
+
+// Request gives the HTTP request the connection was upgraded from, so handlers can
+// route and authorize on its URL, headers, TLS state and remote address after the
+// upgrade. The request body is not available once the connection is hijacked.
+func (ws *Websocket) Request() *http.Request {
+	return ws.request
+}
+
+// Context gives the context of the connection. It carries the values of the upgrade
+// request context, or of the context returned by WSOpener.Authorize, and is cancelled
+// once the connection is closed.
+func (ws *Websocket) Context() context.Context {
+	if ws.ctx == nil {
+		return context.Background()
+	}
+
+	return ws.ctx
+}
+
+// ID gives the random identifier of the connection, for looking it up with
+// ConnectionManager.Lookup and for correlating logs.
+func (ws *Websocket) ID() string {
+	ws.idOnce.Do(func() {
+		ws.id = randomID()
+	})
+
+	return ws.id
+}
+
+// randomID generates a random 64-bit identifier, hex encoded.
+func randomID() string {
+	b := make([]byte, 8)
+	// crypto/rand only fails when the system has no entropy source at all
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Extension is a protocol extension negotiated in the handshake, with its parameters.
+type Extension struct {
+	Name   string
+	Params map[string]string
+}
+
+// Subprotocol gives the subprotocol selected during the handshake, or an empty string
+// if the client requested none of the server's WSOpener.Subprotocols.
+func (ws *Websocket) Subprotocol() string {
+	return ws.subprotocol
+}
+
+// NegotiatedExtensions gives the extensions active on the connection.
+// The package does not implement any extension yet, so the list is always empty.
+func (ws *Websocket) NegotiatedExtensions() []Extension {
+	return ws.extensions
+}
+
+// RemoteAddr gives the network address of the client. Event streams, which are not
+// hijacked, give the RemoteAddr of their request.
+func (ws *Websocket) RemoteAddr() net.Addr {
+	if ws.conn != nil {
+		return ws.conn.RemoteAddr()
+	}
+
+	if ws.request == nil {
+		return nil
+	}
+
+	return requestAddr(ws.request.RemoteAddr)
+}
+
+// remoteAddr gives the address of the client as a string, empty if it is unknown.
+func (ws *Websocket) remoteAddr() string {
+	addr := ws.RemoteAddr()
+	if addr == nil {
+		return ""
+	}
+
+	return addr.String()
+}
+
+// LocalAddr gives the network address the client connected to. Event streams give the
+// address the server accepted their request on, or nil if it is unknown.
+func (ws *Websocket) LocalAddr() net.Addr {
+	if ws.conn != nil {
+		return ws.conn.LocalAddr()
+	}
+
+	if ws.request == nil {
+		return nil
+	}
+
+	addr, _ := ws.request.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return addr
+}
+
+// requestAddr is the remote address of an HTTP request, as set by the server.
+type requestAddr string
+
+func (a requestAddr) Network() string {
+	return "tcp"
+}
+
+func (a requestAddr) String() string {
+	return string(a)
+}
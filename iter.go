@@ -0,0 +1,39 @@
+package websocket
+
+import (
+	"context"
+	"iter"
+)
+
+// Messages returns an iterator over the messages received from the client, for use
+// with range:
+//
+//	for msg, err := range ws.Messages(ctx) {
+//		if err != nil {
+//			return err
+//		}
+//		...
+//	}
+//
+// Iteration ends without an error when the connection is closed with a closing
+// handshake or by a call to Close, or when ctx is done. Any other failure is yielded
+// once as the error of a zero Message, and ends the iteration.
+func (ws *Websocket) Messages(ctx context.Context) iter.Seq2[Message, error] {
+	return func(yield func(Message, error) bool) {
+		for {
+			t, data, err := ws.receiveMessage(ctx)
+			if err != nil {
+				if isCleanClose(err) || ctx.Err() != nil {
+					return
+				}
+
+				yield(Message{}, err)
+				return
+			}
+
+			if !yield(Message{Type: t, Data: data}, nil) {
+				return
+			}
+		}
+	}
+}
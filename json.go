@@ -0,0 +1,64 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// JSONOptions configures how ReadJSON and WriteJSON encode and decode values.
+// The zero value behaves like encoding/json defaults.
+type JSONOptions struct {
+	// DisallowUnknownFields makes ReadJSON fail when an object contains keys
+	// that do not match any exported field of the destination.
+	DisallowUnknownFields bool
+
+	// UseNumber makes ReadJSON decode numbers into an interface{} as json.Number instead of float64.
+	UseNumber bool
+
+	// DisableHTMLEscape stops WriteJSON from escaping <, > and & inside strings.
+	DisableHTMLEscape bool
+
+	// Indent, if set, makes WriteJSON indent nested elements with it.
+	Indent string
+}
+
+// SetJSONOptions replaces the options used by ReadJSON and WriteJSON.
+func (ws *Websocket) SetJSONOptions(opts JSONOptions) {
+	ws.jsonOptions = opts
+}
+
+// WriteJSON sends the JSON encoding of v as a text message.
+func (ws *Websocket) WriteJSON(ctx context.Context, v any) error {
+	data, _, err := JSONCodec{Options: ws.jsonOptions}.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return ws.SendText(ctx, data)
+}
+
+// ReadJSON waits for the next message and decodes it as JSON into v.
+// The message is decoded as it is read from the connection.
+func (ws *Websocket) ReadJSON(ctx context.Context, v any) (err error) {
+	if ws.State() == StateClosed {
+		return ErrConnectionClosed
+	}
+
+	defer ws.checkError(&err)
+	defer ws.readDeadline.bind(ctx)(&err)
+	_, r, err := ws.nextReader()
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(r)
+	if ws.jsonOptions.DisallowUnknownFields {
+		decoder.DisallowUnknownFields()
+	}
+
+	if ws.jsonOptions.UseNumber {
+		decoder.UseNumber()
+	}
+
+	return decoder.Decode(v)
+}
@@ -0,0 +1,438 @@
+// Package jsonrpc serves JSON-RPC 2.0 over websocket connections: requests, notifications
+// and batches from the client, answered with results or error objects, and calls from
+// the server to the client on the same connection.
+//
+// Servers select the protocol by listing Subprotocol in WSOpener.Subprotocols.
+package jsonrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ajsqr/websocket"
+)
+
+// Version is the value of the jsonrpc member of every request and response.
+const Version = "2.0"
+
+// Subprotocol is the name under which clients request JSON-RPC 2.0 in the
+// Sec-WebSocket-Protocol header.
+const Subprotocol = "jsonrpc"
+
+// The error codes defined by the specification.
+const (
+	ParseError     = -32700
+	InvalidRequest = -32600
+	MethodNotFound = -32601
+	InvalidParams  = -32602
+	InternalError  = -32603
+)
+
+// The limits Server uses when MaxConcurrency or MaxBatchSize is not set.
+const (
+	defaultMaxConcurrency = 64
+	defaultMaxBatchSize   = 100
+)
+
+// Request is a request or, without an ID, a notification.
+type Request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+}
+
+// IsNotification reports whether the request is a notification, which is not answered.
+func (r *Request) IsNotification() bool {
+	return len(r.ID) == 0
+}
+
+// Response answers a request with either its result or an error.
+type Response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// Error is a JSON-RPC error object. Handlers return one to choose the code sent to
+// the client; any other error is sent as an InternalError.
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    any    `json:"data,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return "jsonrpc: " + strconv.Itoa(e.Code) + " " + e.Message
+}
+
+// Handler answers a request with its result. The result of a notification is discarded.
+type Handler func(ctx context.Context, c *Conn, params json.RawMessage) (any, error)
+
+// Server dispatches the requests of its connections to the handlers registered for
+// their method. Handlers may be registered concurrently with serving.
+type Server struct {
+	// MaxConcurrency is how many messages of a connection are handled at once. Serve
+	// stops reading the connection while that many are being handled, so a handler
+	// calling the client with Conn.Call when the limit is reached may only get its
+	// response once another handler returns. Zero uses a default of 64.
+	MaxConcurrency int
+
+	// MaxBatchSize is how many requests a batch may hold. Larger batches are answered
+	// with an InvalidRequest error. Zero uses a default of 100.
+	MaxBatchSize int
+
+	mu       sync.RWMutex
+	handlers map[string]Handler
+}
+
+// NewServer returns a Server without handlers.
+func NewServer() *Server {
+	return &Server{
+		handlers: map[string]Handler{},
+	}
+}
+
+// Handle registers h for requests to method, replacing any previous handler.
+func (s *Server) Handle(method string, h Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.handlers == nil {
+		s.handlers = map[string]Handler{}
+	}
+
+	s.handlers[method] = h
+}
+
+// Serve reads messages from ws and answers them until the connection closes or ctx is
+// done. Each message is handled in its own goroutine, up to MaxConcurrency at once, so
+// handlers may call back into the client with Conn.Call; the responses to those calls
+// are handed over as they are read. Serve returns nil when the connection was closed
+// cleanly.
+func (s *Server) Serve(ctx context.Context, ws *websocket.Websocket) error {
+	maxConcurrency := s.MaxConcurrency
+	if maxConcurrency <= 0 {
+		maxConcurrency = defaultMaxConcurrency
+	}
+
+	c := &Conn{
+		ws:      ws,
+		server:  s,
+		slots:   make(chan struct{}, maxConcurrency),
+		pending: map[string]chan Response{},
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for msg, err := range ws.Messages(ctx) {
+		if err != nil {
+			return err
+		}
+
+		if c.resolveMessage(msg.Data) {
+			continue
+		}
+
+		select {
+		case c.slots <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer c.release()
+			c.handle(ctx, msg.Data)
+		}()
+	}
+
+	return nil
+}
+
+// Conn is a connection served by a Server, through which handlers call the client.
+type Conn struct {
+	ws     *websocket.Websocket
+	server *Server
+
+	// slots holds a value for each message or batch element being handled.
+	slots chan struct{}
+
+	mu      sync.Mutex
+	pending map[string]chan Response
+	nextID  atomic.Int64
+}
+
+// Websocket gives the underlying connection.
+func (c *Conn) Websocket() *websocket.Websocket {
+	return c.ws
+}
+
+// Call calls method on the client with params and decodes the result into result,
+// unless result is nil. It returns the *Error sent by the client if the call failed,
+// and ctx.Err() if ctx is done before the response arrives.
+func (c *Conn) Call(ctx context.Context, method string, params any, result any) error {
+	raw, err := marshalParams(params)
+	if err != nil {
+		return err
+	}
+
+	id := strconv.FormatInt(c.nextID.Add(1), 10)
+	response := make(chan Response, 1)
+	c.mu.Lock()
+	c.pending[id] = response
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	err = c.ws.WriteJSON(ctx, Request{JSONRPC: Version, Method: method, Params: raw, ID: json.RawMessage(id)})
+	if err != nil {
+		return err
+	}
+
+	select {
+	case r := <-response:
+		if r.Error != nil {
+			return r.Error
+		}
+
+		if result == nil || len(r.Result) == 0 {
+			return nil
+		}
+
+		return json.Unmarshal(r.Result, result)
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.ws.Context().Done():
+		return websocket.ErrConnectionClosed
+	}
+}
+
+// Notify sends a notification of method with params to the client.
+func (c *Conn) Notify(ctx context.Context, method string, params any) error {
+	raw, err := marshalParams(params)
+	if err != nil {
+		return err
+	}
+
+	return c.ws.WriteJSON(ctx, Request{JSONRPC: Version, Method: method, Params: raw})
+}
+
+// handle answers a message, which holds a request, a response or a batch of them.
+func (c *Conn) handle(ctx context.Context, data []byte) {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		c.handleBatch(ctx, data)
+		return
+	}
+
+	response, ok := c.handleOne(ctx, data)
+	if ok {
+		_ = c.ws.WriteJSON(ctx, response)
+	}
+}
+
+// handleBatch answers a batch with the array of the responses to its requests;
+// a batch of notifications and responses is not answered.
+func (c *Conn) handleBatch(ctx context.Context, data []byte) {
+	var batch []json.RawMessage
+	err := json.Unmarshal(data, &batch)
+	if err != nil {
+		_ = c.ws.WriteJSON(ctx, errorResponse(nil, &Error{Code: ParseError, Message: err.Error()}))
+		return
+	}
+
+	if len(batch) == 0 {
+		_ = c.ws.WriteJSON(ctx, errorResponse(nil, &Error{Code: InvalidRequest, Message: "empty batch"}))
+		return
+	}
+
+	maxBatchSize := c.server.MaxBatchSize
+	if maxBatchSize <= 0 {
+		maxBatchSize = defaultMaxBatchSize
+	}
+
+	if len(batch) > maxBatchSize {
+		_ = c.ws.WriteJSON(ctx, errorResponse(nil, &Error{Code: InvalidRequest, Message: "batch of " + strconv.Itoa(len(batch)) + " requests exceeds the limit of " + strconv.Itoa(maxBatchSize)}))
+		return
+	}
+
+	responses := make([]Response, len(batch))
+	answered := make([]bool, len(batch))
+	var wg sync.WaitGroup
+	for i, message := range batch {
+		// The elements take the free slots of the connection, and those left once no
+		// slot is free are handled by the goroutine of the batch.
+		select {
+		case c.slots <- struct{}{}:
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer c.release()
+				responses[i], answered[i] = c.handleOne(ctx, message)
+			}()
+		default:
+			responses[i], answered[i] = c.handleOne(ctx, message)
+		}
+	}
+
+	wg.Wait()
+	var out []Response
+	for i, response := range responses {
+		if answered[i] {
+			out = append(out, response)
+		}
+	}
+
+	if len(out) > 0 {
+		_ = c.ws.WriteJSON(ctx, out)
+	}
+}
+
+// handleOne runs the handler of a request and gives its response, or hands a response
+// to the pending call. It reports whether there is a response to send.
+func (c *Conn) handleOne(ctx context.Context, data []byte) (Response, bool) {
+	var message struct {
+		Request
+		Result json.RawMessage `json:"result"`
+		Error  *Error          `json:"error"`
+	}
+
+	err := json.Unmarshal(data, &message)
+	if err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return errorResponse(nil, &Error{Code: ParseError, Message: err.Error()}), true
+		}
+
+		return errorResponse(nil, &Error{Code: InvalidRequest, Message: err.Error()}), true
+	}
+
+	if message.Method == "" && (message.Result != nil || message.Error != nil) {
+		c.resolve(Response{JSONRPC: message.JSONRPC, Result: message.Result, Error: message.Error, ID: message.ID})
+		return Response{}, false
+	}
+
+	request := message.Request
+	if request.JSONRPC != Version || request.Method == "" || !validID(request.ID) {
+		return errorResponse(request.ID, &Error{Code: InvalidRequest, Message: "invalid request"}), !request.IsNotification()
+	}
+
+	c.server.mu.RLock()
+	h, ok := c.server.handlers[request.Method]
+	c.server.mu.RUnlock()
+	if !ok {
+		return errorResponse(request.ID, &Error{Code: MethodNotFound, Message: "method not found: " + request.Method}), !request.IsNotification()
+	}
+
+	result, err := h(ctx, c, request.Params)
+	if request.IsNotification() {
+		return Response{}, false
+	}
+
+	if err != nil {
+		rpcErr := &Error{}
+		if !errors.As(err, &rpcErr) {
+			rpcErr = &Error{Code: InternalError, Message: err.Error()}
+		}
+
+		return errorResponse(request.ID, rpcErr), true
+	}
+
+	raw, err := json.Marshal(result)
+	if err != nil {
+		return errorResponse(request.ID, &Error{Code: InternalError, Message: err.Error()}), true
+	}
+
+	return Response{JSONRPC: Version, Result: raw, ID: request.ID}, true
+}
+
+// resolveMessage hands the message to the call waiting for it if it is a single
+// response, and reports whether it was one.
+func (c *Conn) resolveMessage(data []byte) bool {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || data[0] != '{' {
+		return false
+	}
+
+	var message struct {
+		JSONRPC string          `json:"jsonrpc"`
+		Method  string          `json:"method"`
+		Result  json.RawMessage `json:"result"`
+		Error   *Error          `json:"error"`
+		ID      json.RawMessage `json:"id"`
+	}
+
+	err := json.Unmarshal(data, &message)
+	if err != nil || message.Method != "" || message.Result == nil && message.Error == nil {
+		return false
+	}
+
+	c.resolve(Response{JSONRPC: message.JSONRPC, Result: message.Result, Error: message.Error, ID: message.ID})
+	return true
+}
+
+// release frees the slot taken to handle a message or batch element.
+func (c *Conn) release() {
+	<-c.slots
+}
+
+// resolve hands a response to the call waiting for it, if any.
+func (c *Conn) resolve(r Response) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	response, ok := c.pending[string(r.ID)]
+	if !ok {
+		return
+	}
+
+	delete(c.pending, string(r.ID))
+	response <- r
+}
+
+// errorResponse answers the request with the given ID with err. The ID of requests
+// that could not be read is null.
+func errorResponse(id json.RawMessage, err *Error) Response {
+	if len(id) == 0 {
+		id = json.RawMessage("null")
+	}
+
+	return Response{JSONRPC: Version, Error: err, ID: id}
+}
+
+// validID reports whether id is absent, a string, a number or null, as the specification requires.
+func validID(id json.RawMessage) bool {
+	if len(id) == 0 {
+		return true
+	}
+
+	var v any
+	if json.Unmarshal(id, &v) != nil {
+		return false
+	}
+
+	switch v.(type) {
+	case string, float64, nil:
+		return true
+	default:
+		return false
+	}
+}
+
+// marshalParams encodes the params of a call, omitting them when there are none.
+func marshalParams(params any) (json.RawMessage, error) {
+	if params == nil {
+		return nil, nil
+	}
+
+	return json.Marshal(params)
+}
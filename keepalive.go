@@ -0,0 +1,145 @@
+package websocket
+
+import (
+	"context"
+	"encoding/binary"
+	"time"
+)
+
+// PongMatching decides which Pongs keepalive takes for the answer to its last Ping.
+type PongMatching string
+
+var (
+	// PongMatchNonce only takes a Pong echoing the payload of the last Ping, which carries
+	// a nonce, as RFC 6455 lets a Pong acknowledge the most recent Ping only.
+	PongMatchNonce PongMatching = "nonce"
+
+	// PongMatchAny takes any Pong received since the last Ping started to be sent,
+	// whatever its payload, for clients that do not echo the payload of Pings or answer them late.
+	PongMatchAny PongMatching = "any"
+)
+
+// keepalivePolicy is the configuration of keepalive.
+type keepalivePolicy struct {
+	interval    time.Duration
+	timeout     time.Duration
+	matching    PongMatching
+	missedPongs int
+}
+
+func (wso *WSOpener) keepalivePolicy() keepalivePolicy {
+	timeout := wso.PongTimeout
+	if timeout <= 0 {
+		timeout = wso.PingInterval
+	}
+
+	return keepalivePolicy{
+		interval:    wso.PingInterval,
+		timeout:     timeout,
+		matching:    wso.PongMatching,
+		missedPongs: max(wso.MissedPongs, 0),
+	}
+}
+
+// keepalive pings the client every interval and terminates the connection once more
+// than missedPongs Pings in a row were not answered by a Pong within timeout. Each Ping
+// carries a nonce, which only its Pong echoes. Pongs are only noticed while the
+// application reads from the connection, so a connection using keepalive must be read
+// from continuously.
+func (ws *Websocket) keepalive(p keepalivePolicy) {
+	ws.labelGoroutine()
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	missed := 0
+	for {
+		select {
+		case <-ws.done:
+			return
+		case <-ticker.C:
+		}
+
+		// the Pong may be read before Ping returns, so the Pongs are counted beforehand
+		pongs := ws.pongs.Load()
+		nonce := ws.pingNonce.Add(1)
+		ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+		err := ws.Ping(ctx, binary.BigEndian.AppendUint64(nil, nonce))
+		cancel()
+		if err != nil {
+			// a connection that cannot be written to is as dead as one that does not answer
+			_ = ws.terminate(AbnormalClosure, err)
+			return
+		}
+
+		wait := time.NewTimer(p.timeout)
+		select {
+		case <-ws.done:
+			wait.Stop()
+			return
+		case <-wait.C:
+		}
+
+		if ws.answered(p.matching, nonce, pongs) {
+			missed = 0
+			continue
+		}
+
+		missed++
+		if missed > p.missedPongs {
+			// the client is gone, there is no one to send a Close frame to
+			_ = ws.terminate(AbnormalClosure, MissedPong)
+			return
+		}
+	}
+}
+
+// answered reports whether the Ping carrying nonce was answered, pongs being the number
+// of Pongs received before it was sent.
+func (ws *Websocket) answered(matching PongMatching, nonce, pongs uint64) bool {
+	if matching == PongMatchAny {
+		return ws.pongs.Load() != pongs
+	}
+
+	return ws.pongNonce.Load() == nonce
+}
+
+// matchPong counts the Pong, and records its nonce if it answers the last Ping of
+// keepalive. Pongs answering earlier Pings and unsolicited ones are not recorded.
+func (ws *Websocket) matchPong(appData []byte) {
+	ws.pongs.Add(1)
+	if len(appData) != 8 {
+		return
+	}
+
+	nonce := binary.BigEndian.Uint64(appData)
+	if nonce == ws.pingNonce.Load() {
+		ws.pongNonce.Store(nonce)
+	}
+}
+
+// idleTimeout closes the connection with GoingAway once no application data has been
+// sent or received for timeout. Control frames do not count as activity.
+func (ws *Websocket) idleTimeout(timeout time.Duration) {
+	ws.labelGoroutine()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ws.done:
+			return
+		case <-timer.C:
+		}
+
+		idle := time.Since(time.Unix(0, ws.lastActivity.Load()))
+		if idle >= timeout {
+			ws.fail(GoingAway, ConnectionIdle)
+			return
+		}
+
+		timer.Reset(timeout - idle)
+	}
+}
+
+// touch records that application data was just sent or received.
+func (ws *Websocket) touch() {
+	ws.lastActivity.Store(time.Now().UnixNano())
+}
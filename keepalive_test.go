@@ -0,0 +1,172 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// answerPings reads the frames the server writes to client, and answers its n-th Ping
+// with the payload pong gives, if any. It reports the number of Pings read on pings.
+func answerPings(client net.Conn, pong func(n int, ping []byte) ([]byte, bool), pings chan<- int) {
+	header := make([]byte, 2)
+	for n := 1; ; {
+		_, err := io.ReadFull(client, header)
+		if err != nil {
+			return
+		}
+
+		payload := make([]byte, header[1]&0x7f)
+		_, err = io.ReadFull(client, payload)
+		if err != nil {
+			return
+		}
+
+		if header[0]&0x0f != 0x9 {
+			continue
+		}
+
+		answer, ok := pong(n, payload)
+		if ok {
+			_, err = client.Write(maskedFrame(true, Pong, answer))
+			if err != nil {
+				return
+			}
+		}
+
+		pings <- n
+		n++
+	}
+}
+
+// TestKeepalivePongMatching pings a client answering Pings in various ways, and checks
+// after how many Pings the connection is closed for missing Pongs, if it is.
+func TestKeepalivePongMatching(t *testing.T) {
+	var first []byte
+	tests := []struct {
+		name        string
+		matching    PongMatching
+		missedPongs int
+		pong        func(n int, ping []byte) ([]byte, bool)
+
+		// closedAfter is the number of Pings sent when the connection is closed, zero if
+		// it stays open.
+		closedAfter uint64
+	}{
+		{
+			name: "matching nonce",
+			pong: func(n int, ping []byte) ([]byte, bool) {
+				return ping, true
+			},
+		},
+		{
+			name: "stale nonce",
+			pong: func(n int, ping []byte) ([]byte, bool) {
+				if n == 1 {
+					first = ping
+				}
+
+				return first, true
+			},
+			closedAfter: 2,
+		},
+		{
+			name: "empty payload",
+			pong: func(n int, ping []byte) ([]byte, bool) {
+				return nil, true
+			},
+			closedAfter: 1,
+		},
+		{
+			name:     "empty payload matching any",
+			matching: PongMatchAny,
+			pong: func(n int, ping []byte) ([]byte, bool) {
+				return nil, true
+			},
+		},
+		{
+			name:        "tolerated misses",
+			missedPongs: 2,
+			pong: func(n int, ping []byte) ([]byte, bool) {
+				return ping, n%3 == 0
+			},
+		},
+		{
+			name:        "stale nonces do not reset misses",
+			missedPongs: 2,
+			pong: func(n int, ping []byte) ([]byte, bool) {
+				if n == 1 {
+					first = ping
+				}
+
+				return first, true
+			},
+			closedAfter: 4,
+		},
+		{
+			name:        "too many misses",
+			missedPongs: 2,
+			pong: func(n int, ping []byte) ([]byte, bool) {
+				return ping, n == 1
+			},
+			closedAfter: 4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ws, client := pipeWebsocket()
+			defer client.Close()
+			defer ws.terminate(NormalClosure, nil)
+
+			pings := make(chan int, 16)
+			go answerPings(client, tt.pong, pings)
+			go func() {
+				for {
+					_, err := ws.Receive(context.Background())
+					if err != nil {
+						return
+					}
+				}
+			}()
+
+			go ws.keepalive(keepalivePolicy{
+				interval:    20 * time.Millisecond,
+				timeout:     10 * time.Millisecond,
+				matching:    tt.matching,
+				missedPongs: tt.missedPongs,
+			})
+
+			if tt.closedAfter == 0 {
+				for n := 0; n < 7; {
+					select {
+					case n = <-pings:
+					case <-ws.done:
+						t.Fatalf("closed after %d Pings with %v", ws.pingNonce.Load(), ws.closeCause)
+					case <-time.After(2 * time.Second):
+						t.Fatalf("only %d Pings were sent", n)
+					}
+				}
+
+				return
+			}
+
+			select {
+			case <-ws.done:
+			case <-time.After(2 * time.Second):
+				t.Fatal("the connection was not closed")
+			}
+
+			if !errors.Is(ws.closeCause, MissedPong) {
+				t.Fatalf("closed with %v, want %v", ws.closeCause, MissedPong)
+			}
+
+			if sent := ws.pingNonce.Load(); sent != tt.closedAfter {
+				t.Fatalf("closed after %d Pings, want %d", sent, tt.closedAfter)
+			}
+		})
+	}
+}
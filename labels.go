@@ -0,0 +1,54 @@
+package websocket
+
+import (
+	"context"
+	"net/http"
+	"runtime/pprof"
+)
+
+// The keys of the profiler labels set by WSOpener.ProfileLabels and WithProfileLabels.
+const (
+	idLabel       = "websocket.id"
+	endpointLabel = "websocket.endpoint"
+	roomLabel     = "websocket.room"
+)
+
+// WithProfileLabels makes the hub tag the goroutines delivering a broadcast to a room
+// with the room as the websocket.room profiler label, so CPU profiles attribute the cost
+// of broadcasts to their rooms.
+func WithProfileLabels() HubOption {
+	return func(h *Hub) {
+		h.profileLabels = true
+	}
+}
+
+// withProfileLabels adds the profiler labels of the connection, upgraded from r, to its
+// context.
+func (ws *Websocket) withProfileLabels(r *http.Request) {
+	ws.ctx = pprof.WithLabels(ws.ctx, pprof.Labels(idLabel, ws.ID(), endpointLabel, r.URL.Path))
+	ws.profileLabels = true
+}
+
+// labelGoroutine tags the calling goroutine, which serves the connection, with the
+// profiler labels of the connection, if it has any.
+func (ws *Websocket) labelGoroutine() {
+	if ws.profileLabels {
+		pprof.SetGoroutineLabels(ws.ctx)
+	}
+}
+
+// deliverToRoom delivers a broadcast to the members of a room, under the profiler label
+// of the room if the hub sets it.
+func (h *Hub) deliverToRoom(ctx context.Context, room string, pm *PreparedMessage, groups [][]*Websocket) error {
+	if !h.profileLabels || room == "" {
+		return h.deliver(ctx, pm, groups)
+	}
+
+	var err error
+	// the shard workers take the labels of the context of the deliveries they make
+	pprof.Do(ctx, pprof.Labels(roomLabel, room), func(ctx context.Context) {
+		err = h.deliver(ctx, pm, groups)
+	})
+
+	return err
+}
@@ -0,0 +1,122 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+)
+
+// Handlers are the callbacks invoked by Listen. Any of them may be nil.
+type Handlers struct {
+	// OnText is called with every text message. An error stops Listen.
+	OnText func(ctx context.Context, data []byte) error
+
+	// OnBinary is called with every binary message. An error stops Listen.
+	OnBinary func(ctx context.Context, data []byte) error
+
+	// OnPing is called with the application data of every Ping frame, before the
+	// ping handler of the connection answers it.
+	OnPing func(ctx context.Context, appData []byte)
+
+	// OnClose is called once Listen stops because the connection closed, with the
+	// status code of the closure and the reason sent by the peer, if any.
+	OnClose func(code CloseCode, reason string)
+
+	// OnError is called with the error that stopped Listen, unless the connection
+	// was closed with a closing handshake or by a call to Close.
+	OnError func(err error)
+
+	// ReuseBuffers receives messages with ReceiveBuffer, passing OnText and OnBinary data
+	// that is returned to the pool once they return, so they must not retain it. This
+	// spares an allocation for every message on busy connections.
+	ReuseBuffers bool
+}
+
+// Listen reads messages from the client and invokes the handlers with them until the
+// connection closes, ctx is done or a handler fails. It returns nil when the connection
+// was closed with a closing handshake or by a call to Close, and the error that stopped
+// it otherwise. Listen owns the read loop: no other reads may run concurrently.
+func (ws *Websocket) Listen(ctx context.Context, h Handlers) error {
+	defer ws.listenPings(ctx, h)()
+	for {
+		err := ws.handleMessage(ctx, h)
+		if err != nil {
+			return ws.stopListening(h, err)
+		}
+	}
+}
+
+// listenPings makes the ping handler of the connection call h.OnPing, if set, and
+// returns the function restoring the previous handler.
+func (ws *Websocket) listenPings(ctx context.Context, h Handlers) (restore func()) {
+	if h.OnPing == nil {
+		return func() {}
+	}
+
+	pingHandler := ws.pingHandler
+	ws.pingHandler = func(appData []byte) error {
+		h.OnPing(ctx, appData)
+		if pingHandler != nil {
+			return pingHandler(appData)
+		}
+
+		return ws.writeControl(context.Background(), Pong, appData)
+	}
+
+	return func() { ws.pingHandler = pingHandler }
+}
+
+// handleMessage receives the next message and invokes the handler for its type.
+func (ws *Websocket) handleMessage(ctx context.Context, h Handlers) error {
+	var t MessageType
+	var data []byte
+	var mb *MessageBuffer
+	var err error
+	if h.ReuseBuffers {
+		mb, err = ws.ReceiveBuffer(ctx)
+		if err == nil {
+			t, data = mb.Type, mb.Bytes()
+		}
+	} else {
+		t, data, err = ws.receiveMessage(ctx)
+	}
+
+	if err == nil {
+		switch {
+		case t == TextMessage && h.OnText != nil:
+			err = h.OnText(ctx, data)
+		case t == BinaryMessage && h.OnBinary != nil:
+			err = h.OnBinary(ctx, data)
+		}
+	}
+
+	mb.Release()
+	return err
+}
+
+// stopListening invokes the handlers for the error that ended Listen and gives its result.
+func (ws *Websocket) stopListening(h Handlers, err error) error {
+	clean := isCleanClose(err)
+	if !clean && h.OnError != nil {
+		h.OnError(err)
+	}
+
+	if ws.State() == StateClosed && h.OnClose != nil {
+		ws.stateMu.Lock()
+		code := ws.closeCode
+		ws.stateMu.Unlock()
+
+		reason := ""
+		var closeErr *CloseError
+		if errors.As(err, &closeErr) {
+			reason = closeErr.Reason
+		}
+
+		h.OnClose(code, reason)
+	}
+
+	if clean {
+		return nil
+	}
+
+	return err
+}
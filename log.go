@@ -0,0 +1,69 @@
+package websocket
+
+import (
+	"log/slog"
+	"net/http"
+)
+
+// SetLogger sets the logger the protocol violations and the closure of the connection
+// are logged to, with its ID, remote address and close code as attributes. A nil logger
+// disables logging, which is the default unless the WSOpener has a Logger.
+func (ws *Websocket) SetLogger(l *slog.Logger) {
+	ws.logger.Store(l)
+}
+
+// log logs a message about the connection, if it has a logger.
+func (ws *Websocket) log(level slog.Level, msg string, attrs ...slog.Attr) {
+	logger := ws.logger.Load()
+	if logger == nil {
+		return
+	}
+
+	ctx := ws.Context()
+	if !logger.Enabled(ctx, level) {
+		return
+	}
+
+	attrs = append([]slog.Attr{
+		slog.String("id", ws.ID()),
+		slog.String("remote_addr", ws.remoteAddr()),
+	}, attrs...)
+
+	logger.LogAttrs(ctx, level, msg, attrs...)
+}
+
+// logFailure logs the connection being failed with the code. Failures for going away,
+// such as idle timeouts and shutdowns, are routine; the others are the peer misbehaving.
+func (ws *Websocket) logFailure(code CloseCode, err error) {
+	level := slog.LevelWarn
+	if code == NormalClosure || code == GoingAway {
+		level = slog.LevelInfo
+	}
+
+	ws.log(level, "websocket connection failed", slog.Int("code", int(code)), slog.Any("error", err))
+}
+
+// logClose logs the closure of the connection with the code and its cause, if any.
+func (ws *Websocket) logClose(code CloseCode, cause error) {
+	attrs := []slog.Attr{slog.Int("code", int(code))}
+	if cause != nil {
+		attrs = append(attrs, slog.Any("error", cause))
+	}
+
+	ws.log(slog.LevelInfo, "websocket connection closed", attrs...)
+}
+
+// logHandshakeFailure logs a failed upgrade request, if the opener has a logger. A status
+// of zero means the connection was hijacked already.
+func (wso *WSOpener) logHandshakeFailure(r *http.Request, status int, err error) {
+	if wso.Logger == nil {
+		return
+	}
+
+	wso.Logger.LogAttrs(r.Context(), slog.LevelInfo, "websocket handshake failed",
+		slog.String("remote_addr", r.RemoteAddr),
+		slog.String("path", r.URL.Path),
+		slog.Int("status", status),
+		slog.Any("error", err),
+	)
+}
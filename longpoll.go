@@ -0,0 +1,315 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultPollTimeout is how long a poll waits for messages when LongPoll.PollTimeout is not set.
+	defaultPollTimeout = 25 * time.Second
+
+	// defaultPollSessionTimeout is how long a client may go without polling when
+	// LongPoll.SessionTimeout is not set.
+	defaultPollSessionTimeout = time.Minute
+
+	// defaultPollMessageSize is the largest message accepted when LongPoll.MaxMessageSize is not set.
+	defaultPollMessageSize = 1 << 20
+)
+
+// LongPoll is an HTTP long-polling fallback for clients that cannot open a websocket.
+// A client starts a connection with a POST request without a session parameter, and is
+// answered with {"session": "<id>"}. It then
+//
+//   - polls with GET ?session=<id>, answered once messages are available or the poll times
+//     out with a JSON array of the messages, each base64 encoded,
+//   - sends a message as the body of a POST ?session=<id>,
+//   - closes the connection with DELETE ?session=<id>.
+//
+// Requests for a closed or unknown session are answered with 410 Gone.
+type LongPoll struct {
+	// PollTimeout is how long a poll waits for messages. It defaults to 25 seconds.
+	PollTimeout time.Duration
+
+	// SessionTimeout closes connections whose client has not polled for that long.
+	// It defaults to one minute.
+	SessionTimeout time.Duration
+
+	// QueueSize is the number of messages buffered in each direction. It defaults to 64.
+	QueueSize int
+
+	// MaxMessageSize is the largest message a client may send. It defaults to 1 MiB.
+	MaxMessageSize int64
+
+	mu    sync.Mutex
+	conns map[string]*PollConn
+}
+
+// Handler returns an http.Handler serving the long-polling protocol, which runs fn with
+// each new connection. The connection is closed when fn returns.
+func (lp *LongPoll) Handler(fn func(ctx context.Context, tr Transport)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Query().Get("session")
+		if id == "" {
+			if r.Method != http.MethodPost {
+				http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+				return
+			}
+
+			pc := lp.open(r)
+			go func() {
+				defer pc.Close()
+				fn(pc.Context(), pc)
+			}()
+
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]string{"session": pc.id})
+			return
+		}
+
+		lp.mu.Lock()
+		pc := lp.conns[id]
+		lp.mu.Unlock()
+		if pc == nil {
+			http.Error(w, http.StatusText(http.StatusGone), http.StatusGone)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			pc.poll(w, r)
+		case http.MethodPost:
+			pc.post(w, r)
+		case http.MethodDelete:
+			_ = pc.Close()
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// Len gives the number of open long-polling connections.
+func (lp *LongPoll) Len() int {
+	lp.mu.Lock()
+	defer lp.mu.Unlock()
+	return len(lp.conns)
+}
+
+// open starts a connection for the request.
+func (lp *LongPoll) open(r *http.Request) *PollConn {
+	size := lp.QueueSize
+	if size <= 0 {
+		size = defaultQueueSize
+	}
+
+	pc := &PollConn{
+		lp:       lp,
+		id:       sessionToken(),
+		request:  r,
+		incoming: make(chan []byte, size),
+		size:     size,
+		ready:    make(chan struct{}, 1),
+		space:    make(chan struct{}, 1),
+	}
+
+	// the connection outlives the request that opened it, so only the values of its context are kept
+	pc.ctx, pc.cancel = context.WithCancel(context.WithoutCancel(r.Context()))
+	pc.expiry = time.AfterFunc(lp.sessionTimeout(), func() {
+		_ = pc.Close()
+	})
+
+	lp.mu.Lock()
+	defer lp.mu.Unlock()
+	if lp.conns == nil {
+		lp.conns = map[string]*PollConn{}
+	}
+
+	lp.conns[pc.id] = pc
+	return pc
+}
+
+func (lp *LongPoll) remove(pc *PollConn) {
+	lp.mu.Lock()
+	defer lp.mu.Unlock()
+	delete(lp.conns, pc.id)
+}
+
+func (lp *LongPoll) pollTimeout() time.Duration {
+	if lp.PollTimeout > 0 {
+		return lp.PollTimeout
+	}
+
+	return defaultPollTimeout
+}
+
+func (lp *LongPoll) sessionTimeout() time.Duration {
+	if lp.SessionTimeout > 0 {
+		return lp.SessionTimeout
+	}
+
+	return defaultPollSessionTimeout
+}
+
+// PollConn is a long-polling connection to a client.
+type PollConn struct {
+	lp      *LongPoll
+	id      string
+	request *http.Request
+	ctx     context.Context
+	cancel  context.CancelFunc
+	expiry  *time.Timer
+
+	incoming chan []byte
+
+	mu       sync.Mutex
+	outgoing [][]byte
+	size     int
+	closed   bool
+	ready    chan struct{}
+	space    chan struct{}
+}
+
+// ID gives the session identifier of the connection.
+func (pc *PollConn) ID() string {
+	return pc.id
+}
+
+// Context gives the context of the connection. It carries the values of the request
+// that opened the connection, and is cancelled once the connection is closed.
+func (pc *PollConn) Context() context.Context {
+	return pc.ctx
+}
+
+// Request gives the HTTP request that opened the connection.
+func (pc *PollConn) Request() *http.Request {
+	return pc.request
+}
+
+// Send queues a message for the next poll of the client, waiting while the queue is full.
+func (pc *PollConn) Send(ctx context.Context, data []byte) error {
+	for {
+		pc.mu.Lock()
+		if pc.closed {
+			pc.mu.Unlock()
+			return ErrConnectionClosed
+		}
+
+		if len(pc.outgoing) < pc.size {
+			pc.outgoing = append(pc.outgoing, data)
+			pc.mu.Unlock()
+			signal(pc.ready)
+			return nil
+		}
+		pc.mu.Unlock()
+
+		select {
+		case <-pc.space:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-pc.ctx.Done():
+			return ErrConnectionClosed
+		}
+	}
+}
+
+// Receive waits for the next message posted by the client.
+func (pc *PollConn) Receive(ctx context.Context) ([]byte, error) {
+	select {
+	case data := <-pc.incoming:
+		return data, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-pc.ctx.Done():
+		return nil, ErrConnectionClosed
+	}
+}
+
+// Close closes the connection. Messages not polled yet are discarded.
+func (pc *PollConn) Close() error {
+	pc.mu.Lock()
+	if pc.closed {
+		pc.mu.Unlock()
+		return ErrConnectionClosed
+	}
+
+	pc.closed = true
+	pc.outgoing = nil
+	pc.mu.Unlock()
+
+	pc.expiry.Stop()
+	pc.cancel()
+	pc.lp.remove(pc)
+	return nil
+}
+
+// poll answers a poll with the queued messages, once there are some.
+func (pc *PollConn) poll(w http.ResponseWriter, r *http.Request) {
+	// the client is present for as long as it polls
+	pc.expiry.Stop()
+	defer pc.expiry.Reset(pc.lp.sessionTimeout())
+
+	timer := time.NewTimer(pc.lp.pollTimeout())
+	defer timer.Stop()
+	for {
+		pc.mu.Lock()
+		messages, closed := pc.outgoing, pc.closed
+		pc.outgoing = nil
+		pc.mu.Unlock()
+		if closed {
+			http.Error(w, http.StatusText(http.StatusGone), http.StatusGone)
+			return
+		}
+
+		if len(messages) > 0 {
+			signal(pc.space)
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(messages)
+			return
+		}
+
+		select {
+		case <-pc.ready:
+		case <-timer.C:
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = io.WriteString(w, "[]\n")
+			return
+		case <-r.Context().Done():
+			return
+		case <-pc.ctx.Done():
+		}
+	}
+}
+
+// post hands the body of the request to Receive as a message.
+func (pc *PollConn) post(w http.ResponseWriter, r *http.Request) {
+	limit := pc.lp.MaxMessageSize
+	if limit <= 0 {
+		limit = defaultPollMessageSize
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	if err != nil {
+		status := http.StatusBadRequest
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	select {
+	case pc.incoming <- data:
+		w.WriteHeader(http.StatusNoContent)
+	case <-r.Context().Done():
+	case <-pc.ctx.Done():
+		http.Error(w, http.StatusText(http.StatusGone), http.StatusGone)
+	}
+}
@@ -0,0 +1,213 @@
+package websocket
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// ConnectionManager tracks the connections opened by the WSOpeners it is set on, so they
+// can be looked up, limited and shut down together.
+type ConnectionManager struct {
+	// MaxConnections, if set, is the maximum number of connections open at once.
+	// Upgrades beyond it are rejected with 503 Service Unavailable before hijacking.
+	MaxConnections int
+
+	// MaxConnectionsPerIP, if set, is the maximum number of connections open at once
+	// from a single client IP address, as given by WSOpener.ClientIP. Upgrades beyond
+	// it are rejected with 429 Too Many Requests before hijacking.
+	MaxConnectionsPerIP int
+
+	mu           sync.Mutex
+	conns        map[*Websocket]*managed
+	byID         map[string]*Websocket
+	shuttingDown bool
+
+	// pending is the number of upgrades admitted but not yet opened or failed.
+	pending int
+
+	// perIP counts the connections, open or pending, of each client IP address.
+	perIP map[string]int
+}
+
+// managed is the state the manager keeps for a connection.
+type managed struct {
+	// stop cancels the removal of the connection once it closes.
+	stop func() bool
+
+	// ip is the IP address of the client.
+	ip string
+
+	// opened is when the connection was added.
+	opened time.Time
+}
+
+// NewConnectionManager returns a ConnectionManager without connections.
+func NewConnectionManager() *ConnectionManager {
+	return &ConnectionManager{
+		conns: map[*Websocket]*managed{},
+		byID:  map[string]*Websocket{},
+		perIP: map[string]int{},
+	}
+}
+
+// Len gives the number of open connections.
+func (m *ConnectionManager) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.conns)
+}
+
+// Range calls f for every open connection, in no particular order, until f returns false.
+// f is called without the manager locked, and connections opened or closed meanwhile
+// may or may not be seen.
+func (m *ConnectionManager) Range(f func(ws *Websocket) bool) {
+	for _, ws := range m.snapshot() {
+		if !f(ws) {
+			return
+		}
+	}
+}
+
+// Lookup gives the open connection whose ID is id.
+func (m *ConnectionManager) Lookup(id string) (*Websocket, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ws, ok := m.byID[id]
+	return ws, ok
+}
+
+// snapshot gives the open connections.
+func (m *ConnectionManager) snapshot() []*Websocket {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	conns := make([]*Websocket, 0, len(m.conns))
+	for ws := range m.conns {
+		conns = append(conns, ws)
+	}
+
+	return conns
+}
+
+// admit reserves room for a new upgrade from the client IP address; it fails with
+// ShuttingDown once Shutdown has been called, with TooManyConnections at the connection
+// limit and with IPQuotaExceeded at the limit of the address. Every successful call must
+// be followed by a call to release once the upgrade succeeded or failed.
+func (m *ConnectionManager) admit(ip string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.shuttingDown {
+		return ShuttingDown
+	}
+
+	if m.MaxConnections > 0 && len(m.conns)+m.pending >= m.MaxConnections {
+		return TooManyConnections
+	}
+
+	if m.MaxConnectionsPerIP > 0 && m.perIP[ip] >= m.MaxConnectionsPerIP {
+		return IPQuotaExceeded
+	}
+
+	if m.perIP == nil {
+		m.perIP = map[string]int{}
+	}
+
+	m.pending++
+	m.perIP[ip]++
+	return nil
+}
+
+// release gives back the room reserved by admit. A connection added meanwhile keeps
+// counting towards the quota of its address.
+func (m *ConnectionManager) release(ip string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pending--
+	m.decrementIP(ip)
+}
+
+// decrementIP removes a connection from the count of the address. m.mu must be held.
+func (m *ConnectionManager) decrementIP(ip string) {
+	m.perIP[ip]--
+	if m.perIP[ip] <= 0 {
+		delete(m.perIP, ip)
+	}
+}
+
+// add starts tracking ws, opened from the client IP address, until its connection closes.
+func (m *ConnectionManager) add(ws *Websocket, ip string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.shuttingDown {
+		return ShuttingDown
+	}
+
+	if m.conns == nil {
+		m.conns = map[*Websocket]*managed{}
+		m.byID = map[string]*Websocket{}
+	}
+
+	if m.perIP == nil {
+		m.perIP = map[string]int{}
+	}
+
+	m.conns[ws] = &managed{
+		stop: context.AfterFunc(ws.Context(), func() {
+			m.remove(ws)
+		}),
+		ip:     ip,
+		opened: time.Now(),
+	}
+
+	m.perIP[ip]++
+
+	m.byID[ws.ID()] = ws
+	return nil
+}
+
+// remove stops tracking ws.
+func (m *ConnectionManager) remove(ws *Websocket) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.conns[ws]
+	if !ok {
+		return
+	}
+
+	c.stop()
+	delete(m.conns, ws)
+	delete(m.byID, ws.ID())
+	m.decrementIP(c.ip)
+}
+
+// Shutdown stops new upgrades, which are rejected with 503 Service Unavailable, and
+// sends a Close frame with GoingAway to every connection. It then waits for the clients
+// to answer, which the applications notice as their reads fail with a *CloseError, until
+// every connection is closed or ctx is done. Connections still open then are closed
+// without waiting any longer, and ctx.Err() is returned. A Hub is shut down first with
+// Hub.Shutdown, so its members write out their queues before they are closed.
+func (m *ConnectionManager) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	m.shuttingDown = true
+	m.mu.Unlock()
+
+	conns := m.snapshot()
+
+	for _, ws := range conns {
+		go ws.startClose(GoingAway, "")
+	}
+
+	for _, ws := range conns {
+		select {
+		case <-ws.Context().Done():
+		case <-ctx.Done():
+			for _, ws := range conns {
+				_ = ws.terminate(GoingAway, nil)
+			}
+
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,45 @@
+package websocket
+
+// MessageType is the type of a data message, as carried by the opcode of its first frame.
+type MessageType string
+
+var (
+	// TextMessage denotes a message of UTF-8 encoded text.
+	TextMessage MessageType = "text"
+
+	// BinaryMessage denotes a message of arbitrary binary data.
+	BinaryMessage MessageType = "binary"
+)
+
+// Message is a complete data message.
+type Message struct {
+	// Type is the type of the message.
+	Type MessageType
+
+	// Data is the payload of the message, assembled from all of its fragments.
+	Data []byte
+}
+
+// messageType maps the opcode of the first frame of a message to its MessageType.
+func messageType(opcode Opcode) (MessageType, error) {
+	switch opcode {
+	case TextFrame:
+		return TextMessage, nil
+	case BinaryFrame:
+		return BinaryMessage, nil
+	default:
+		return "", InvalidFrameType
+	}
+}
+
+// opcode gives the opcode carried by the first frame of a message of this type.
+func (t MessageType) opcode() (Opcode, error) {
+	switch t {
+	case TextMessage:
+		return TextFrame, nil
+	case BinaryMessage:
+		return BinaryFrame, nil
+	default:
+		return "", InvalidFrameType
+	}
+}
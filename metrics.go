@@ -0,0 +1,165 @@
+package websocket
+
+import (
+	"net/http"
+	"slices"
+	"time"
+)
+
+// Metrics receives the events of the connections opened by a WSOpener whose Metrics is
+// set, so operators can feed them to any metrics backend. The methods are called on the
+// read and write paths of the connections, from many goroutines at once; they must be
+// safe for concurrent use and return quickly. Implementations may embed NopMetrics to
+// only implement the events they record.
+type Metrics interface {
+	// ConnectionOpened is called once the handshake of a connection succeeded.
+	ConnectionOpened(ws *Websocket)
+
+	// ConnectionClosed is called once a connection terminated, with the status code of
+	// the closure and its cause, as given to the OnClose function.
+	ConnectionClosed(ws *Websocket, code CloseCode, err error)
+
+	// HandshakeFailed is called when an upgrade request fails, with the HTTP status it
+	// was rejected with, or zero if it failed after the connection was hijacked.
+	HandshakeFailed(r *http.Request, status int, err error)
+
+	// MessageReceived and MessageSent are called for every data message read or written
+	// in full, with its size in bytes across all of its fragments. MessageSent is also
+	// given how long writing the message took, waiting for the send rate included.
+	MessageReceived(ws *Websocket, t MessageType, size int)
+	MessageSent(ws *Websocket, t MessageType, size int, latency time.Duration)
+
+	// FrameReceived and FrameSent are called for every frame, control frames included,
+	// with the length of its payload. Their sums are the bytes in and out of the
+	// connection, frame headers aside.
+	FrameReceived(ws *Websocket, opcode Opcode, size int)
+	FrameSent(ws *Websocket, opcode Opcode, size int)
+
+	// SessionResumed is called when a reconnecting client resumes its session of a
+	// SessionStore on ws, lossless if no message sent on the session was lost.
+	SessionResumed(ws *Websocket, lossless bool)
+}
+
+// NopMetrics is a Metrics ignoring every event.
+type NopMetrics struct{}
+
+func (NopMetrics) ConnectionOpened(ws *Websocket)                                            {}
+func (NopMetrics) ConnectionClosed(ws *Websocket, code CloseCode, err error)                 {}
+func (NopMetrics) HandshakeFailed(r *http.Request, status int, err error)                    {}
+func (NopMetrics) MessageReceived(ws *Websocket, t MessageType, size int)                    {}
+func (NopMetrics) MessageSent(ws *Websocket, t MessageType, size int, latency time.Duration) {}
+func (NopMetrics) FrameReceived(ws *Websocket, opcode Opcode, size int)                      {}
+func (NopMetrics) FrameSent(ws *Websocket, opcode Opcode, size int)                          {}
+func (NopMetrics) SessionResumed(ws *Websocket, lossless bool)                               {}
+
+// handshakeFailed reports a failed upgrade request to the metrics and the logger, if set.
+func (wso *WSOpener) handshakeFailed(r *http.Request, status int, err error) {
+	wso.logHandshakeFailure(r, status, err)
+	if wso.Metrics != nil {
+		wso.Metrics.HandshakeFailed(r, status, err)
+	}
+}
+
+// frameReceived reports the header of a frame just read to the metrics and the frame
+// trace, if set.
+func (ws *Websocket) frameReceived(f *Frame) {
+	ws.traceReceived(f)
+	if ws.metrics != nil {
+		ws.metrics.FrameReceived(ws, f.Opcode, int(f.PayloadLength()))
+	}
+}
+
+// frameSent reports a frame just written to the metrics and the frame trace, if set.
+func (ws *Websocket) frameSent(f *Frame) {
+	ws.traceSent(f)
+	if ws.metrics != nil {
+		ws.metrics.FrameSent(ws, f.Opcode, len(f.ExtensionData)+len(f.ApplicationData))
+	}
+}
+
+// messageReceived reports a message read in full to the metrics, if set, and counts it
+// towards the usage of the identity of the connection.
+func (ws *Websocket) messageReceived(t MessageType, size int) {
+	ws.countUsage(false, size)
+	if ws.metrics != nil {
+		ws.metrics.MessageReceived(ws, t, size)
+	}
+}
+
+// messageSent reports a message written in full, with the opcode of its first frame and
+// when it started to be written, to the metrics, if set, and counts it towards the usage
+// of the identity of the connection.
+func (ws *Websocket) messageSent(opcode Opcode, size int, started time.Time) {
+	ws.countUsage(true, size)
+	if ws.metrics == nil {
+		return
+	}
+
+	t, err := messageType(opcode)
+	if err == nil {
+		ws.metrics.MessageSent(ws, t, size, time.Since(started))
+	}
+}
+
+// sessionResumed reports the session resumed on the connection to the metrics, if set.
+func (ws *Websocket) sessionResumed(lossless bool) {
+	if ws.metrics != nil {
+		ws.metrics.SessionResumed(ws, lossless)
+	}
+}
+
+// MultiMetrics returns a Metrics passing every event to each of the given metrics in
+// turn, so a connection can feed several backends.
+func MultiMetrics(metrics ...Metrics) Metrics {
+	return multiMetrics(slices.Clone(metrics))
+}
+
+type multiMetrics []Metrics
+
+func (mm multiMetrics) ConnectionOpened(ws *Websocket) {
+	for _, m := range mm {
+		m.ConnectionOpened(ws)
+	}
+}
+
+func (mm multiMetrics) ConnectionClosed(ws *Websocket, code CloseCode, err error) {
+	for _, m := range mm {
+		m.ConnectionClosed(ws, code, err)
+	}
+}
+
+func (mm multiMetrics) HandshakeFailed(r *http.Request, status int, err error) {
+	for _, m := range mm {
+		m.HandshakeFailed(r, status, err)
+	}
+}
+
+func (mm multiMetrics) MessageReceived(ws *Websocket, t MessageType, size int) {
+	for _, m := range mm {
+		m.MessageReceived(ws, t, size)
+	}
+}
+
+func (mm multiMetrics) MessageSent(ws *Websocket, t MessageType, size int, latency time.Duration) {
+	for _, m := range mm {
+		m.MessageSent(ws, t, size, latency)
+	}
+}
+
+func (mm multiMetrics) FrameReceived(ws *Websocket, opcode Opcode, size int) {
+	for _, m := range mm {
+		m.FrameReceived(ws, opcode, size)
+	}
+}
+
+func (mm multiMetrics) FrameSent(ws *Websocket, opcode Opcode, size int) {
+	for _, m := range mm {
+		m.FrameSent(ws, opcode, size)
+	}
+}
+
+func (mm multiMetrics) SessionResumed(ws *Websocket, lossless bool) {
+	for _, m := range mm {
+		m.SessionResumed(ws, lossless)
+	}
+}
@@ -0,0 +1,253 @@
+// Package prometheus provides a websocket.Metrics recording the events of connections as
+// Prometheus metrics.
+//
+// It lives in its own module so the websocket package itself stays free of
+// external dependencies.
+package prometheus
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ajsqr/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// defaultNamespace is the namespace of the metrics when Options.Namespace is not set.
+const defaultNamespace = "websocket"
+
+// The directions of messages and frames, in the direction label.
+const (
+	received = "received"
+	sent     = "sent"
+)
+
+var (
+	_ websocket.Metrics    = (*Collector)(nil)
+	_ prometheus.Collector = (*Collector)(nil)
+)
+
+// Options configures a Collector.
+type Options struct {
+	// Namespace prefixes the names of the metrics. It defaults to "websocket".
+	Namespace string
+
+	// Endpoint gives the endpoint label of the connections upgraded from a request.
+	// It defaults to the path of the request; paths carrying IDs should be mapped to
+	// their route, so the number of series stays bounded.
+	Endpoint func(r *http.Request) string
+
+	// SizeBuckets are the buckets in bytes of the message size histogram. They default
+	// to powers of four from 64 bytes to 1 MiB.
+	SizeBuckets []float64
+
+	// LatencyBuckets are the buckets in seconds of the write latency histogram. They
+	// default to prometheus.DefBuckets.
+	LatencyBuckets []float64
+}
+
+// Collector is a websocket.Metrics recording connections, handshake failures, messages,
+// frames and session resumptions as Prometheus metrics, labelled by endpoint and
+// subprotocol. It is also a prometheus.Collector, to be registered with the registry the
+// metrics are served from.
+type Collector struct {
+	endpoint func(r *http.Request) string
+
+	connections       *prometheus.GaugeVec
+	opened            *prometheus.CounterVec
+	closed            *prometheus.CounterVec
+	handshakeFailures *prometheus.CounterVec
+	messages          *prometheus.CounterVec
+	messageSize       *prometheus.HistogramVec
+	writeLatency      *prometheus.HistogramVec
+	frames            *prometheus.CounterVec
+	bytes             *prometheus.CounterVec
+	resumed           *prometheus.CounterVec
+
+	// labels are the endpoint and subprotocol of the open connections, by connection.
+	labels sync.Map
+}
+
+// NewCollector returns a Collector with the given options.
+func NewCollector(opts Options) *Collector {
+	namespace := opts.Namespace
+	if namespace == "" {
+		namespace = defaultNamespace
+	}
+
+	endpoint := opts.Endpoint
+	if endpoint == nil {
+		endpoint = func(r *http.Request) string {
+			return r.URL.Path
+		}
+	}
+
+	sizeBuckets := opts.SizeBuckets
+	if sizeBuckets == nil {
+		sizeBuckets = prometheus.ExponentialBuckets(64, 4, 8)
+	}
+
+	latencyBuckets := opts.LatencyBuckets
+	if latencyBuckets == nil {
+		latencyBuckets = prometheus.DefBuckets
+	}
+
+	connLabels := []string{"endpoint", "subprotocol"}
+	return &Collector{
+		endpoint: endpoint,
+		connections: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "connections",
+			Help:      "Number of open connections.",
+		}, connLabels),
+		opened: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "connections_opened_total",
+			Help:      "Number of connections opened.",
+		}, connLabels),
+		closed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "connections_closed_total",
+			Help:      "Number of connections closed, by close code.",
+		}, append(connLabels, "code")),
+		handshakeFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "handshake_failures_total",
+			Help:      "Number of upgrade requests that failed, by HTTP status, 0 after the connection was hijacked.",
+		}, []string{"endpoint", "status"}),
+		messages: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "messages_total",
+			Help:      "Number of data messages received and sent.",
+		}, append(connLabels, "direction", "type")),
+		messageSize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "message_size_bytes",
+			Help:      "Size of the data messages received and sent.",
+			Buckets:   sizeBuckets,
+		}, append(connLabels, "direction")),
+		writeLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "write_duration_seconds",
+			Help:      "Time taken to write data messages.",
+			Buckets:   latencyBuckets,
+		}, connLabels),
+		frames: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "frames_total",
+			Help:      "Number of frames received and sent, control frames included.",
+		}, append(connLabels, "direction", "opcode")),
+		bytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "payload_bytes_total",
+			Help:      "Bytes of frame payload received and sent.",
+		}, append(connLabels, "direction")),
+		resumed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "sessions_resumed_total",
+			Help:      "Number of sessions resumed by reconnecting clients, by whether no message was lost.",
+		}, append(connLabels, "lossless")),
+	}
+}
+
+// Describe sends the descriptions of the metrics.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, collector := range c.collectors() {
+		collector.Describe(ch)
+	}
+}
+
+// Collect sends the current values of the metrics.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	for _, collector := range c.collectors() {
+		collector.Collect(ch)
+	}
+}
+
+func (c *Collector) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		c.connections, c.opened, c.closed, c.handshakeFailures, c.messages,
+		c.messageSize, c.writeLatency, c.frames, c.bytes, c.resumed,
+	}
+}
+
+// ConnectionOpened counts the connection as open.
+func (c *Collector) ConnectionOpened(ws *websocket.Websocket) {
+	labels := c.connLabels(ws)
+	c.labels.Store(ws, labels)
+	c.opened.WithLabelValues(labels...).Inc()
+	c.connections.WithLabelValues(labels...).Inc()
+}
+
+// ConnectionClosed counts the connection as closed with the code.
+func (c *Collector) ConnectionClosed(ws *websocket.Websocket, code websocket.CloseCode, err error) {
+	labels := c.lookup(ws)
+	c.labels.Delete(ws)
+	c.connections.WithLabelValues(labels...).Dec()
+	c.closed.WithLabelValues(append(labels, strconv.Itoa(int(code)))...).Inc()
+}
+
+// HandshakeFailed counts the failed upgrade request.
+func (c *Collector) HandshakeFailed(r *http.Request, status int, err error) {
+	c.handshakeFailures.WithLabelValues(c.endpoint(r), strconv.Itoa(status)).Inc()
+}
+
+// MessageReceived counts the message received and observes its size.
+func (c *Collector) MessageReceived(ws *websocket.Websocket, t websocket.MessageType, size int) {
+	labels := c.lookup(ws)
+	c.messages.WithLabelValues(append(labels, received, string(t))...).Inc()
+	c.messageSize.WithLabelValues(append(labels, received)...).Observe(float64(size))
+}
+
+// MessageSent counts the message sent and observes its size and write latency.
+func (c *Collector) MessageSent(ws *websocket.Websocket, t websocket.MessageType, size int, latency time.Duration) {
+	labels := c.lookup(ws)
+	c.messages.WithLabelValues(append(labels, sent, string(t))...).Inc()
+	c.messageSize.WithLabelValues(append(labels, sent)...).Observe(float64(size))
+	c.writeLatency.WithLabelValues(labels...).Observe(latency.Seconds())
+}
+
+// FrameReceived counts the frame received and its payload bytes.
+func (c *Collector) FrameReceived(ws *websocket.Websocket, opcode websocket.Opcode, size int) {
+	c.frame(ws, received, opcode, size)
+}
+
+// FrameSent counts the frame sent and its payload bytes.
+func (c *Collector) FrameSent(ws *websocket.Websocket, opcode websocket.Opcode, size int) {
+	c.frame(ws, sent, opcode, size)
+}
+
+// SessionResumed counts the session resumed.
+func (c *Collector) SessionResumed(ws *websocket.Websocket, lossless bool) {
+	c.resumed.WithLabelValues(append(c.lookup(ws), strconv.FormatBool(lossless))...).Inc()
+}
+
+func (c *Collector) frame(ws *websocket.Websocket, direction string, opcode websocket.Opcode, size int) {
+	labels := c.lookup(ws)
+	c.frames.WithLabelValues(append(labels, direction, string(opcode))...).Inc()
+	c.bytes.WithLabelValues(append(labels, direction)...).Add(float64(size))
+}
+
+// lookup gives the labels of the connection, computing them if it is not open.
+func (c *Collector) lookup(ws *websocket.Websocket) []string {
+	labels, ok := c.labels.Load(ws)
+	if !ok {
+		return c.connLabels(ws)
+	}
+
+	// the stored slice is full, so appending to it always copies
+	return labels.([]string)
+}
+
+// connLabels computes the endpoint and subprotocol labels of the connection.
+func (c *Collector) connLabels(ws *websocket.Websocket) []string {
+	endpoint := ""
+	if r := ws.Request(); r != nil {
+		endpoint = c.endpoint(r)
+	}
+
+	labels := []string{endpoint, ws.Subprotocol()}
+	return labels[:len(labels):len(labels)]
+}
@@ -0,0 +1,249 @@
+// Package mux carries independent, flow-controlled streams over a single websocket
+// connection, so an application can tunnel several protocols without opening several
+// connections.
+//
+// Every mux frame is a binary message made of a one byte frame type, the big-endian
+// 32-bit ID of its stream and a payload:
+//
+//	open    opens the stream; the payload is empty
+//	data    carries stream data, at most the credit granted by the receiver
+//	close   tells the receiver no more data follows on the stream
+//	window  grants the sender more credit; the payload is a big-endian 32-bit increment
+//	reset   aborts the stream in both directions
+//
+// Each side starts with Window bytes of credit on every stream. The server opens streams
+// with even IDs, the client with odd IDs.
+package mux
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync"
+
+	"github.com/ajsqr/websocket"
+)
+
+// The types of mux frames.
+const (
+	frameOpen byte = iota
+	frameData
+	frameClose
+	frameWindow
+	frameReset
+)
+
+const (
+	// Window is the credit each side has on a stream before it is granted more.
+	Window = 256 << 10
+
+	// maxData is the largest data payload sent in one frame.
+	maxData = 32 << 10
+
+	// backlog is the number of opened streams waiting for Accept.
+	backlog = 64
+
+	// headerSize is the size of the type and stream ID of a frame.
+	headerSize = 5
+)
+
+var (
+	MuxClosed = errors.New("mux: connection closed")
+
+	StreamClosed = errors.New("mux: stream closed")
+
+	StreamReset = errors.New("mux: stream reset by peer")
+
+	InvalidFrame = errors.New("mux: invalid frame")
+)
+
+// Mux multiplexes streams over a websocket connection.
+type Mux struct {
+	ws   *websocket.Websocket
+	done chan struct{}
+
+	mu      sync.Mutex
+	streams map[uint32]*Stream
+	nextID  uint32
+
+	accept chan *Stream
+}
+
+// New starts multiplexing streams over ws, which must not be read from by anything else.
+// The read loop runs until the connection closes.
+func New(ws *websocket.Websocket) *Mux {
+	m := &Mux{
+		ws:      ws,
+		done:    make(chan struct{}),
+		streams: map[uint32]*Stream{},
+		accept:  make(chan *Stream, backlog),
+	}
+
+	go m.run()
+	return m
+}
+
+// Done is closed once the connection is closed and no more streams can be used.
+func (m *Mux) Done() <-chan struct{} {
+	return m.done
+}
+
+// Open opens a new stream to the peer.
+func (m *Mux) Open(ctx context.Context) (*Stream, error) {
+	m.mu.Lock()
+	select {
+	case <-m.done:
+		m.mu.Unlock()
+		return nil, MuxClosed
+	default:
+	}
+
+	m.nextID += 2
+	s := m.newStream(m.nextID)
+	m.mu.Unlock()
+
+	err := m.write(ctx, frameOpen, s.id, nil)
+	if err != nil {
+		m.remove(s.id)
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Accept waits for the peer to open a stream.
+func (m *Mux) Accept(ctx context.Context) (*Stream, error) {
+	select {
+	case s := <-m.accept:
+		return s, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-m.done:
+		return nil, MuxClosed
+	}
+}
+
+// Close closes the underlying connection, and with it every stream.
+func (m *Mux) Close() error {
+	return m.ws.Close()
+}
+
+// newStream registers a stream. m.mu must be held.
+func (m *Mux) newStream(id uint32) *Stream {
+	s := &Stream{
+		mux:      m,
+		id:       id,
+		credit:   Window,
+		readable: make(chan struct{}, 1),
+		writable: make(chan struct{}, 1),
+	}
+
+	m.streams[id] = s
+	return s
+}
+
+// run reads the frames of the peer and hands them to their stream.
+func (m *Mux) run() {
+	for msg, err := range m.ws.Messages(context.Background()) {
+		if err != nil {
+			break
+		}
+
+		if m.handle(msg.Data) != nil {
+			_ = m.ws.CloseWithCode(websocket.ProtocolError, "invalid mux frame")
+			break
+		}
+	}
+
+	m.mu.Lock()
+	streams := m.streams
+	m.streams = map[uint32]*Stream{}
+	close(m.done)
+	m.mu.Unlock()
+	for _, s := range streams {
+		s.fail(MuxClosed)
+	}
+}
+
+// handle handles a frame from the peer.
+func (m *Mux) handle(frame []byte) error {
+	if len(frame) < headerSize {
+		return InvalidFrame
+	}
+
+	t, id, payload := frame[0], binary.BigEndian.Uint32(frame[1:headerSize]), frame[headerSize:]
+	m.mu.Lock()
+	s := m.streams[id]
+	if t == frameOpen {
+		if s != nil || id%2 == 0 {
+			m.mu.Unlock()
+			return InvalidFrame
+		}
+
+		s = m.newStream(id)
+		m.mu.Unlock()
+		select {
+		case m.accept <- s:
+		default:
+			// nobody accepts streams fast enough
+			m.remove(id)
+			go m.write(context.Background(), frameReset, id, nil)
+		}
+
+		return nil
+	}
+	m.mu.Unlock()
+
+	if s == nil {
+		// frames racing with the end of their stream
+		return nil
+	}
+
+	switch t {
+	case frameData:
+		return s.receive(payload)
+	case frameClose:
+		s.closeRemote()
+	case frameWindow:
+		if len(payload) != 4 {
+			return InvalidFrame
+		}
+
+		s.grant(int(binary.BigEndian.Uint32(payload)))
+	case frameReset:
+		m.remove(id)
+		s.fail(StreamReset)
+	default:
+		return InvalidFrame
+	}
+
+	return nil
+}
+
+// write sends a frame to the peer.
+func (m *Mux) write(ctx context.Context, t byte, id uint32, payload []byte) error {
+	frame := make([]byte, headerSize+len(payload))
+	frame[0] = t
+	binary.BigEndian.PutUint32(frame[1:headerSize], id)
+	copy(frame[headerSize:], payload)
+	err := m.ws.SendBinary(ctx, frame)
+	if errors.Is(err, websocket.ErrConnectionClosed) {
+		return MuxClosed
+	}
+
+	return err
+}
+
+func (m *Mux) remove(id uint32) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.streams, id)
+}
+
+// signal wakes up the goroutine waiting on c, if any, without blocking.
+func signal(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,184 @@
+package mux
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"sync"
+)
+
+// Stream is a bidirectional stream of bytes multiplexed over the connection.
+// Read and Write may be called concurrently with each other.
+type Stream struct {
+	mux *Mux
+	id  uint32
+
+	mu sync.Mutex
+
+	// buf holds the data received and not read yet.
+	buf []byte
+
+	// consumed is the number of bytes read since the peer was last granted credit.
+	consumed int
+
+	// credit is the number of bytes that may still be sent before the peer grants more.
+	credit int
+
+	// remoteClosed is set once the peer sent close, localClosed once Close was called.
+	remoteClosed bool
+	localClosed  bool
+
+	// err is set once the stream was reset or the connection closed.
+	err error
+
+	readable chan struct{}
+	writable chan struct{}
+}
+
+// ID gives the ID of the stream, shared with the peer.
+func (s *Stream) ID() uint32 {
+	return s.id
+}
+
+// Read reads data sent by the peer. It returns io.EOF once the peer closed the stream
+// and all its data was read.
+func (s *Stream) Read(p []byte) (int, error) {
+	for {
+		s.mu.Lock()
+		if len(s.buf) > 0 {
+			n := copy(p, s.buf)
+			s.buf = s.buf[n:]
+			s.consumed += n
+			var grant int
+			if s.consumed >= Window/2 && !s.remoteClosed {
+				grant, s.consumed = s.consumed, 0
+			}
+			s.mu.Unlock()
+
+			if grant > 0 {
+				increment := make([]byte, 4)
+				binary.BigEndian.PutUint32(increment, uint32(grant))
+				_ = s.mux.write(context.Background(), frameWindow, s.id, increment)
+			}
+
+			return n, nil
+		}
+
+		err := s.err
+		if err == nil && s.remoteClosed {
+			err = io.EOF
+		}
+		s.mu.Unlock()
+		if err != nil {
+			return 0, err
+		}
+
+		<-s.readable
+	}
+}
+
+// Write sends p to the peer, waiting for credit when the peer has not read enough
+// of what was sent before.
+func (s *Stream) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		s.mu.Lock()
+		if s.localClosed {
+			s.mu.Unlock()
+			return written, StreamClosed
+		}
+
+		if s.err != nil {
+			err := s.err
+			s.mu.Unlock()
+			return written, err
+		}
+
+		n := min(len(p)-written, s.credit, maxData)
+		s.credit -= n
+		s.mu.Unlock()
+		if n == 0 {
+			<-s.writable
+			continue
+		}
+
+		err := s.mux.write(context.Background(), frameData, s.id, p[written:written+n])
+		if err != nil {
+			return written, err
+		}
+
+		written += n
+	}
+
+	return written, nil
+}
+
+// Close tells the peer no more data will be written. Data the peer sends may still be read.
+func (s *Stream) Close() error {
+	s.mu.Lock()
+	if s.localClosed || s.err != nil {
+		s.mu.Unlock()
+		return nil
+	}
+
+	s.localClosed = true
+	done := s.remoteClosed
+	s.mu.Unlock()
+	signal(s.writable)
+	if done {
+		s.mux.remove(s.id)
+	}
+
+	return s.mux.write(context.Background(), frameClose, s.id, nil)
+}
+
+// Reset aborts the stream in both directions, discarding unread data.
+func (s *Stream) Reset() error {
+	s.mux.remove(s.id)
+	s.fail(StreamClosed)
+	return s.mux.write(context.Background(), frameReset, s.id, nil)
+}
+
+// receive buffers data sent by the peer. It fails if the peer sent more than its credit.
+func (s *Stream) receive(data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.remoteClosed || len(s.buf)+len(data) > Window {
+		return InvalidFrame
+	}
+
+	s.buf = append(s.buf, data...)
+	signal(s.readable)
+	return nil
+}
+
+// closeRemote records that the peer will send no more data.
+func (s *Stream) closeRemote() {
+	s.mu.Lock()
+	s.remoteClosed = true
+	done := s.localClosed
+	s.mu.Unlock()
+	signal(s.readable)
+	if done {
+		s.mux.remove(s.id)
+	}
+}
+
+// grant adds the credit granted by the peer.
+func (s *Stream) grant(n int) {
+	s.mu.Lock()
+	s.credit += n
+	s.mu.Unlock()
+	signal(s.writable)
+}
+
+// fail makes both directions of the stream fail with err.
+func (s *Stream) fail(err error) {
+	s.mu.Lock()
+	if s.err == nil {
+		s.err = err
+	}
+	s.mu.Unlock()
+	signal(s.readable)
+	signal(s.writable)
+}
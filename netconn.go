@@ -0,0 +1,100 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+// NetConn returns a net.Conn backed by ws, so stream oriented protocols can run over
+// a websocket unchanged. Every Write is sent as a single message of type msgType,
+// and Read returns the payloads of incoming messages back to back. Messages of any
+// other type fail the Read. Deadlines apply to the underlying connection. A normal
+// closure by the peer is reported as io.EOF.
+func NetConn(ws *Websocket, msgType MessageType) net.Conn {
+	return &netConn{
+		ws:      ws,
+		msgType: msgType,
+	}
+}
+
+type netConn struct {
+	ws      *Websocket
+	msgType MessageType
+
+	// reader is the message currently being read, nil between messages.
+	reader io.Reader
+}
+
+func (c *netConn) Read(p []byte) (int, error) {
+	for {
+		if c.reader == nil {
+			t, r, err := c.ws.NextReader(context.Background())
+			if err != nil {
+				var closeErr *CloseError
+				if errors.As(err, &closeErr) && (closeErr.Code == NormalClosure || closeErr.Code == NoStatusReceived) {
+					return 0, io.EOF
+				}
+
+				return 0, err
+			}
+
+			if t != c.msgType {
+				return 0, UnexpectedMessageType
+			}
+
+			c.reader = r
+		}
+
+		n, err := c.reader.Read(p)
+		if err == io.EOF {
+			// the message is exhausted, the stream continues with the next one
+			c.reader = nil
+			err = nil
+		}
+
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+}
+
+func (c *netConn) Write(p []byte) (int, error) {
+	opcode, err := c.msgType.opcode()
+	if err != nil {
+		return 0, err
+	}
+
+	err = c.ws.send(context.Background(), opcode, p)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+func (c *netConn) Close() error {
+	return c.ws.Close()
+}
+
+func (c *netConn) LocalAddr() net.Addr {
+	return c.ws.LocalAddr()
+}
+
+func (c *netConn) RemoteAddr() net.Addr {
+	return c.ws.RemoteAddr()
+}
+
+func (c *netConn) SetDeadline(t time.Time) error {
+	return c.ws.SetDeadline(t)
+}
+
+func (c *netConn) SetReadDeadline(t time.Time) error {
+	return c.ws.SetReadDeadline(t)
+}
+
+func (c *netConn) SetWriteDeadline(t time.Time) error {
+	return c.ws.SetWriteDeadline(t)
+}
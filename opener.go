@@ -1,11 +1,19 @@
 package websocket 
 
 import (
-	"crypto/sha1"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
 	"encoding/base64"
 	"bufio"
 	"fmt"
+	"net/url"
+	"strings"
+	"time"
 )
 
 const (
@@ -22,43 +30,548 @@ type Opener interface {
 type WSOpener struct {
 	// MaxBytes defines the maximum payload length of a frame.
 	// If the message is bigger than this value, then the message is sent as fragments.
+	// Zero sends every message in a single frame.
 	MaxBytes int
+
+	// RepeatDataOpcode makes every fragment of a message carry the text or binary
+	// opcode instead of the continuation opcode. This violates RFC 6455 and must
+	// only be enabled for legacy peers that expect the data opcode on every fragment.
+	RepeatDataOpcode bool
+
+	// Capabilities, if set, are advertised to the client in the handshake response headers.
+	// Clients can read them back with ParseCapabilities.
+	Capabilities *Capabilities
+
+	// JSON configures ReadJSON and WriteJSON on the opened connections.
+	JSON JSONOptions
+
+	// Codec serializes values for SendValue and ReceiveValue on the opened connections.
+	// If nil, JSONCodec is used with the JSON options above.
+	Codec Codec
+
+	// MaxFramesPerSecond limits how many frames a client may send per second, regardless of their size.
+	// A client exceeding it is closed with PolicyViolation. Zero disables the limit.
+	MaxFramesPerSecond float64
+
+	// FrameBurst is the number of frames a client may send at once before MaxFramesPerSecond applies.
+	FrameBurst int
+
+	// MaxBytesPerSecond limits the rate at which messages are sent to a client.
+	// Zero disables the limit. See Websocket.SetSendRate.
+	MaxBytesPerSecond float64
+
+	// ByteBurst is the number of bytes that may be sent at once before MaxBytesPerSecond
+	// applies. It defaults to one second worth of bytes.
+	ByteBurst int
+
+	// ReadLimit is the maximum size in bytes of a message read from a client.
+	// Zero means no limit. See Websocket.SetReadLimit.
+	ReadLimit int64
+
+	// MaxFrameSize is the largest payload length in bytes accepted in a single frame from
+	// a client, whatever the ReadLimit. A client declaring a larger frame is closed with
+	// MessageTooBig before any of it is read. It defaults to 64 MiB.
+	MaxFrameSize int64
+
+	// PingInterval, if set, makes the server ping every opened connection at this interval.
+	// Connections that do not answer a Ping with its Pong within PongTimeout are closed.
+	// Pongs are only noticed while the application reads from the connection.
+	PingInterval time.Duration
+
+	// PongTimeout is how long to wait for a Pong after a Ping. It defaults to PingInterval.
+	PongTimeout time.Duration
+
+	// PongMatching decides which Pongs answer a Ping. It defaults to PongMatchNonce.
+	PongMatching PongMatching
+
+	// MissedPongs is how many Pings in a row may go unanswered before the connection is
+	// closed. Zero closes it at the first unanswered Ping.
+	MissedPongs int
+
+	// IdleTimeout, if set, closes connections with GoingAway once no application data
+	// has been sent or received for this long. Pings and Pongs do not count as activity.
+	IdleTimeout time.Duration
+
+	// OnAbuse, if set, is called when a client is closed for misbehaving, with the reason.
+	OnAbuse func(ws *Websocket, err error)
+
+	// QueueSize is the number of messages the send queue of a connection holds, see
+	// Websocket.Enqueue. Zero uses a default of 64.
+	QueueSize int
+
+	// OverflowPolicy decides what happens to messages enqueued while the send queue is
+	// full. It defaults to OverflowBlock.
+	OverflowPolicy OverflowPolicy
+
+	// SlowClientTimeout, if set, closes connections with TryAgainLater once they have been
+	// behind for this long: their send queue holds at least MaxQueueDepth messages, or
+	// a message has been taking longer than MaxWriteLatency to write.
+	SlowClientTimeout time.Duration
+
+	// MaxQueueDepth is the queue depth from which a connection is behind.
+	// It defaults to QueueSize, a full queue.
+	MaxQueueDepth int
+
+	// MaxWriteLatency is the write latency from which a connection is behind, while it
+	// has messages to write. Zero ignores write latency.
+	MaxWriteLatency time.Duration
+
+	// OnEvict, if set, is called when a connection is closed for being behind for
+	// SlowClientTimeout, with its send statistics at that time.
+	OnEvict func(ws *Websocket, stats SendStats)
+
+	// FlushDelay, if set, coalesces the data frames written to a connection, which are
+	// left in the write buffer until it holds FlushSize bytes, FlushDelay passed or
+	// Websocket.Flush is called. See Websocket.SetWriteCoalescing.
+	FlushDelay time.Duration
+
+	// FlushSize is the number of buffered bytes from which coalesced frames are written.
+	// It defaults to WriteBufferSize.
+	FlushSize int
+
+	// ReadBufferSize and WriteBufferSize are the sizes in bytes of the buffers used to
+	// read from and write to the connection. Zero uses the bufio default of 4096 bytes.
+	ReadBufferSize int
+	WriteBufferSize int
+
+	// Metrics, if set, receives the events of the opened connections and the failed
+	// upgrade requests, for operators to feed a metrics backend.
+	Metrics Metrics
+
+	// Logger, if set, logs the failed upgrade requests and, on the opened connections, the
+	// protocol violations and closures. See Websocket.SetLogger.
+	Logger *slog.Logger
+
+	// FrameTrace, if set, receives a decoded line for every frame of the opened
+	// connections, for debugging. See Websocket.SetFrameTrace.
+	FrameTrace io.Writer
+
+	// ProfileLabels, if set, tags the goroutines serving the opened connections, those of
+	// Handler included, with the websocket.id and websocket.endpoint profiler labels, the
+	// ID of the connection and the path of its upgrade request, so CPU and goroutine
+	// profiles attribute their cost to connections. The labels are also carried by the
+	// context of the connections, for pprof.Do.
+	ProfileLabels bool
+
+	// Capture, if set, is called once the handshake of a connection succeeded and may
+	// give a writer, such as a file, that every byte the connection exchanges from then
+	// on is recorded to with timestamps, for replaying the protocol bugs of a specific
+	// connection offline with a CaptureReader. The writer is closed once the connection
+	// closes if it is an io.Closer. A nil writer leaves the connection uncaptured.
+	Capture func(ws *Websocket) io.Writer
+
+	// Socket are the TCP options set on the hijacked connection, such as kernel
+	// keepalives and socket buffer sizes.
+	Socket SocketOptions
+
+	// HandshakeTimeout bounds the time spent writing the handshake response.
+	// Zero means no timeout.
+	HandshakeTimeout time.Duration
+
+	// Error, if set, writes the HTTP response for a rejected upgrade request.
+	// By default the status text is written with http.Error.
+	Error func(w http.ResponseWriter, r *http.Request, status int, reason error)
+
+	// CheckOrigin decides whether the Origin of the upgrade request is acceptable.
+	// By default requests without an Origin header, or whose Origin host matches
+	// the Host of the request, are accepted.
+	CheckOrigin func(r *http.Request) bool
+
+	// Subprotocols lists the subprotocols supported by the server, in order of preference.
+	// The first of them requested by the client is selected.
+	Subprotocols []string
+
+	// ClientIP gives the IP address of the client that sent an upgrade request, for the
+	// per address limits. It defaults to RemoteIP; use ForwardedIP behind reverse proxies.
+	ClientIP func(r *http.Request) string
+
+	// HandshakeLimiter, if set, limits the rate of upgrade requests.
+	HandshakeLimiter *HandshakeLimiter
+
+	// Manager, if set, tracks the opened connections so they can be shut down together.
+	// Once it is shutting down, upgrades are rejected with 503 Service Unavailable.
+	Manager *ConnectionManager
+
+	// Authorize, if set, runs before the connection is hijacked. An error rejects the
+	// upgrade with 403 Forbidden if it wraps Forbidden, and 401 Unauthorized otherwise.
+	// On success the returned context, which should derive from the request context,
+	// becomes the context of the connection.
+	Authorize func(r *http.Request) (context.Context, error)
+}
+
+// OpenOption customizes a single call to Open.
+type OpenOption func(*openOptions)
+
+type openOptions struct {
+	header http.Header
+}
+
+// WithResponseHeader adds the headers to the 101 Switching Protocols response, for example
+// a Set-Cookie binding the connection to a session. Headers that are part of the websocket
+// handshake itself, such as Upgrade or Sec-WebSocket-Accept, are ignored.
+func WithResponseHeader(h http.Header) OpenOption {
+	return func(o *openOptions) {
+		if o.header == nil {
+			o.header = http.Header{}
+		}
+
+		for name, values := range h {
+			for _, value := range values {
+				o.header.Add(name, value)
+			}
+		}
+	}
+}
+
+// reservedResponseHeader reports whether the header is set by the handshake and cannot be overridden.
+func reservedResponseHeader(name string) bool {
+	switch http.CanonicalHeaderKey(name) {
+	case "Upgrade", "Connection", "Sec-Websocket-Accept", "Sec-Websocket-Protocol", "Sec-Websocket-Extensions":
+		return true
+	default:
+		return false
+	}
 }
 
 // Open will open a websocket connection, by upgrading the existing HTTP connection.
 // The opened websocket connection hijacks the existing http connection.
-func (wso *WSOpener) Open(w http.ResponseWriter, r *http.Request, t WebsocketType) (*Websocket, error) {
+// Requests that are not valid websocket upgrades are rejected with an HTTP error
+// written by the Error function, and are not hijacked.
+func (wso *WSOpener) Open(w http.ResponseWriter, r *http.Request, t WebsocketType, opts ...OpenOption) (*Websocket, error) {
+	options := openOptions{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
 	ws := Websocket{}
+	ip := wso.clientIP(r)
+	if wso.HandshakeLimiter != nil && !wso.HandshakeLimiter.allow(ip) {
+		wso.error(w, r, http.StatusTooManyRequests, TooManyHandshakes)
+		return nil, TooManyHandshakes
+	}
+
+	status, err := wso.checkRequest(r)
+	if err != nil{
+		wso.error(w, r, status, err)
+		return nil, err
+	}
+
+	ctx := r.Context()
+	if wso.Authorize != nil {
+		ctx, err = wso.Authorize(r)
+		if err != nil{
+			status := http.StatusUnauthorized
+			if errors.Is(err, Forbidden) {
+				status = http.StatusForbidden
+			}
+
+			wso.error(w, r, status, err)
+			return nil, err
+		}
+
+		if ctx == nil {
+			ctx = r.Context()
+		}
+	}
+
+	if wso.Manager != nil {
+		err = wso.Manager.admit(ip)
+		if err != nil{
+			status := http.StatusServiceUnavailable
+			if errors.Is(err, IPQuotaExceeded) {
+				status = http.StatusTooManyRequests
+			}
+
+			wso.error(w, r, status, err)
+			return nil, err
+		}
+
+		defer wso.Manager.release(ip)
+	}
+
 	hj, ok := w.(http.Hijacker)
 	if !ok {
+		wso.error(w, r, http.StatusInternalServerError, HijackingNotSupported)
 		return nil, HijackingNotSupported
 	}
 
-	// the second return item here is a ReadWriter 
-	// I should probably reuse this instead of creating a new one.
-	conn, _, err := hj.Hijack()
+	ws.subprotocol = wso.selectSubprotocol(r)
+	ws.request = r
+	// the connection outlives the request handler, so only the values of the context are kept
+	ws.ctx, ws.cancel = context.WithCancel(context.WithoutCancel(ctx))
+	if wso.ProfileLabels {
+		ws.withProfileLabels(r)
+	}
+
+	conn, brw, err := hj.Hijack()
+	if err != nil{
+		wso.handshakeFailed(r, 0, err)
+		return nil, err
+	}
+
+	err = wso.Socket.Apply(conn)
+	if err != nil{
+		conn.Close()
+		wso.handshakeFailed(r, 0, err)
+		return nil, err
+	}
+
+	ws.conn = conn
+	ws.readDeadline = newDeadline(conn.SetReadDeadline)
+	ws.writeDeadline = newDeadline(conn.SetWriteDeadline)
+	ws.reader, ws.writer, err = wso.buffers(conn, brw)
 	if err != nil{
+		conn.Close()
+		wso.handshakeFailed(r, 0, err)
 		return nil, err
 	}
 
-	ws.reader = bufio.NewReader(conn)
-	ws.writer = bufio.NewWriter(conn)
 	ws.t = t
 	ws.framingLimit = wso.MaxBytes
+	ws.repeatDataOpcode = wso.RepeatDataOpcode
+	ws.jsonOptions = wso.JSON
+	ws.codec = wso.Codec
+	ws.onAbuse = wso.OnAbuse
+	ws.readLimit = wso.ReadLimit
+	ws.maxFrameSize = wso.MaxFrameSize
+	ws.metrics = wso.Metrics
+	ws.logger.Store(wso.Logger)
+	ws.SetFrameTrace(wso.FrameTrace)
+	ws.queueSize = wso.QueueSize
+	ws.overflowPolicy = wso.OverflowPolicy
+	if wso.MaxFramesPerSecond > 0 {
+		ws.frameLimiter = newRateLimiter(wso.MaxFramesPerSecond, wso.FrameBurst)
+	}
+
+	ws.SetSendRate(wso.MaxBytesPerSecond, wso.ByteBurst)
+	ws.SetWriteCoalescing(wso.FlushDelay, wso.FlushSize)
 
-	err = wso.handshake(ws.writer, r)
+	if wso.HandshakeTimeout > 0 {
+		err = conn.SetWriteDeadline(time.Now().Add(wso.HandshakeTimeout))
+		if err != nil{
+			conn.Close()
+			wso.handshakeFailed(r, 0, err)
+			return nil, err
+		}
+	}
+
+	err = wso.handshake(ws.writer, r, ws.subprotocol, options.header)
 	if err != nil{
+		conn.Close()
+		wso.handshakeFailed(r, 0, err)
 		return nil, err
 	}
 
+	if wso.HandshakeTimeout > 0 {
+		err = conn.SetWriteDeadline(time.Time{})
+		if err != nil{
+			conn.Close()
+			wso.handshakeFailed(r, 0, err)
+			return nil, err
+		}
+	}
+
+	if wso.Capture != nil {
+		w := wso.Capture(&ws)
+		if w != nil {
+			err = ws.startCapture(w)
+			if err != nil{
+				conn.Close()
+				wso.handshakeFailed(r, 0, err)
+				return nil, err
+			}
+		}
+	}
+
+	ws.done = make(chan struct{})
+	ws.touch()
+	if ws.metrics != nil {
+		ws.metrics.ConnectionOpened(&ws)
+	}
+
+	if wso.Manager != nil {
+		err = wso.Manager.add(&ws, ip)
+		if err != nil{
+			// the shutdown started during the handshake
+			_ = ws.CloseWithCode(GoingAway, "")
+			return nil, err
+		}
+	}
+
+	if wso.IdleTimeout > 0 {
+		go ws.idleTimeout(wso.IdleTimeout)
+	}
+
+	if wso.SlowClientTimeout > 0 {
+		go ws.evictSlowClient(wso.slowClientPolicy())
+	}
+
+	if wso.PingInterval > 0 {
+		go ws.keepalive(wso.keepalivePolicy())
+	}
+
 	return &ws, nil
 }
 
+// buffers gives the reader and writer of a hijacked connection. The ReadWriter returned by
+// Hijack is reused when its buffers have the configured sizes. Otherwise new buffers are
+// created, and the bytes the client sent after its request, which the server may already
+// have read into the hijacked reader, are carried over so no frame is lost.
+func (wso *WSOpener) buffers(conn net.Conn, brw *bufio.ReadWriter) (*bufio.Reader, *bufio.Writer, error) {
+	reader := brw.Reader
+	if wso.ReadBufferSize > 0 && wso.ReadBufferSize != reader.Size() {
+		buffered, err := reader.Peek(reader.Buffered())
+		if err != nil {
+			return nil, nil, err
+		}
+
+		pipelined := bytes.NewReader(bytes.Clone(buffered))
+		reader = bufio.NewReaderSize(io.MultiReader(pipelined, conn), wso.ReadBufferSize)
+	}
+
+	writer := brw.Writer
+	if wso.WriteBufferSize > 0 && wso.WriteBufferSize != writer.Size() {
+		err := writer.Flush()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		writer = bufio.NewWriterSize(conn, wso.WriteBufferSize)
+	}
+
+	return reader, writer, nil
+}
+
+// checkRequest verifies that r is a websocket upgrade request the server accepts.
+// See Section 4.2.1 of RFC 6455. It gives the HTTP status to reject the request with.
+func (wso *WSOpener) checkRequest(r *http.Request) (int, error) {
+	if r.Method != http.MethodGet {
+		return http.StatusMethodNotAllowed, fmt.Errorf("%w: method is not GET", BadHandshake)
+	}
+
+	if !headerContainsToken(r.Header, "Connection", "upgrade") {
+		return http.StatusBadRequest, fmt.Errorf("%w: Connection header does not contain upgrade", BadHandshake)
+	}
+
+	if !headerContainsToken(r.Header, "Upgrade", "websocket") {
+		return http.StatusBadRequest, fmt.Errorf("%w: Upgrade header does not contain websocket", BadHandshake)
+	}
+
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		return http.StatusUpgradeRequired, fmt.Errorf("%w: unsupported Sec-WebSocket-Version", BadHandshake)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(r.Header.Get("Sec-WebSocket-Key"))
+	if err != nil || len(key) != 16 {
+		return http.StatusBadRequest, fmt.Errorf("%w: invalid Sec-WebSocket-Key", BadHandshake)
+	}
+
+	checkOrigin := wso.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+
+	if !checkOrigin(r) {
+		return http.StatusForbidden, OriginNotAllowed
+	}
+
+	return http.StatusOK, nil
+}
+
+// error rejects an upgrade request with the given status.
+func (wso *WSOpener) error(w http.ResponseWriter, r *http.Request, status int, reason error) {
+	wso.handshakeFailed(r, status, reason)
+	if status == http.StatusUpgradeRequired {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+	}
+
+	if wso.Error != nil {
+		wso.Error(w, r, status, reason)
+		return
+	}
+
+	http.Error(w, http.StatusText(status), status)
+}
+
+// selectSubprotocol gives the first of the server's subprotocols requested by the client,
+// or an empty string if there is none.
+func (wso *WSOpener) selectSubprotocol(r *http.Request) string {
+	requested := headerTokens(r.Header, "Sec-WebSocket-Protocol")
+	for _, supported := range wso.Subprotocols {
+		for _, protocol := range requested {
+			if protocol == supported {
+				return supported
+			}
+		}
+	}
+
+	return ""
+}
+
+// sameOrigin accepts requests without an Origin header, and requests whose Origin host is the Host of the request.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// headerTokens gives the comma separated tokens of all the values of a header.
+func headerTokens(h http.Header, name string) []string {
+	tokens := make([]string, 0)
+	for _, value := range h.Values(name) {
+		for _, token := range strings.Split(value, ",") {
+			token = strings.TrimSpace(token)
+			if token != "" {
+				tokens = append(tokens, token)
+			}
+		}
+	}
+
+	return tokens
+}
+
+// headerContainsToken reports whether a header has the token among its values, ignoring case.
+func headerContainsToken(h http.Header, name string, token string) bool {
+	for _, t := range headerTokens(h, name) {
+		if strings.EqualFold(t, token) {
+			return true
+		}
+	}
+
+	return false
+}
+
 // handshake performs the websocket handshake
-func (wso *WSOpener) handshake(writer *bufio.Writer,r *http.Request) error {
+func (wso *WSOpener) handshake(writer *bufio.Writer,r *http.Request, subprotocol string, header http.Header) error {
 	websocketKey := r.Header.Get("Sec-WebSocket-Key")
-	acceptToken := generateWebsocketAcceptToken(websocketKey)
+	acceptToken := ComputeAcceptKey(websocketKey)
 	response := newWebsocketAcceptResponse(acceptToken)
+	for name, values := range header {
+		if reservedResponseHeader(name) {
+			continue
+		}
+
+		for _, value := range values {
+			response.Header.Add(name, value)
+		}
+	}
+
+	if subprotocol != "" {
+		response.Header.Set("Sec-WebSocket-Protocol", subprotocol)
+	}
+
+	if wso.Capabilities != nil {
+		wso.capabilities().writeHeaders(response.Header)
+	}
+
 	err := response.Write(writer)
 	if err != nil{
 		return err
@@ -72,20 +585,6 @@ func (wso *WSOpener) handshake(writer *bufio.Writer,r *http.Request) error {
 	return nil
 }
 
-// generateWebsocketAcceptToken generates the token used as 
-// Sec-WebSocket-Accept header field.  The value of this
-// header field is constructed by concatenating /key/, defined
-// above in step 4 in Section 4.2.2, with the string "258EAFA5-
-// E914-47DA-95CA-C5AB0DC85B11", taking the SHA-1 hash of this
-// concatenated value to obtain a 20-byte value and base64-
-// encoding (see Section 4 of [RFC4648]) this 20-byte hash.
-func generateWebsocketAcceptToken(secWebsocketKey string) string {
-	combinedKey := []byte(fmt.Sprintf("%s%s", secWebsocketKey, websocketGUID))
-	hash := sha1.Sum(combinedKey)
-	encodedKey := base64.StdEncoding.EncodeToString(hash[:])
-	return encodedKey
-}
-
 func newWebsocketAcceptResponse(acceptToken string) *http.Response {
 	resp := http.Response{
 		Status: "101 Switching Protocols",
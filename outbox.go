@@ -0,0 +1,240 @@
+package websocket
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// OutboxMessage is a message kept in an outbox until its user connects.
+type OutboxMessage struct {
+	// Type and Data are the message.
+	Type MessageType `json:"type"`
+	Data []byte      `json:"data"`
+
+	// Time is when the message was sent.
+	Time time.Time `json:"time"`
+}
+
+// OutboxStore persists the messages of an Outbox, in the order they were appended, for
+// each user. The memory store is MemoryOutboxStore; persistent stores live in the outbox
+// subdirectories, in their own modules.
+type OutboxStore interface {
+	// Append adds a message after the messages kept for the user.
+	Append(ctx context.Context, user string, msg OutboxMessage) error
+
+	// Load gives the messages kept for the user, oldest first.
+	Load(ctx context.Context, user string) ([]OutboxMessage, error)
+
+	// Trim removes the n oldest messages kept for the user.
+	Trim(ctx context.Context, user string, n int) error
+}
+
+// Outbox delivers messages to logical users rather than connections: a message sent to a
+// user is queued to every connection of the user, and kept in the store when the user has
+// none, to be flushed to the next connection of the user. Messages of a user are delivered
+// in the order they were sent.
+type Outbox struct {
+	// Store keeps the messages of users without connections.
+	Store OutboxStore
+
+	// MaxAge is how long a message is kept for a user before it is no longer flushed.
+	// Zero keeps messages until they are flushed.
+	MaxAge time.Duration
+
+	mu        sync.Mutex
+	mailboxes map[string]*mailbox
+}
+
+// mailbox is the state of an outbox for a user with connections, or being sent to.
+type mailbox struct {
+	// mu is held while delivering to the user, so messages are delivered in order.
+	mu    sync.Mutex
+	conns map[*Websocket]struct{}
+
+	// users counts the goroutines holding or waiting for mu, the mailbox is dropped once
+	// there are none and the user has no connections.
+	users int
+}
+
+// NewOutbox returns an Outbox keeping messages in store.
+func NewOutbox(store OutboxStore) *Outbox {
+	return &Outbox{
+		Store:     store,
+		mailboxes: map[string]*mailbox{},
+	}
+}
+
+// Connect attaches ws to the user, first flushing the messages kept for the user to it.
+// The connection is detached once it closes. It fails if the messages cannot be loaded
+// from the store or flushed; those not flushed are kept.
+func (o *Outbox) Connect(ctx context.Context, user string, ws *Websocket) error {
+	mb := o.lock(user)
+	defer o.unlock(user, mb)
+
+	messages, err := o.Store.Load(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	flushed := 0
+	defer func() {
+		if flushed > 0 {
+			_ = o.Store.Trim(context.WithoutCancel(ctx), user, flushed)
+		}
+	}()
+
+	for _, msg := range messages {
+		if o.expired(msg) {
+			flushed++
+			continue
+		}
+
+		err = ws.Enqueue(ctx, Message{Type: msg.Type, Data: msg.Data})
+		if err != nil {
+			return err
+		}
+
+		flushed++
+	}
+
+	mb.conns[ws] = struct{}{}
+	context.AfterFunc(ws.Context(), func() {
+		o.disconnect(user, ws)
+	})
+
+	return nil
+}
+
+// Send delivers a message to the connections of the user, or keeps it in the store when
+// none of them accepted it.
+func (o *Outbox) Send(ctx context.Context, user string, t MessageType, data []byte) error {
+	_, err := t.opcode()
+	if err != nil {
+		return err
+	}
+
+	mb := o.lock(user)
+	defer o.unlock(user, mb)
+
+	delivered := false
+	for ws := range mb.conns {
+		if ws.Enqueue(ctx, Message{Type: t, Data: data}) == nil {
+			delivered = true
+		}
+	}
+
+	if delivered {
+		return nil
+	}
+
+	return o.Store.Append(ctx, user, OutboxMessage{Type: t, Data: data, Time: time.Now()})
+}
+
+// Online reports whether the user has connections attached.
+func (o *Outbox) Online(user string) bool {
+	mb := o.lock(user)
+	defer o.unlock(user, mb)
+	return len(mb.conns) > 0
+}
+
+// disconnect detaches the closed connection from the user.
+func (o *Outbox) disconnect(user string, ws *Websocket) {
+	mb := o.lock(user)
+	defer o.unlock(user, mb)
+	delete(mb.conns, ws)
+}
+
+// lock locks the mailbox of the user, creating it if needed.
+func (o *Outbox) lock(user string) *mailbox {
+	o.mu.Lock()
+	if o.mailboxes == nil {
+		o.mailboxes = map[string]*mailbox{}
+	}
+
+	mb, ok := o.mailboxes[user]
+	if !ok {
+		mb = &mailbox{conns: map[*Websocket]struct{}{}}
+		o.mailboxes[user] = mb
+	}
+
+	mb.users++
+	o.mu.Unlock()
+
+	mb.mu.Lock()
+	return mb
+}
+
+// unlock unlocks the mailbox of the user, dropping it when it is no longer needed.
+func (o *Outbox) unlock(user string, mb *mailbox) {
+	empty := len(mb.conns) == 0
+	mb.mu.Unlock()
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	mb.users--
+	// conns only changes under mu, which nobody holds or waits for once users is zero
+	if mb.users == 0 && empty {
+		delete(o.mailboxes, user)
+	}
+}
+
+func (o *Outbox) expired(msg OutboxMessage) bool {
+	return o.MaxAge > 0 && time.Since(msg.Time) > o.MaxAge
+}
+
+// MemoryOutboxStore is an OutboxStore keeping messages in memory, which are lost when the
+// process exits.
+type MemoryOutboxStore struct {
+	// Limit is the number of messages kept for a user, the oldest being dropped to make
+	// room for new ones. Zero keeps every message.
+	Limit int
+
+	mu       sync.Mutex
+	messages map[string][]OutboxMessage
+}
+
+// NewMemoryOutboxStore returns an empty MemoryOutboxStore.
+func NewMemoryOutboxStore() *MemoryOutboxStore {
+	return &MemoryOutboxStore{
+		messages: map[string][]OutboxMessage{},
+	}
+}
+
+// Append adds a message for the user, dropping the oldest one when the limit is reached.
+func (s *MemoryOutboxStore) Append(ctx context.Context, user string, msg OutboxMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.messages == nil {
+		s.messages = map[string][]OutboxMessage{}
+	}
+
+	messages := append(s.messages[user], msg)
+	if s.Limit > 0 && len(messages) > s.Limit {
+		messages = messages[len(messages)-s.Limit:]
+	}
+
+	s.messages[user] = messages
+	return nil
+}
+
+// Load gives a copy of the messages kept for the user.
+func (s *MemoryOutboxStore) Load(ctx context.Context, user string) ([]OutboxMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]OutboxMessage(nil), s.messages[user]...), nil
+}
+
+// Trim removes the n oldest messages kept for the user.
+func (s *MemoryOutboxStore) Trim(ctx context.Context, user string, n int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	messages := s.messages[user]
+	if n >= len(messages) {
+		delete(s.messages, user)
+		return nil
+	}
+
+	s.messages[user] = messages[n:]
+	return nil
+}
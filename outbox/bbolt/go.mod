@@ -0,0 +1,12 @@
+module github.com/ajsqr/websocket/outbox/bbolt
+
+go 1.23.4
+
+require (
+	github.com/ajsqr/websocket v0.0.0
+	go.etcd.io/bbolt v1.3.11
+)
+
+require golang.org/x/sys v0.4.0 // indirect
+
+replace github.com/ajsqr/websocket => ../../
@@ -0,0 +1,155 @@
+// Package bbolt provides a websocket.OutboxStore over a bbolt database, so the messages
+// kept for offline users survive restarts of a single server instance.
+//
+// It lives in its own module so the websocket package itself stays free of
+// external dependencies.
+package bbolt
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+
+	"github.com/ajsqr/websocket"
+	bolt "go.etcd.io/bbolt"
+)
+
+// defaultBucket is the bucket used when Store.Bucket is not set.
+const defaultBucket = "websocket-outbox"
+
+// Store keeps the messages of each user in a bucket of their own, nested in Bucket, keyed
+// by the big-endian sequence number of the message in the bucket.
+type Store struct {
+	// DB is the database the messages are kept in.
+	DB *bolt.DB
+
+	// Bucket is the name of the bucket holding the bucket of every user.
+	// It defaults to "websocket-outbox".
+	Bucket string
+
+	// Limit is the number of messages kept for a user, the oldest being dropped to make
+	// room for new ones. Zero keeps every message.
+	Limit int
+}
+
+// Append adds a message to the bucket of the user.
+func (s *Store) Append(ctx context.Context, user string, msg websocket.OutboxMessage) error {
+	value, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	return s.DB.Update(func(tx *bolt.Tx) error {
+		root, err := tx.CreateBucketIfNotExists([]byte(s.bucket()))
+		if err != nil {
+			return err
+		}
+
+		b, err := root.CreateBucketIfNotExists([]byte(user))
+		if err != nil {
+			return err
+		}
+
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		err = b.Put(key(seq), value)
+		if err != nil {
+			return err
+		}
+
+		if s.Limit <= 0 {
+			return nil
+		}
+
+		// messages are only removed from the front, so the keys are contiguous
+		first, _ := b.Cursor().First()
+		return trim(b, int(seq-binary.BigEndian.Uint64(first))+1-s.Limit)
+	})
+}
+
+// Load gives the messages in the bucket of the user. Messages that cannot be decoded
+// are skipped.
+func (s *Store) Load(ctx context.Context, user string) ([]websocket.OutboxMessage, error) {
+	var messages []websocket.OutboxMessage
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		b := s.userBucket(tx, user)
+		if b == nil {
+			return nil
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			msg := websocket.OutboxMessage{}
+			if json.Unmarshal(v, &msg) == nil {
+				messages = append(messages, msg)
+			}
+
+			return nil
+		})
+	})
+
+	return messages, err
+}
+
+// Trim removes the n oldest messages from the bucket of the user, and the bucket itself
+// once it is empty.
+func (s *Store) Trim(ctx context.Context, user string, n int) error {
+	return s.DB.Update(func(tx *bolt.Tx) error {
+		b := s.userBucket(tx, user)
+		if b == nil {
+			return nil
+		}
+
+		err := trim(b, n)
+		if err != nil {
+			return err
+		}
+
+		if k, _ := b.Cursor().First(); k == nil {
+			return tx.Bucket([]byte(s.bucket())).DeleteBucket([]byte(user))
+		}
+
+		return nil
+	})
+}
+
+func (s *Store) userBucket(tx *bolt.Tx, user string) *bolt.Bucket {
+	root := tx.Bucket([]byte(s.bucket()))
+	if root == nil {
+		return nil
+	}
+
+	return root.Bucket([]byte(user))
+}
+
+func (s *Store) bucket() string {
+	if s.Bucket == "" {
+		return defaultBucket
+	}
+
+	return s.Bucket
+}
+
+// trim deletes the n first keys of the bucket.
+func trim(b *bolt.Bucket, n int) error {
+	c := b.Cursor()
+	for k, _ := c.First(); k != nil && n > 0; k, _ = c.First() {
+		err := c.Delete()
+		if err != nil {
+			return err
+		}
+
+		n--
+	}
+
+	return nil
+}
+
+// key encodes a sequence number so keys sort in the order messages were appended.
+func key(seq uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, seq)
+	return k
+}
@@ -0,0 +1,95 @@
+// Package redis provides a websocket.OutboxStore over Redis lists, so the messages kept
+// for offline users are shared by every server instance and flushed by whichever the
+// user reconnects to.
+//
+// It lives in its own module so the websocket package itself stays free of
+// external dependencies.
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/ajsqr/websocket"
+	"github.com/redis/go-redis/v9"
+)
+
+// defaultPrefix is the key prefix used when Store.Prefix is not set.
+const defaultPrefix = "websocket:outbox"
+
+// Store keeps the messages of each user in a Redis list, at the key Prefix:user.
+type Store struct {
+	// Client is the connection to Redis.
+	Client redis.UniversalClient
+
+	// Prefix is the prefix of the keys of the lists. It defaults to "websocket:outbox".
+	Prefix string
+
+	// Limit is the number of messages kept for a user, the oldest being dropped to make
+	// room for new ones. Zero keeps every message.
+	Limit int
+
+	// TTL, if set, expires the list of a user once no message was appended to it for
+	// that long.
+	TTL time.Duration
+}
+
+// Append pushes a message at the end of the list of the user.
+func (s *Store) Append(ctx context.Context, user string, msg websocket.OutboxMessage) error {
+	value, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	key := s.Key(user)
+	_, err = s.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.RPush(ctx, key, value)
+		if s.Limit > 0 {
+			pipe.LTrim(ctx, key, int64(-s.Limit), -1)
+		}
+
+		if s.TTL > 0 {
+			pipe.Expire(ctx, key, s.TTL)
+		}
+
+		return nil
+	})
+
+	return err
+}
+
+// Load gives the messages in the list of the user. Messages that cannot be decoded
+// are skipped.
+func (s *Store) Load(ctx context.Context, user string) ([]websocket.OutboxMessage, error) {
+	values, err := s.Client.LRange(ctx, s.Key(user), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]websocket.OutboxMessage, 0, len(values))
+	for _, value := range values {
+		msg := websocket.OutboxMessage{}
+		if json.Unmarshal([]byte(value), &msg) == nil {
+			messages = append(messages, msg)
+		}
+	}
+
+	return messages, nil
+}
+
+// Trim removes the n first messages of the list of the user. Redis deletes the list
+// once it is empty.
+func (s *Store) Trim(ctx context.Context, user string, n int) error {
+	return s.Client.LTrim(ctx, s.Key(user), int64(n), -1).Err()
+}
+
+// Key gives the key of the list of the user.
+func (s *Store) Key(user string) string {
+	prefix := s.Prefix
+	if prefix == "" {
+		prefix = defaultPrefix
+	}
+
+	return prefix + ":" + user
+}
@@ -0,0 +1,222 @@
+package websocket
+
+import (
+	"context"
+	"sync"
+	"syscall"
+)
+
+// Poller waits for messages on many connections with the event notification facility of
+// the operating system, epoll, instead of a goroutine blocked in a read for each of them,
+// so a server can hold millions of mostly idle connections. A connection only has a
+// goroutine while its messages are being handled. Pollers are only supported on Linux;
+// elsewhere NewPoller fails with PollingNotSupported.
+//
+// Only connections over a socket can be polled, not those over TLS or other wrapping
+// connections, whose reads may need more than the socket to be readable.
+type Poller struct {
+	sys *pollerSys
+
+	mu     sync.Mutex
+	conns  map[int]*polled
+	closed bool
+
+	// done is closed once the event loop returned.
+	done chan struct{}
+}
+
+// polled is a connection parked on a poller.
+type polled struct {
+	ws   *Websocket
+	fd   int
+	h    Handlers
+	stop func() bool
+}
+
+// NewPoller starts a poller. Its event loop runs until Close is called.
+func NewPoller() (*Poller, error) {
+	sys, err := newPollerSys()
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Poller{
+		sys:   sys,
+		conns: map[int]*polled{},
+		done:  make(chan struct{}),
+	}
+
+	go p.run()
+	return p, nil
+}
+
+// Add parks ws on the poller. Each time data arrives, the messages received are passed
+// to the handlers as by Listen, in a goroutine that returns once no more data is buffered,
+// the connection being parked again. When the connection closes or a handler fails, it is
+// removed and OnError and OnClose are called as Listen would. The poller owns the reads
+// of the connection until then.
+func (p *Poller) Add(ws *Websocket, h Handlers) error {
+	if ws.State() == StateClosed {
+		return ErrConnectionClosed
+	}
+
+	fd, err := socketFD(ws)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return PollerClosed
+	}
+
+	pc := &polled{ws: ws, fd: fd, h: h}
+	ctx := ws.Context()
+	pc.stop = context.AfterFunc(ctx, func() {
+		p.remove(pc)
+	})
+
+	// the connection is only read through the poller from now on, so the handler stays
+	_ = ws.listenPings(ctx, h)
+	p.conns[fd] = pc
+	if ws.reader.Buffered() > 0 {
+		// the data already buffered would not make the socket readable again
+		go p.serve(pc)
+		return nil
+	}
+
+	err = p.sys.add(fd)
+	if err != nil {
+		pc.stop()
+		delete(p.conns, fd)
+		return err
+	}
+
+	return nil
+}
+
+// Len gives the number of connections on the poller.
+func (p *Poller) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.conns)
+}
+
+// Close stops the poller. The connections on it are left open, without a reader.
+func (p *Poller) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+
+	p.closed = true
+	for _, pc := range p.conns {
+		pc.stop()
+	}
+
+	clear(p.conns)
+	p.mu.Unlock()
+
+	err := p.sys.wake()
+	<-p.done
+	return err
+}
+
+// run waits for connections to become readable until the poller is closed.
+func (p *Poller) run() {
+	defer close(p.done)
+	defer p.sys.close()
+	fds := make([]int, 0, 128)
+	for {
+		var err error
+		fds, err = p.sys.wait(fds[:0])
+		if err != nil && err != syscall.EINTR {
+			return
+		}
+
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return
+		}
+
+		for _, fd := range fds {
+			pc, ok := p.conns[fd]
+			if ok {
+				go p.serve(pc)
+			}
+		}
+		p.mu.Unlock()
+	}
+}
+
+// serve handles the messages of a readable connection, then parks it again.
+func (p *Poller) serve(pc *polled) {
+	pc.ws.labelGoroutine()
+	ctx := pc.ws.Context()
+	for {
+		err := pc.ws.handleMessage(ctx, pc.h)
+		if err != nil {
+			p.remove(pc)
+			_ = pc.ws.stopListening(pc.h, err)
+			return
+		}
+
+		if pc.ws.reader.Buffered() == 0 {
+			break
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conns[pc.fd] != pc {
+		// removed while its messages were handled
+		return
+	}
+
+	err := p.sys.rearm(pc.fd)
+	if err != nil {
+		delete(p.conns, pc.fd)
+		pc.stop()
+		go pc.ws.fail(InternalServerError, err)
+	}
+}
+
+// remove takes the connection off the poller, unless the descriptor was reused already.
+func (p *Poller) remove(pc *polled) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conns[pc.fd] != pc {
+		return
+	}
+
+	delete(p.conns, pc.fd)
+	pc.stop()
+	_ = p.sys.remove(pc.fd)
+}
+
+// socketFD gives the file descriptor of the socket of ws.
+func socketFD(ws *Websocket) (int, error) {
+	sc, ok := ws.conn.(syscall.Conn)
+	if !ok {
+		return 0, PollingNotSupported
+	}
+
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+
+	fd := -1
+	err = raw.Control(func(s uintptr) {
+		fd = int(s)
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return fd, nil
+}
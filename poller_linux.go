@@ -0,0 +1,96 @@
+//go:build linux
+
+package websocket
+
+import (
+	"errors"
+	"syscall"
+)
+
+// pollerSys is the epoll instance of a Poller, with a pipe to wake up its event loop.
+type pollerSys struct {
+	epfd int
+	pipe [2]int
+}
+
+func newPollerSys() (*pollerSys, error) {
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &pollerSys{epfd: epfd}
+	err = syscall.Pipe2(s.pipe[:], syscall.O_NONBLOCK|syscall.O_CLOEXEC)
+	if err != nil {
+		syscall.Close(epfd)
+		return nil, err
+	}
+
+	err = syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, s.pipe[0], &syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(s.pipe[0])})
+	if err != nil {
+		s.close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// connEvents are the events a connection is watched for. With EPOLLONESHOT, a readable
+// connection is reported once and ignored until it is rearmed, so it is only handled by
+// one goroutine at a time.
+const connEvents = syscall.EPOLLIN | syscall.EPOLLRDHUP | syscall.EPOLLONESHOT
+
+func (s *pollerSys) add(fd int) error {
+	event := &syscall.EpollEvent{Events: connEvents, Fd: int32(fd)}
+	err := syscall.EpollCtl(s.epfd, syscall.EPOLL_CTL_ADD, fd, event)
+	if errors.Is(err, syscall.EEXIST) {
+		// the descriptor of a closed connection was reused before it left the poller
+		return syscall.EpollCtl(s.epfd, syscall.EPOLL_CTL_MOD, fd, event)
+	}
+
+	return err
+}
+
+func (s *pollerSys) rearm(fd int) error {
+	event := &syscall.EpollEvent{Events: connEvents, Fd: int32(fd)}
+	err := syscall.EpollCtl(s.epfd, syscall.EPOLL_CTL_MOD, fd, event)
+	if errors.Is(err, syscall.ENOENT) {
+		// served for the data buffered when it was added, before it was ever watched
+		return syscall.EpollCtl(s.epfd, syscall.EPOLL_CTL_ADD, fd, event)
+	}
+
+	return err
+}
+
+func (s *pollerSys) remove(fd int) error {
+	return syscall.EpollCtl(s.epfd, syscall.EPOLL_CTL_DEL, fd, nil)
+}
+
+// wait blocks until descriptors are readable and appends them to fds.
+func (s *pollerSys) wait(fds []int) ([]int, error) {
+	var events [128]syscall.EpollEvent
+	n, err := syscall.EpollWait(s.epfd, events[:], -1)
+	if err != nil {
+		return fds, err
+	}
+
+	for _, event := range events[:n] {
+		if int(event.Fd) != s.pipe[0] {
+			fds = append(fds, int(event.Fd))
+		}
+	}
+
+	return fds, nil
+}
+
+// wake interrupts wait, for the event loop to notice the poller was closed.
+func (s *pollerSys) wake() error {
+	_, err := syscall.Write(s.pipe[1], []byte{0})
+	return err
+}
+
+func (s *pollerSys) close() error {
+	syscall.Close(s.pipe[0])
+	syscall.Close(s.pipe[1])
+	return syscall.Close(s.epfd)
+}
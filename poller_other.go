@@ -0,0 +1,34 @@
+//go:build !linux
+
+package websocket
+
+// pollerSys stands for the event notification facility, which is only used on Linux.
+type pollerSys struct{}
+
+func newPollerSys() (*pollerSys, error) {
+	return nil, PollingNotSupported
+}
+
+func (s *pollerSys) add(fd int) error {
+	return PollingNotSupported
+}
+
+func (s *pollerSys) rearm(fd int) error {
+	return PollingNotSupported
+}
+
+func (s *pollerSys) remove(fd int) error {
+	return PollingNotSupported
+}
+
+func (s *pollerSys) wait(fds []int) ([]int, error) {
+	return fds, PollingNotSupported
+}
+
+func (s *pollerSys) wake() error {
+	return PollingNotSupported
+}
+
+func (s *pollerSys) close() error {
+	return PollingNotSupported
+}
@@ -0,0 +1,113 @@
+package websocket
+
+import (
+	"bytes"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// minPooledBufferSize is the capacity a buffer may always keep when returned to a pool,
+	// however small recent messages have been.
+	minPooledBufferSize = 64 << 10
+
+	// maxPooledPayloadSize is the capacity above which payload buffers are left to the
+	// garbage collector rather than pooled.
+	maxPooledPayloadSize = 1 << 20
+)
+
+// assemblyPool holds the buffers Receive concatenates the fragments of a message into,
+// and those handed out by ReceiveBuffer.
+var assemblyPool = &messageBufferPool{}
+
+// framePool holds the frames the read and write paths build for their own use and
+// release once they are done with them.
+var framePool = sync.Pool{
+	New: func() any {
+		return &Frame{}
+	},
+}
+
+// getFrame returns an empty frame from framePool.
+func getFrame() *Frame {
+	return framePool.Get().(*Frame)
+}
+
+// putFrame resets the frame and returns it to framePool. Frames that escape to callers,
+// such as those ReadFrame returns or a PreparedMessage caches, must never be put.
+func putFrame(f *Frame) {
+	*f = Frame{}
+	framePool.Put(f)
+}
+
+// payloadPool holds the scratch buffers frame payloads are read into or masked in.
+var payloadPool sync.Pool
+
+// getPayload returns a buffer of n bytes from payloadPool.
+func getPayload(n int) *[]byte {
+	b, ok := payloadPool.Get().(*[]byte)
+	if !ok || cap(*b) < n {
+		if ok {
+			payloadPool.Put(b)
+		}
+
+		b = new([]byte)
+		*b = make([]byte, n)
+	}
+
+	*b = (*b)[:n]
+	return b
+}
+
+// putPayload returns a buffer obtained from getPayload once it is no longer used.
+func putPayload(b *[]byte) {
+	if cap(*b) > maxPooledPayloadSize {
+		return
+	}
+
+	payloadPool.Put(b)
+}
+
+// messageBufferPool pools message buffers and sizes them by recent message-size statistics.
+// New buffers are grown to the average message size up front, and buffers that grew far
+// beyond it for an outlier message are dropped instead of being held on to.
+type messageBufferPool struct {
+	pool sync.Pool
+
+	// averageSize is an exponentially weighted moving average of the sizes of recent messages.
+	averageSize atomic.Int64
+}
+
+// get returns an empty buffer with room for a message of average size.
+func (p *messageBufferPool) get() *bytes.Buffer {
+	buf, ok := p.pool.Get().(*bytes.Buffer)
+	if !ok {
+		buf = &bytes.Buffer{}
+	}
+
+	buf.Grow(int(p.averageSize.Load()))
+	return buf
+}
+
+// put records the size of the message held by buf and returns buf to the pool.
+func (p *messageBufferPool) put(buf *bytes.Buffer) {
+	size := int64(buf.Len())
+	for {
+		average := p.averageSize.Load()
+		if p.averageSize.CompareAndSwap(average, average+(size-average)/8) {
+			break
+		}
+	}
+
+	limit := 4 * p.averageSize.Load()
+	if limit < minPooledBufferSize {
+		limit = minPooledBufferSize
+	}
+
+	if int64(buf.Cap()) > limit {
+		return
+	}
+
+	buf.Reset()
+	p.pool.Put(buf)
+}
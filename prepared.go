@@ -0,0 +1,112 @@
+package websocket
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// PreparedMessage is a message whose frames are built once and shared by every
+// connection it is sent to, so broadcasting it costs no more per connection than
+// writing it. Connections with different framing settings get their own frames,
+// built on first use.
+type PreparedMessage struct {
+	t    MessageType
+	data []byte
+
+	mu     sync.Mutex
+	frames map[framing][]*Frame
+
+	// sse is the message encoded as a Server-Sent Event, built on first use.
+	sse []byte
+}
+
+// framing identifies the settings that decide how a message is split into frames.
+type framing struct {
+	limit            int
+	repeatDataOpcode bool
+}
+
+// NewPreparedMessage prepares data to be sent as a message of type t.
+// The data must not be modified afterwards.
+func NewPreparedMessage(t MessageType, data []byte) (*PreparedMessage, error) {
+	_, err := t.opcode()
+	if err != nil {
+		return nil, err
+	}
+
+	return &PreparedMessage{
+		t:      t,
+		data:   data,
+		frames: map[framing][]*Frame{},
+	}, nil
+}
+
+// Type gives the type of the message.
+func (pm *PreparedMessage) Type() MessageType {
+	return pm.t
+}
+
+// Data gives the payload of the message.
+func (pm *PreparedMessage) Data() []byte {
+	return pm.data
+}
+
+// framesFor gives the frames of the message for the framing settings of ws.
+func (pm *PreparedMessage) framesFor(ws *Websocket) ([]*Frame, error) {
+	key := framing{
+		limit:            ws.framingLimit,
+		repeatDataOpcode: ws.repeatDataOpcode,
+	}
+
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	frames, ok := pm.frames[key]
+	if ok {
+		return frames, nil
+	}
+
+	opcode, err := pm.t.opcode()
+	if err != nil {
+		return nil, err
+	}
+
+	frames, err = ws.fragment(opcode, pm.data)
+	if err != nil {
+		return nil, err
+	}
+
+	pm.frames[key] = frames
+	return frames, nil
+}
+
+// WritePreparedMessage sends the prepared message. Like Send, it is safe to call
+// concurrently with the other write methods.
+func (ws *Websocket) WritePreparedMessage(ctx context.Context, pm *PreparedMessage) (err error) {
+	if ws.State() != StateOpen {
+		return ErrConnectionClosed
+	}
+
+	defer ws.checkError(&err)
+	frames, err := pm.framesFor(ws)
+	if err != nil {
+		return err
+	}
+
+	ws.messageMu.Lock()
+	defer ws.messageMu.Unlock()
+	ws.touch()
+	defer ws.timeWrite()()
+	started := time.Now()
+
+	for i, frame := range frames {
+		err := ws.writeDataFrame(ctx, frame)
+		if err != nil {
+			ws.abandonMessage(i, err)
+			return err
+		}
+	}
+
+	ws.messageSent(frames[0].Opcode, len(pm.data), started)
+	return nil
+}
@@ -0,0 +1,203 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// pumps are the goroutines moving messages between the connection and the channels
+// of Incoming and Outgoing.
+type pumps struct {
+	inOnce   sync.Once
+	incoming chan Message
+
+	outOnce  sync.Once
+	outgoing chan Message
+
+	// writeOnce creates queue, the send queue drained by writePump.
+	writeOnce sync.Once
+
+	mu sync.Mutex
+
+	// queue is the send queue, nil until Enqueue or Outgoing is first called.
+	queue *messageQueue
+
+	// err is the first error that stopped a pump, other than the connection closing.
+	err error
+}
+
+// Incoming gives a channel delivering the messages received from the client, for
+// applications structured around select loops. The first call starts a goroutine
+// reading the connection, so no other reads may be made once it is used. The channel
+// is closed when reading stops; Err then gives the reason, if it was not the
+// connection closing.
+func (ws *Websocket) Incoming() <-chan Message {
+	ws.pumps.inOnce.Do(func() {
+		ws.pumps.incoming = make(chan Message)
+		go ws.readPump()
+	})
+
+	return ws.pumps.incoming
+}
+
+// Outgoing gives a channel whose messages are added to the send queue of the connection
+// by a goroutine started on the first call, following its overflow policy; see Enqueue.
+// Closing the channel closes the connection once the messages before it are sent.
+// If a send fails the goroutines stop and Err gives the reason; senders should select
+// on the Done channel of the connection Context so they do not block once it is closed.
+func (ws *Websocket) Outgoing() chan<- Message {
+	ws.pumps.outOnce.Do(func() {
+		ws.pumps.outgoing = make(chan Message)
+		go ws.forwardOutgoing(ws.sendQueue())
+	})
+
+	return ws.pumps.outgoing
+}
+
+// Err gives the error that stopped the goroutines of Incoming or Outgoing, or nil
+// if they are running or stopped because the connection was closed.
+func (ws *Websocket) Err() error {
+	ws.pumps.mu.Lock()
+	defer ws.pumps.mu.Unlock()
+	return ws.pumps.err
+}
+
+// readPump receives messages and delivers them to the Incoming channel until reading fails.
+func (ws *Websocket) readPump() {
+	ws.labelGoroutine()
+	defer close(ws.pumps.incoming)
+
+	ctx := ws.Context()
+	for {
+		t, data, err := ws.receiveMessage(ctx)
+		if err != nil {
+			ws.stopPump(err)
+			return
+		}
+
+		select {
+		case ws.pumps.incoming <- Message{Type: t, Data: data}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// forwardOutgoing moves the messages of the Outgoing channel to the send queue until it is closed.
+func (ws *Websocket) forwardOutgoing(q *messageQueue) {
+	ws.labelGoroutine()
+	ctx := ws.Context()
+	for {
+		select {
+		case msg, ok := <-ws.pumps.outgoing:
+			if !ok {
+				q.close()
+				return
+			}
+
+			err := q.push(ctx, ws, queuedMessage{Message: msg})
+			if err != nil && !errors.Is(err, QueueFull) {
+				ws.stopPump(err)
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// writePump sends the messages of the send queue until it is closed or sending fails.
+func (ws *Websocket) writePump() {
+	ws.labelGoroutine()
+	ctx := ws.Context()
+	q := ws.pumps.queue
+	var err error
+	for {
+		msg, ok := q.pop(ctx.Done())
+		if !ok {
+			if ctx.Err() == nil {
+				// the queue was closed along with the Outgoing channel
+				ws.stopPump(ws.Close())
+			}
+
+			return
+		}
+
+		if msg.prepared != nil {
+			err = ws.WritePreparedMessage(ctx, msg.prepared)
+		} else {
+			err = ws.sendMessage(ctx, msg.Message)
+		}
+
+		if err != nil {
+			ws.stopPump(err)
+			return
+		}
+	}
+}
+
+// sendMessage sends msg as a single message of its type.
+func (ws *Websocket) sendMessage(ctx context.Context, msg Message) error {
+	opcode, err := msg.Type.opcode()
+	if err != nil {
+		return err
+	}
+
+	return ws.send(ctx, opcode, msg.Data)
+}
+
+// stopPump records the error that stopped a pump, unless it only means the connection closed.
+func (ws *Websocket) stopPump(err error) {
+	if err == nil || isCleanClose(err) {
+		return
+	}
+
+	if errors.Is(err, context.Canceled) && ws.Context().Err() != nil {
+		// the context of the connection is cancelled when it is closed under the pump
+		return
+	}
+
+	ws.pumps.mu.Lock()
+	defer ws.pumps.mu.Unlock()
+	if ws.pumps.err == nil {
+		ws.pumps.err = err
+	}
+}
+
+// Run services the connection until ctx is done or the connection closes: it starts the
+// goroutines of Incoming and Outgoing, alongside the keepalive started by Open, and
+// waits. Received messages are delivered on Incoming, which must be drained for reading
+// to progress. When ctx is done the connection is closed with GoingAway and ctx.Err()
+// is returned. Otherwise Run returns nil if the connection was closed with a closing
+// handshake or by a call to Close, and the error that terminated it if not, so it can
+// be run as one of the goroutines of an errgroup.
+func (ws *Websocket) Run(ctx context.Context) error {
+	ws.Incoming()
+	ws.Outgoing()
+
+	select {
+	case <-ctx.Done():
+		err := ws.CloseWithCode(GoingAway, "")
+		if err != nil && !errors.Is(err, ErrConnectionClosed) {
+			return errors.Join(ctx.Err(), err)
+		}
+
+		return ctx.Err()
+	case <-ws.Context().Done():
+	}
+
+	err := ws.Err()
+	if err != nil {
+		return err
+	}
+
+	ws.stateMu.Lock()
+	cause := ws.closeCause
+	ws.stateMu.Unlock()
+	if cause == nil || isCleanClose(cause) {
+		return nil
+	}
+
+	return cause
+}
@@ -0,0 +1,305 @@
+package websocket
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// defaultQueueSize is the capacity of the send queue when WSOpener.QueueSize is not set.
+const defaultQueueSize = 64
+
+// OverflowPolicy decides what happens to a message enqueued while the send queue is full.
+type OverflowPolicy string
+
+var (
+	// OverflowBlock makes Enqueue wait until the queue has room, the context is done or the connection closes.
+	OverflowBlock OverflowPolicy = "block"
+
+	// OverflowDropOldest discards the oldest queued message to make room for the new one.
+	OverflowDropOldest OverflowPolicy = "drop-oldest"
+
+	// OverflowDropNewest discards the new message; Enqueue fails with QueueFull.
+	OverflowDropNewest OverflowPolicy = "drop-newest"
+
+	// OverflowClose closes the connection with TryAgainLater; Enqueue fails with QueueFull.
+	OverflowClose OverflowPolicy = "close"
+)
+
+// EnqueueOption customizes a single call to Enqueue.
+type EnqueueOption func(*queuedMessage)
+
+// WithTTL drops the message instead of sending it if it is still queued after ttl,
+// so stale data such as superseded state is not delivered late to a slow client.
+func WithTTL(ttl time.Duration) EnqueueOption {
+	return func(m *queuedMessage) {
+		m.expires = time.Now().Add(ttl)
+	}
+}
+
+// Enqueue adds msg to the send queue of the connection, whose messages are sent in order
+// by the goroutine also serving Outgoing, and returns without waiting for it to be sent.
+// When the queue is full the overflow policy of the connection applies, so a publisher
+// writing to many connections is not held up by a slow one unless the policy is OverflowBlock.
+func (ws *Websocket) Enqueue(ctx context.Context, msg Message, opts ...EnqueueOption) error {
+	if ws.State() != StateOpen {
+		return ErrConnectionClosed
+	}
+
+	_, err := msg.Type.opcode()
+	if err != nil {
+		return err
+	}
+
+	m := queuedMessage{Message: msg}
+	for _, opt := range opts {
+		opt(&m)
+	}
+
+	return ws.sendQueue().push(ctx, ws, m)
+}
+
+// SetQueue sets the capacity and overflow policy of the send queue. It has no effect once
+// Enqueue or Outgoing has been called. A size of zero or less uses a default of 64
+// messages, and an empty policy defaults to OverflowBlock.
+func (ws *Websocket) SetQueue(size int, policy OverflowPolicy) {
+	ws.queueSize = size
+	ws.overflowPolicy = policy
+}
+
+// sendQueue gives the send queue of the connection, creating it and starting the
+// goroutine draining it on the first call.
+func (ws *Websocket) sendQueue() *messageQueue {
+	ws.pumps.writeOnce.Do(func() {
+		size := ws.queueSize
+		if size <= 0 {
+			size = defaultQueueSize
+		}
+
+		policy := ws.overflowPolicy
+		if policy == "" {
+			policy = OverflowBlock
+		}
+
+		q := &messageQueue{
+			size:   size,
+			policy: policy,
+			ready:  make(chan struct{}, 1),
+			space:  make(chan struct{}, 1),
+		}
+
+		ws.pumps.mu.Lock()
+		ws.pumps.queue = q
+		ws.pumps.mu.Unlock()
+
+		if !ws.eventStream {
+			go ws.writePump()
+		}
+	})
+
+	return ws.pumps.queue
+}
+
+// EnqueuePrepared adds the prepared message to the send queue of the connection, like Enqueue.
+func (ws *Websocket) EnqueuePrepared(ctx context.Context, pm *PreparedMessage, opts ...EnqueueOption) error {
+	if ws.State() != StateOpen {
+		return ErrConnectionClosed
+	}
+
+	m := queuedMessage{
+		Message:  Message{Type: pm.t, Data: pm.data},
+		prepared: pm,
+	}
+
+	for _, opt := range opts {
+		opt(&m)
+	}
+
+	return ws.sendQueue().push(ctx, ws, m)
+}
+
+// queuedMessage is a message waiting in the send queue.
+type queuedMessage struct {
+	Message
+
+	// prepared, if set, is sent instead of Message.
+	prepared *PreparedMessage
+
+	// expires, if set, is the time after which the message is dropped instead of sent.
+	expires time.Time
+
+	// blockUntil, if set, is when a push blocked by a full queue gives up and fails with
+	// SlowClient, for hub deliveries that must not wait on one member for long.
+	blockUntil time.Time
+}
+
+// expired reports whether the message must no longer be sent at now.
+func (m *queuedMessage) expired(now time.Time) bool {
+	return !m.expires.IsZero() && now.After(m.expires)
+}
+
+// messageQueue is a bounded FIFO of messages waiting to be sent.
+type messageQueue struct {
+	mu       sync.Mutex
+	messages []queuedMessage
+	size     int
+	policy   OverflowPolicy
+
+	// closed is set once no more messages will be pushed, so the queue ends when drained.
+	closed bool
+
+	// writing is set while the message last taken off the queue is being written, that is
+	// until the writer comes back for the next one.
+	writing bool
+
+	// ready and space are signalled when a message is pushed and taken, respectively.
+	ready chan struct{}
+	space chan struct{}
+}
+
+// push adds msg to the queue, applying the overflow policy if it is full.
+func (q *messageQueue) push(ctx context.Context, ws *Websocket, msg queuedMessage) error {
+	// timeout fires at msg.blockUntil, from a timer started the first time push blocks
+	var timeout <-chan time.Time
+	for {
+		q.mu.Lock()
+		if q.closed {
+			q.mu.Unlock()
+			return ErrConnectionClosed
+		}
+
+		if len(q.messages) == q.size {
+			// expired messages are dropped anyway, make room with them first
+			q.dropExpired(time.Now())
+		}
+
+		if len(q.messages) < q.size {
+			q.messages = append(q.messages, msg)
+			room := len(q.messages) < q.size
+			q.mu.Unlock()
+			signal(q.ready)
+			if room {
+				// pass the wakeup on to other blocked pushers
+				signal(q.space)
+			}
+
+			return nil
+		}
+
+		switch q.policy {
+		case OverflowDropOldest:
+			q.messages[0] = queuedMessage{}
+			q.messages = append(q.messages[1:], msg)
+			q.mu.Unlock()
+			return nil
+		case OverflowDropNewest:
+			q.mu.Unlock()
+			return QueueFull
+		case OverflowClose:
+			q.mu.Unlock()
+			// the Close frame waits for the writes ahead of it, which the publisher must not
+			go ws.fail(TryAgainLater, QueueFull)
+			return QueueFull
+		}
+
+		q.mu.Unlock()
+		if timeout == nil && !msg.blockUntil.IsZero() {
+			wait := time.Until(msg.blockUntil)
+			if wait <= 0 {
+				return SlowClient
+			}
+
+			timer := time.NewTimer(wait)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+
+		select {
+		case <-q.space:
+		case <-timeout:
+			return SlowClient
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ws.done:
+			return ErrConnectionClosed
+		}
+	}
+}
+
+// pop takes the oldest message that has not expired off the queue, waiting for one
+// to be pushed. It reports false once the queue is closed and drained, or done is closed.
+func (q *messageQueue) pop(done <-chan struct{}) (queuedMessage, bool) {
+	for {
+		q.mu.Lock()
+		q.writing = false
+		now := time.Now()
+		for len(q.messages) > 0 {
+			msg := q.messages[0]
+			q.messages[0] = queuedMessage{}
+			q.messages = q.messages[1:]
+			if msg.expired(now) {
+				continue
+			}
+
+			q.writing = true
+			q.mu.Unlock()
+			signal(q.space)
+			return msg, true
+		}
+
+		closed := q.closed
+		q.mu.Unlock()
+		if closed {
+			return queuedMessage{}, false
+		}
+
+		select {
+		case <-q.ready:
+		case <-done:
+			return queuedMessage{}, false
+		}
+	}
+}
+
+// dropExpired removes the messages that expired at now. q.mu must be held.
+func (q *messageQueue) dropExpired(now time.Time) {
+	kept := q.messages[:0]
+	for _, msg := range q.messages {
+		if !msg.expired(now) {
+			kept = append(kept, msg)
+		}
+	}
+
+	clear(q.messages[len(kept):])
+	q.messages = kept
+}
+
+// close makes pop report false once the queued messages are taken.
+func (q *messageQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	signal(q.ready)
+}
+
+// len gives the number of queued messages.
+func (q *messageQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.messages)
+}
+
+// idle reports whether every message pushed was written.
+func (q *messageQueue) idle() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.messages) == 0 && !q.writing
+}
+
+// signal wakes up a goroutine waiting on c, if it is not already due to wake up.
+func signal(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
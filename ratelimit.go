@@ -0,0 +1,93 @@
+package websocket
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket refilled at rate tokens per second, holding at most burst tokens.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter creates a limiter that starts with a full bucket.
+// A burst smaller than one is raised to one, so the limiter can always make progress.
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &rateLimiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// allow takes n tokens from the bucket if they are available, and reports whether it did.
+func (l *rateLimiter) allow(n float64) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(time.Now())
+	if l.tokens < n {
+		return false
+	}
+
+	l.tokens -= n
+	return true
+}
+
+// wait takes n tokens from the bucket, waiting until they have accumulated or ctx is done,
+// in which case they are given back. n may exceed the burst: the bucket then goes into
+// debt, which delays the next callers.
+func (l *rateLimiter) wait(ctx context.Context, n float64) error {
+	l.mu.Lock()
+	l.refill(time.Now())
+	l.tokens -= n
+	debt := -l.tokens
+	l.mu.Unlock()
+	if debt <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(time.Duration(debt / l.rate * float64(time.Second)))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.give(n)
+		return ctx.Err()
+	}
+}
+
+// refill adds the tokens accumulated since the last refill.
+func (l *rateLimiter) refill(now time.Time) {
+	elapsed := now.Sub(l.last).Seconds()
+	l.last = now
+	l.tokens += elapsed * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+}
+
+// give returns n tokens to the bucket, up to the burst.
+func (l *rateLimiter) give(n float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens = min(l.tokens+n, l.burst)
+}
+
+// full reports whether the bucket has refilled completely by now.
+func (l *rateLimiter) full(now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(now)
+	return l.tokens >= l.burst
+}
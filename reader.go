@@ -0,0 +1,334 @@
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"slices"
+)
+
+// SetReadLimit sets the maximum size in bytes of a message read from the client,
+// counting all of its fragments. When a message exceeds it, reading stops, the
+// connection is sent a Close frame with MessageTooBig and the read fails with
+// ReadLimitExceeded. Zero or less removes the limit.
+func (ws *Websocket) SetReadLimit(limit int64) {
+	ws.readLimit = limit
+}
+
+// NextReader waits for the next message from the client and returns its type
+// together with a reader spanning all of its fragments. The payload is read
+// from the connection as the reader is consumed, so the message is never
+// buffered in full.
+//
+// The reader is only valid until the next call to NextReader; unread bytes
+// of the previous message are discarded.
+//
+// The deadline and cancellation of ctx apply to waiting for the message, not to
+// reading it afterwards.
+func (ws *Websocket) NextReader(ctx context.Context) (t MessageType, r io.Reader, err error) {
+	if ws.State() == StateClosed {
+		return "", nil, ErrConnectionClosed
+	}
+
+	defer ws.checkError(&err)
+	defer ws.readDeadline.bind(ctx)(&err)
+	return ws.nextReader()
+}
+
+// nextReader implements NextReader, without binding a context.
+func (ws *Websocket) nextReader() (MessageType, io.Reader, error) {
+	if ws.messageReader != nil {
+		// drain whatever the caller left unread so the stream is positioned on a frame boundary
+		_, err := io.Copy(io.Discard, ws.messageReader)
+		putFrame(ws.messageReader.frame)
+		ws.messageReader = nil
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	frame, err := ws.nextFrameHeader()
+	if err != nil {
+		return "", nil, err
+	}
+
+	t, err := messageType(frame.Opcode)
+	if err != nil {
+		return "", nil, err
+	}
+
+	err = ws.checkReadLimit(frame.PayloadLength())
+	if err != nil {
+		return "", nil, err
+	}
+
+	ws.messageReader = &messageReader{
+		ws:        ws,
+		t:         t,
+		frame:     frame,
+		remaining: frame.PayloadLength(),
+		size:      frame.PayloadLength(),
+	}
+
+	return t, ws.messageReader, nil
+}
+
+// ReceiveInto waits for the next message from the client and copies its fragments
+// into w as they arrive, so messages larger than memory can be received.
+// It returns the number of bytes written and the type of the message.
+func (ws *Websocket) ReceiveInto(ctx context.Context, w io.Writer) (n int64, t MessageType, err error) {
+	if ws.State() == StateClosed {
+		return 0, "", ErrConnectionClosed
+	}
+
+	defer ws.checkError(&err)
+	defer ws.readDeadline.bind(ctx)(&err)
+	t, r, err := ws.nextReader()
+	if err != nil {
+		return 0, "", err
+	}
+
+	n, err = io.Copy(w, r)
+	return n, t, err
+}
+
+// MessageBuffer is a message received by ReceiveBuffer, held in a pooled buffer.
+type MessageBuffer struct {
+	// Type is the type of the message.
+	Type MessageType
+
+	buf *bytes.Buffer
+}
+
+// Bytes gives the payload of the message. It is only valid until Release is called.
+func (mb *MessageBuffer) Bytes() []byte {
+	if mb == nil || mb.buf == nil {
+		return nil
+	}
+
+	return mb.buf.Bytes()
+}
+
+// Release returns the buffer of the message to the pool, for a later message to reuse.
+// It is safe to call more than once, and on a nil MessageBuffer.
+func (mb *MessageBuffer) Release() {
+	if mb == nil || mb.buf == nil {
+		return
+	}
+
+	assemblyPool.put(mb.buf)
+	mb.buf = nil
+}
+
+// ReceiveBuffer waits for the next message from the client and reads it in full into a
+// pooled buffer, sparing the allocation Receive makes for every message. The caller calls
+// Release once done with the payload, which must not be used afterwards; a buffer that is
+// never released is only left to the garbage collector.
+func (ws *Websocket) ReceiveBuffer(ctx context.Context) (mb *MessageBuffer, err error) {
+	if ws.State() == StateClosed {
+		return nil, ErrConnectionClosed
+	}
+
+	defer ws.checkError(&err)
+	defer ws.readDeadline.bind(ctx)(&err)
+	t, _, err := ws.nextReader()
+	if err != nil {
+		return nil, err
+	}
+
+	buf := assemblyPool.get()
+	data, err := ws.messageReader.readAll(buf.AvailableBuffer())
+	if err != nil {
+		assemblyPool.put(buf)
+		return nil, err
+	}
+
+	// data is still in the buffer unless it outgrew it
+	buf.Write(data)
+	return &MessageBuffer{Type: t, buf: buf}, nil
+}
+
+// receiveMessage waits for the next message from the client and reads it in full.
+func (ws *Websocket) receiveMessage(ctx context.Context) (t MessageType, data []byte, err error) {
+	if ws.State() == StateClosed {
+		return "", nil, ErrConnectionClosed
+	}
+
+	defer ws.checkError(&err)
+	defer ws.readDeadline.bind(ctx)(&err)
+	t, _, err = ws.nextReader()
+	if err != nil {
+		return "", nil, err
+	}
+
+	data, err = ws.messageReader.readAll(nil)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return t, data, nil
+}
+
+// nextFrameHeader reads frame headers until it finds a data frame, consuming the
+// control frames that may be interleaved with the fragments of a message.
+func (ws *Websocket) nextFrameHeader() (*Frame, error) {
+	for {
+		frame, err := ws.readFrameHeader()
+		if err != nil {
+			return nil, err
+		}
+
+		switch frame.Opcode {
+		case NonControlFrame, ControlFrame:
+			// reserved opcodes, no extension defines their meaning
+			return nil, InvalidOpcode
+		}
+
+		if frame.RSV1 || frame.RSV2 || frame.RSV3 {
+			ws.fail(ProtocolError, ReservedBitsSet)
+			return nil, ReservedBitsSet
+		}
+
+		if !frame.isControl() {
+			return frame, nil
+		}
+
+		err = ws.readControlPayload(frame)
+		if err != nil {
+			return nil, err
+		}
+
+		err = ws.handleControl(frame)
+		putFrame(frame)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// readControlPayload reads and unmasks the payload of a control frame.
+// Control frames must not be fragmented and carry at most 125 bytes of payload.
+func (ws *Websocket) readControlPayload(frame *Frame) error {
+	if !frame.FIN || frame.PayloadLength() > 125 {
+		return InvalidLength
+	}
+
+	payload := make([]byte, frame.PayloadLength())
+	_, err := io.ReadFull(ws.reader, payload)
+	if err != nil {
+		return err
+	}
+
+	if frame.Mask {
+		MaskBytes([4]byte(frame.MaskingKey), 0, payload)
+	}
+
+	frame.ApplicationData = payload
+	return nil
+}
+
+// messageReader reads the payload of a message across all of its fragments.
+type messageReader struct {
+	ws *Websocket
+
+	// t is the type of the message.
+	t MessageType
+
+	// frame is the header of the fragment currently being read.
+	frame *Frame
+
+	// remaining is the number of payload bytes left in the current fragment.
+	remaining uint64
+
+	// pos is the position in the masking key for the next payload byte.
+	pos int
+
+	// size is the sum of the payload lengths of the fragments read so far.
+	size uint64
+
+	err error
+}
+
+// readAll reads the rest of the message and appends it to buf, growing buf by the declared
+// payload length of every fragment rather than by doubling, so a message is read with at
+// most one allocation per fragment, unless fragments are large enough to be read in chunks.
+func (mr *messageReader) readAll(buf []byte) ([]byte, error) {
+	for {
+		// with no room left, Read reads the header of the next fragment
+		buf = slices.Grow(buf, payloadGrowth(len(buf), mr.remaining))
+		n, err := mr.Read(buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+		if err == io.EOF {
+			return buf, nil
+		}
+
+		if err != nil {
+			return buf, err
+		}
+	}
+}
+
+func (mr *messageReader) Read(p []byte) (int, error) {
+	for mr.remaining == 0 {
+		if mr.err != nil {
+			return 0, mr.err
+		}
+
+		if mr.frame.FIN {
+			mr.ws.messageReceived(mr.t, int(mr.size))
+			mr.err = io.EOF
+			return 0, mr.err
+		}
+
+		frame, err := mr.ws.nextFrameHeader()
+		if err != nil {
+			mr.err = err
+			return 0, err
+		}
+
+		if frame.Opcode != ContinuationFrame {
+			mr.err = InvalidOpcode
+			return 0, mr.err
+		}
+
+		mr.size += frame.PayloadLength()
+		err = mr.ws.checkReadLimit(mr.size)
+		if err != nil {
+			mr.err = err
+			return 0, err
+		}
+
+		putFrame(mr.frame)
+		mr.frame = frame
+		mr.remaining = frame.PayloadLength()
+		mr.pos = 0
+	}
+
+	if mr.err != nil {
+		return 0, mr.err
+	}
+
+	if uint64(len(p)) > mr.remaining {
+		p = p[:mr.remaining]
+	}
+
+	n, err := mr.ws.reader.Read(p)
+	if n > 0 {
+		mr.ws.touch()
+	}
+
+	if mr.frame.Mask {
+		mr.pos = MaskBytes([4]byte(mr.frame.MaskingKey), mr.pos, p[:n])
+	}
+
+	mr.remaining -= uint64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+
+	if err != nil {
+		mr.err = err
+	}
+
+	return n, err
+}
@@ -0,0 +1,260 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// Envelope is the message format understood by Router: the JSON object
+// {"type": "...", "data": ...}, whose type selects the handler of the data.
+//
+// An envelope sent with SendEventAck also carries an ID and "ack": true, and is answered
+// by an envelope of type AckEvent carrying the same ID once it has been handled.
+// A call made with Call carries an ID and "call": true, and is answered by an envelope
+// of type ResponseEvent carrying the same ID and either the result or an error.
+// An envelope sent on a Session carries its sequence number in the session.
+type Envelope struct {
+	Type  string          `json:"type"`
+	ID    string          `json:"id,omitempty"`
+	Ack   bool            `json:"ack,omitempty"`
+	Call  bool            `json:"call,omitempty"`
+	Seq   uint64          `json:"seq,omitempty"`
+	Data  json.RawMessage `json:"data,omitempty"`
+	Error string          `json:"error,omitempty"`
+}
+
+// Event is a decoded envelope being dispatched, together with the connection it arrived on.
+type Event struct {
+	// Type is the name of the event.
+	Type string
+
+	// Data is the raw JSON payload of the event, if any.
+	Data json.RawMessage
+
+	// Conn is the connection the event arrived on.
+	Conn *Websocket
+
+	// ID identifies the envelope of the event, if the sender set one.
+	ID string
+
+	// ack is set when the sender awaits an acknowledgment, until it is sent.
+	ack atomic.Bool
+
+	// call is set when the event is a call awaiting a response, until it is sent.
+	call atomic.Bool
+}
+
+// Decode decodes the data of the event into v with the JSON options of the connection.
+func (e *Event) Decode(v any) error {
+	return JSONCodec{Options: e.Conn.jsonOptions}.Unmarshal(e.Data, v)
+}
+
+// Reply sends an event of type t carrying v back on the connection the event arrived on.
+func (e *Event) Reply(ctx context.Context, t string, v any) error {
+	return e.Conn.SendEvent(ctx, t, v)
+}
+
+// Ack acknowledges the event to its sender, if the sender requested it and it has not
+// been acknowledged yet. Routers acknowledge events once their handler succeeded,
+// unless ManualAck is set.
+func (e *Event) Ack(ctx context.Context) error {
+	if !e.ack.Swap(false) {
+		return nil
+	}
+
+	return e.Conn.WriteJSON(ctx, Envelope{Type: AckEvent, ID: e.ID})
+}
+
+// Respond sends v as the result of the call the event is, if it has not been answered yet.
+// Routers answer calls whose handler did not respond, with an empty result on success and
+// with the error of the handler on failure.
+func (e *Event) Respond(ctx context.Context, v any) error {
+	if !e.call.Swap(false) {
+		return nil
+	}
+
+	data, _, err := JSONCodec{Options: e.Conn.jsonOptions}.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return e.Conn.WriteJSON(ctx, Envelope{Type: ResponseEvent, ID: e.ID, Data: data})
+}
+
+// fail answers the call the event is with the error, if it has not been answered yet.
+func (e *Event) fail(ctx context.Context, err error) error {
+	if !e.call.Swap(false) {
+		return nil
+	}
+
+	return e.Conn.WriteJSON(ctx, Envelope{Type: ResponseEvent, ID: e.ID, Error: err.Error()})
+}
+
+// EventHandler handles an event dispatched by a Router.
+type EventHandler func(ctx context.Context, e *Event) error
+
+// Middleware wraps an EventHandler, to run code around every event of a Router.
+type Middleware func(next EventHandler) EventHandler
+
+// Router dispatches envelopes to the handlers registered for their type.
+// Handlers may be registered concurrently with dispatching.
+type Router struct {
+	mu         sync.RWMutex
+	handlers   map[string]EventHandler
+	middleware []Middleware
+
+	// NotFound handles events no handler is registered for.
+	// When nil, such events fail with UnknownEvent.
+	NotFound EventHandler
+
+	// ManualAck leaves acknowledging events to their handlers, with Event.Ack.
+	// By default an event is acknowledged once its handler returned without error.
+	ManualAck bool
+
+	// Dedup, if set, filters the events awaiting an acknowledgment that were handled
+	// already, such as events redelivered by Deliver whose acknowledgment was lost: they
+	// are acknowledged again without being handled. An event counts as handled once its
+	// handler returned without error.
+	Dedup *Deduplicator
+}
+
+// NewRouter returns a Router without handlers.
+func NewRouter() *Router {
+	return &Router{
+		handlers: map[string]EventHandler{},
+	}
+}
+
+// Handle registers h for events of type t, replacing any previous handler.
+func (rt *Router) Handle(t string, h EventHandler) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	if rt.handlers == nil {
+		rt.handlers = map[string]EventHandler{}
+	}
+
+	rt.handlers[t] = h
+}
+
+// Use appends middleware that run around every handler, the first one outermost.
+func (rt *Router) Use(mw ...Middleware) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	rt.middleware = append(rt.middleware, mw...)
+}
+
+// Dispatch decodes message as an envelope and runs the handler registered for its type,
+// wrapped in the middleware of the router. Acknowledgments and responses are handed to
+// the pending SendEventAck or Call instead. The error of the handler of a call is sent
+// to the caller rather than returned.
+func (rt *Router) Dispatch(ctx context.Context, ws *Websocket, message []byte) error {
+	envelope := Envelope{}
+	err := json.Unmarshal(message, &envelope)
+	if err != nil {
+		return fmt.Errorf("%w: %v", InvalidEnvelope, err)
+	}
+
+	if envelope.Type == "" {
+		return fmt.Errorf("%w: missing type", InvalidEnvelope)
+	}
+
+	if envelope.Type == AckEvent || envelope.Type == ResponseEvent {
+		ws.replies.resolve(envelope)
+		return nil
+	}
+
+	rt.mu.RLock()
+	h, ok := rt.handlers[envelope.Type]
+	if !ok {
+		h = rt.notFound
+	}
+
+	for i := len(rt.middleware) - 1; i >= 0; i-- {
+		h = rt.middleware[i](h)
+	}
+	rt.mu.RUnlock()
+
+	e := &Event{
+		Type: envelope.Type,
+		Data: envelope.Data,
+		Conn: ws,
+		ID:   envelope.ID,
+	}
+
+	e.ack.Store(envelope.Ack && envelope.ID != "")
+	e.call.Store(envelope.Call && envelope.ID != "")
+	if rt.Dedup != nil && e.ack.Load() {
+		key := rt.Dedup.key(e)
+		switch rt.Dedup.begin(key) {
+		case eventHandled:
+			return e.Ack(ctx)
+		case eventHandling:
+			// acknowledged by the delivery being handled
+			return nil
+		}
+
+		next := h
+		h = func(ctx context.Context, e *Event) (err error) {
+			defer func() {
+				rt.Dedup.end(key, err == nil)
+			}()
+
+			return next(ctx, e)
+		}
+	}
+
+	err = h(ctx, e)
+	if err != nil {
+		if e.call.Load() {
+			return e.fail(ctx, err)
+		}
+
+		return err
+	}
+
+	err = e.Respond(ctx, nil)
+	if err != nil || rt.ManualAck {
+		return err
+	}
+
+	return e.Ack(ctx)
+}
+
+// Serve receives messages from ws and dispatches them until receiving or a handler fails,
+// returning that error.
+func (rt *Router) Serve(ctx context.Context, ws *Websocket) error {
+	for {
+		message, err := ws.Receive(ctx)
+		if err != nil {
+			return err
+		}
+
+		err = rt.Dispatch(ctx, ws, message)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (rt *Router) notFound(ctx context.Context, e *Event) error {
+	if rt.NotFound != nil {
+		return rt.NotFound(ctx, e)
+	}
+
+	return fmt.Errorf("%w: %q", UnknownEvent, e.Type)
+}
+
+// SendEvent sends an envelope of type t carrying the JSON encoding of v as a text message.
+func (ws *Websocket) SendEvent(ctx context.Context, t string, v any) error {
+	data, _, err := JSONCodec{Options: ws.jsonOptions}.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return ws.WriteJSON(ctx, Envelope{Type: t, Data: data})
+}
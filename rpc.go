@@ -0,0 +1,52 @@
+package websocket
+
+import (
+	"context"
+)
+
+// ResponseEvent is the type of the envelopes answering a call made with Call.
+const ResponseEvent = "response"
+
+// CallError is returned by Call when the handler of the call failed on the peer.
+type CallError struct {
+	// Method is the method that was called.
+	Method string
+
+	// Message is the error reported by the peer.
+	Message string
+}
+
+func (e *CallError) Error() string {
+	return "call " + e.Method + ": " + e.Message
+}
+
+// Call calls method on the peer with the JSON encoding of params, waits for the response
+// and decodes its result into result, unless result is nil. Calls work in both directions:
+// the peer dispatches them with a Router, to the handler registered for method, and the
+// response is received by the Router dispatching the messages of this connection, so one
+// must be running concurrently.
+//
+// Call returns ctx.Err() if ctx is done before the response arrives, ErrConnectionClosed
+// if the connection closes first and a *CallError if the handler failed.
+func (ws *Websocket) Call(ctx context.Context, method string, params any, result any) error {
+	codec := JSONCodec{Options: ws.jsonOptions}
+	data, _, err := codec.Marshal(params)
+	if err != nil {
+		return err
+	}
+
+	response, err := ws.roundTrip(ctx, Envelope{Type: method, Call: true, Data: data})
+	if err != nil {
+		return err
+	}
+
+	if response.Error != "" {
+		return &CallError{Method: method, Message: response.Error}
+	}
+
+	if result == nil || len(response.Data) == 0 || string(response.Data) == "null" {
+		return nil
+	}
+
+	return codec.Unmarshal(response.Data, result)
+}
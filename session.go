@@ -0,0 +1,277 @@
+package websocket
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// SessionEvent is the type of the envelope that starts every session connection, telling
+// the client its session token and whether the session was resumed without losing messages.
+const SessionEvent = "session"
+
+const (
+	// defaultSessionBuffer is the number of messages kept for replay when SessionStore.BufferSize is not set.
+	defaultSessionBuffer = 256
+
+	// defaultSessionTTL is how long sessions outlive their connection when SessionStore.TTL is not set.
+	defaultSessionTTL = time.Minute
+)
+
+// SessionInfo is the data of the SessionEvent envelope.
+type SessionInfo struct {
+	// Token identifies the session; the client presents it when reconnecting.
+	Token string `json:"token"`
+
+	// Resumed is true when the session was resumed and every message sent after the
+	// sequence number presented by the client was replayed. It is false for new
+	// sessions, and when messages were lost, so the client knows to resynchronize.
+	Resumed bool `json:"resumed"`
+}
+
+// SessionStore keeps sessions across reconnections of their client. Messages sent on a
+// session carry a sequence number and are buffered, so that a client reconnecting with
+// its session token and the last sequence number it received gets the messages it missed.
+type SessionStore struct {
+	// BufferSize is the number of messages of a session kept for replay. It defaults to 256.
+	BufferSize int
+
+	// TTL is how long a session is kept once its connection closed, waiting for the client
+	// to reconnect. It defaults to one minute.
+	TTL time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]*Session
+}
+
+// NewSessionStore returns a SessionStore without sessions.
+func NewSessionStore() *SessionStore {
+	return &SessionStore{
+		sessions: map[string]*Session{},
+	}
+}
+
+// ResumeParams reads the session token and last received sequence number a client
+// presents in the query of its upgrade request, as ?session=token&seq=n. Both are
+// empty for clients connecting for the first time.
+func ResumeParams(r *http.Request) (token string, lastSeq uint64) {
+	query := r.URL.Query()
+	lastSeq, _ = strconv.ParseUint(query.Get("seq"), 10, 64)
+	return query.Get("session"), lastSeq
+}
+
+// Resume attaches ws to the session identified by token, replaying the messages sent
+// after lastSeq, or to a new session if the token is empty or unknown. It first sends
+// the SessionEvent envelope. A connection still attached to the session is closed.
+func (st *SessionStore) Resume(ctx context.Context, ws *Websocket, token string, lastSeq uint64) (*Session, error) {
+	st.mu.Lock()
+	if st.sessions == nil {
+		st.sessions = map[string]*Session{}
+	}
+
+	s, ok := st.sessions[token]
+	if !ok {
+		s = &Session{store: st, token: sessionToken()}
+		st.sessions[s.token] = s
+	}
+	st.mu.Unlock()
+
+	return s, s.attach(ctx, ws, ok, lastSeq)
+}
+
+// Len gives the number of sessions kept.
+func (st *SessionStore) Len() int {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return len(st.sessions)
+}
+
+// expire forgets the session, unless it was resumed meanwhile.
+func (st *SessionStore) expire(s *Session) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ws == nil {
+		delete(st.sessions, s.token)
+	}
+}
+
+// Session is a sequence of messages to a client that survives its reconnections.
+type Session struct {
+	store *SessionStore
+	token string
+
+	// mu is held while sending, so messages are sent in the order of their sequence numbers.
+	mu     sync.Mutex
+	ws     *Websocket
+	seq    uint64
+	buffer []sequencedMessage
+	stop   func() bool
+	expiry *time.Timer
+}
+
+// sequencedMessage is a message kept for replay.
+type sequencedMessage struct {
+	seq  uint64
+	data []byte
+}
+
+// Token gives the token identifying the session.
+func (s *Session) Token() string {
+	return s.token
+}
+
+// Websocket gives the connection currently attached to the session, nil if there is none.
+func (s *Session) Websocket() *Websocket {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ws
+}
+
+// SendEvent sends an envelope of type t carrying the JSON encoding of v, numbered with the
+// next sequence number of the session. The message is kept for replay, and is only sent
+// once the client reconnects if no connection is attached or the connection fails.
+func (s *Session) SendEvent(ctx context.Context, t string, v any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var options JSONOptions
+	if s.ws != nil {
+		options = s.ws.jsonOptions
+	}
+
+	data, _, err := JSONCodec{Options: options}.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	s.seq++
+	message, err := json.Marshal(Envelope{Type: t, Seq: s.seq, Data: data})
+	if err != nil {
+		return err
+	}
+
+	s.buffer = append(s.buffer, sequencedMessage{seq: s.seq, data: message})
+	if size := s.bufferSize(); len(s.buffer) > size {
+		s.buffer = s.buffer[len(s.buffer)-size:]
+	}
+
+	if s.ws == nil {
+		return nil
+	}
+
+	err = s.ws.SendText(ctx, message)
+	if isCleanClose(err) || isConnectionFailure(err) {
+		// kept for the next connection of the client
+		return nil
+	}
+
+	return err
+}
+
+// Ack drops the messages up to seq from the replay buffer, once the client confirmed it
+// received them.
+func (s *Session) Ack(seq uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := 0
+	for i < len(s.buffer) && s.buffer[i].seq <= seq {
+		i++
+	}
+
+	s.buffer = s.buffer[i:]
+}
+
+// attach makes ws the connection of the session and replays the messages after lastSeq.
+func (s *Session) attach(ctx context.Context, ws *Websocket, resumed bool, lastSeq uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.expiry != nil {
+		s.expiry.Stop()
+		s.expiry = nil
+	}
+
+	if s.ws != nil {
+		s.stop()
+		go s.ws.CloseWithCode(PolicyViolation, "session resumed on another connection")
+	}
+
+	s.ws = ws
+	s.stop = context.AfterFunc(ws.Context(), func() {
+		s.detach(ws)
+	})
+
+	replay := s.buffer
+	if resumed {
+		i := 0
+		for i < len(replay) && replay[i].seq <= lastSeq {
+			i++
+		}
+
+		// nothing the client missed is lost if the message after lastSeq is still buffered
+		replay = replay[i:]
+		resumed = lastSeq <= s.seq && (lastSeq == s.seq || (len(replay) > 0 && replay[0].seq == lastSeq+1))
+		ws.sessionResumed(resumed)
+	}
+
+	data, _, err := JSONCodec{Options: ws.jsonOptions}.Marshal(SessionInfo{Token: s.token, Resumed: resumed})
+	if err != nil {
+		return err
+	}
+
+	err = ws.WriteJSON(ctx, Envelope{Type: SessionEvent, Data: data})
+	if err != nil {
+		return err
+	}
+
+	// a session resumed with a gap still replays what is left
+	for _, m := range replay {
+		err = ws.SendText(ctx, m.data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// detach removes the closed connection from the session, which expires after the TTL
+// of the store unless the client reconnects.
+func (s *Session) detach(ws *Websocket) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ws != ws {
+		return
+	}
+
+	s.ws = nil
+	ttl := s.store.TTL
+	if ttl <= 0 {
+		ttl = defaultSessionTTL
+	}
+
+	s.expiry = time.AfterFunc(ttl, func() {
+		s.store.expire(s)
+	})
+}
+
+func (s *Session) bufferSize() int {
+	if s.store.BufferSize > 0 {
+		return s.store.BufferSize
+	}
+
+	return defaultSessionBuffer
+}
+
+// sessionToken generates a random 128-bit session token, hex encoded.
+func sessionToken() string {
+	b := make([]byte, 16)
+	// crypto/rand only fails when the system has no entropy source at all
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
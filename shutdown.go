@@ -0,0 +1,145 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// drainInterval is how often Hub.Shutdown checks whether the send queues of the members
+// were written out.
+const drainInterval = 10 * time.Millisecond
+
+// ShutdownStage is a stage of Hub.Shutdown, which goes through them in order.
+type ShutdownStage string
+
+const (
+	// ShutdownStopIntake stops the hub from taking new members and broadcasts, and waits
+	// for the broadcasts being delivered.
+	ShutdownStopIntake ShutdownStage = "stop-intake"
+
+	// ShutdownFlush waits for the send queues of the members to be written out.
+	ShutdownFlush ShutdownStage = "flush"
+
+	// ShutdownClose closes the members with GoingAway and waits for the clients to
+	// answer the closing handshake.
+	ShutdownClose ShutdownStage = "close"
+
+	// ShutdownRelease stops the shard workers, drops the connections still open and
+	// empties the hub.
+	ShutdownRelease ShutdownStage = "release"
+)
+
+// OnShutdown registers a hook called by Shutdown at the start of each stage, with the
+// context of Shutdown, for example to stop producers before the intake stops or to
+// release application resources once the hub is empty. Errors are returned by Shutdown,
+// which goes through the stages regardless. Hooks run in the order they were registered.
+func (h *Hub) OnShutdown(f func(ctx context.Context, stage ShutdownStage) error) {
+	h.hooksMu.Lock()
+	defer h.hooksMu.Unlock()
+	h.shutdownHooks = append(h.shutdownHooks, f)
+}
+
+// Shutdown tears the hub down in a fixed order, so applications can rely on it during
+// process exit:
+//
+//  1. it stops the intake: Register, Join and broadcasts fail with HubClosed, and the
+//     broadcasts already started are delivered;
+//  2. it waits for the members to write out their send queues;
+//  3. it closes the members with GoingAway and waits for their closing handshakes;
+//  4. it releases the hub, as Close does, dropping the connections still open.
+//
+// The second and third stages wait until ctx is done, after which the remaining stages
+// run without waiting and ctx.Err() is returned. Shutdown only covers the members of the
+// hub; the ConnectionManager of the server is shut down apart, after the hub.
+func (h *Hub) Shutdown(ctx context.Context) error {
+	h.hooksMu.RLock()
+	hooks := h.shutdownHooks
+	h.hooksMu.RUnlock()
+
+	var errs []error
+	stage := func(s ShutdownStage) {
+		for _, hook := range hooks {
+			err := hook(ctx, s)
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	stage(ShutdownStopIntake)
+	h.mu.Lock()
+	h.closed = true
+	members := make([]*Websocket, 0, len(h.members))
+	for ws := range h.members {
+		members = append(members, ws)
+	}
+	h.mu.Unlock()
+
+	delivered := make(chan struct{})
+	go func() {
+		h.delivering.Wait()
+		close(delivered)
+	}()
+
+	select {
+	case <-delivered:
+	case <-ctx.Done():
+	}
+
+	stage(ShutdownFlush)
+	for _, ws := range members {
+		if ctx.Err() != nil {
+			break
+		}
+
+		err := ws.drain(ctx)
+		if err != nil && ctx.Err() == nil {
+			errs = append(errs, err)
+		}
+	}
+
+	stage(ShutdownClose)
+	for _, ws := range members {
+		go ws.startClose(GoingAway, "")
+	}
+
+	for _, ws := range members {
+		select {
+		case <-ws.done:
+		case <-ctx.Done():
+		}
+	}
+
+	stage(ShutdownRelease)
+	errs = append(errs, h.Close(), ctx.Err())
+	return errors.Join(errs...)
+}
+
+// drain waits until every message enqueued to the connection was written, then flushes
+// the frames left in the write buffer by write coalescing, within ctx. A connection that
+// closes has nothing left to write.
+func (ws *Websocket) drain(ctx context.Context) error {
+	ws.pumps.mu.Lock()
+	q := ws.pumps.queue
+	ws.pumps.mu.Unlock()
+
+	ticker := time.NewTicker(drainInterval)
+	defer ticker.Stop()
+	for q != nil && !q.idle() {
+		select {
+		case <-ticker.C:
+		case <-ws.done:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	err := ws.Flush(ctx)
+	if errors.Is(err, ErrConnectionClosed) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,135 @@
+package websocket
+
+import (
+	"time"
+)
+
+// SendStats describe how well a connection keeps up with the messages sent to it.
+type SendStats struct {
+	// QueueDepth is the number of messages waiting in the send queue.
+	QueueDepth int
+
+	// WriteLatency is how long the last message took to write, or how long the message
+	// being written has been taking if that is longer.
+	WriteLatency time.Duration
+}
+
+// SendStats gives the current send statistics of the connection.
+func (ws *Websocket) SendStats() SendStats {
+	stats := SendStats{
+		WriteLatency: time.Duration(ws.writeLatency.Load()),
+	}
+
+	started := ws.writeStarted.Load()
+	if started != 0 {
+		stats.WriteLatency = max(stats.WriteLatency, time.Since(time.Unix(0, started)))
+	}
+
+	ws.pumps.mu.Lock()
+	q := ws.pumps.queue
+	ws.pumps.mu.Unlock()
+	if q != nil {
+		stats.QueueDepth = q.len()
+	}
+
+	return stats
+}
+
+// timeWrite records that a message starts to be written, and returns the function
+// recording that it was written.
+func (ws *Websocket) timeWrite() func() {
+	ws.writeStarted.Store(time.Now().UnixNano())
+	return func() {
+		// throttle moves the start forward by the time the message waited for the send rate
+		ws.writeLatency.Store(time.Now().UnixNano() - ws.writeStarted.Load())
+		ws.writeStarted.Store(0)
+	}
+}
+
+// slowClientPolicy is the configuration of evictSlowClient.
+type slowClientPolicy struct {
+	timeout         time.Duration
+	maxQueueDepth   int
+	maxWriteLatency time.Duration
+	onEvict         func(ws *Websocket, stats SendStats)
+}
+
+func (wso *WSOpener) slowClientPolicy() slowClientPolicy {
+	depth := wso.MaxQueueDepth
+	if depth <= 0 {
+		depth = wso.QueueSize
+	}
+
+	if depth <= 0 {
+		depth = defaultQueueSize
+	}
+
+	return slowClientPolicy{
+		timeout:         wso.SlowClientTimeout,
+		maxQueueDepth:   depth,
+		maxWriteLatency: wso.MaxWriteLatency,
+		onEvict:         wso.OnEvict,
+	}
+}
+
+// behind reports whether a connection with these statistics is not keeping up. The
+// write latency only counts while a message is being written or waits in the queue: an
+// idle connection is not behind, however slow its last write was.
+func (p slowClientPolicy) behind(stats SendStats, writing bool) bool {
+	if stats.QueueDepth >= p.maxQueueDepth {
+		return true
+	}
+
+	if !writing && stats.QueueDepth == 0 {
+		return false
+	}
+
+	return p.maxWriteLatency > 0 && stats.WriteLatency > p.maxWriteLatency
+}
+
+// evictSlowClient closes the connection with TryAgainLater once it has been behind for
+// the timeout of the policy. The Close frame is queued behind the writes that are
+// late already, so the connection is dropped if it is not sent within the timeout.
+func (ws *Websocket) evictSlowClient(p slowClientPolicy) {
+	ws.labelGoroutine()
+	ticker := time.NewTicker(max(p.timeout/4, time.Millisecond))
+	defer ticker.Stop()
+
+	var behindSince time.Time
+	for {
+		select {
+		case <-ws.done:
+			return
+		case <-ticker.C:
+		}
+
+		stats := ws.SendStats()
+		if !p.behind(stats, ws.writeStarted.Load() != 0) {
+			behindSince = time.Time{}
+			continue
+		}
+
+		if behindSince.IsZero() {
+			behindSince = time.Now()
+		}
+
+		if time.Since(behindSince) < p.timeout {
+			continue
+		}
+
+		if p.onEvict != nil {
+			p.onEvict(ws, stats)
+		}
+
+		go ws.fail(TryAgainLater, SlowClient)
+		grace := time.NewTimer(p.timeout)
+		defer grace.Stop()
+		select {
+		case <-ws.done:
+		case <-grace.C:
+			_ = ws.terminate(TryAgainLater, SlowClient)
+		}
+
+		return
+	}
+}
@@ -0,0 +1,76 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// pipeWebsocket returns an open connection over one end of a pipe, and the other end,
+// which nothing reads unless the test does.
+func pipeWebsocket() (*Websocket, net.Conn) {
+	server, client := net.Pipe()
+	ws := &Websocket{
+		conn:         server,
+		reader:       bufio.NewReader(server),
+		writer:       bufio.NewWriter(server),
+		t:            TextWebsocket,
+		framingLimit: 1024,
+		done:         make(chan struct{}),
+	}
+
+	ws.ctx, ws.cancel = context.WithCancel(context.Background())
+	ws.readDeadline = newDeadline(server.SetReadDeadline)
+	ws.writeDeadline = newDeadline(server.SetWriteDeadline)
+	return ws, client
+}
+
+// TestEvictSlowSendFrom checks that a message streamed with SendFrom to a client that
+// stopped reading counts towards the write latency, and gets the client evicted.
+func TestEvictSlowSendFrom(t *testing.T) {
+	ws, client := pipeWebsocket()
+	defer client.Close()
+
+	evicted := make(chan SendStats, 1)
+	policy := slowClientPolicy{
+		timeout:         50 * time.Millisecond,
+		maxQueueDepth:   defaultQueueSize,
+		maxWriteLatency: 10 * time.Millisecond,
+		onEvict: func(ws *Websocket, stats SendStats) {
+			evicted <- stats
+		},
+	}
+
+	go ws.evictSlowClient(policy)
+	sent := make(chan error, 1)
+	go func() {
+		sent <- ws.SendFrom(context.Background(), TextMessage, bytes.NewReader(bytes.Repeat([]byte("a"), 10000)))
+	}()
+
+	select {
+	case stats := <-evicted:
+		if stats.WriteLatency < policy.maxWriteLatency {
+			t.Fatalf("evicted with a write latency of %v, want at least %v", stats.WriteLatency, policy.maxWriteLatency)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the client was not evicted")
+	}
+
+	select {
+	case err := <-sent:
+		if err == nil {
+			t.Fatal("SendFrom() to the evicted client succeeded")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("SendFrom() did not return once the client was evicted")
+	}
+
+	<-ws.done
+	if !errors.Is(ws.closeCause, SlowClient) || ws.closeCode != TryAgainLater {
+		t.Fatalf("closed with %v (%v), want %v (%v)", ws.closeCode, ws.closeCause, TryAgainLater, SlowClient)
+	}
+}
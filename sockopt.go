@@ -0,0 +1,108 @@
+package websocket
+
+import (
+	"net"
+	"time"
+)
+
+// SocketOptions are the TCP options set on a connection when it is opened. The zero
+// SocketOptions only makes sure TCP_NODELAY is set, leaving the other options as the
+// listener or dialer set them.
+type SocketOptions struct {
+	// DelayWrites clears TCP_NODELAY, which Go sets on TCP connections by default, so the
+	// kernel coalesces small writes with Nagle's algorithm at the cost of latency.
+	DelayWrites bool
+
+	// KeepAlive is the idle time after which the kernel starts probing the connection,
+	// KeepAliveInterval the time between probes and KeepAliveCount the number of probes
+	// left unanswered before the connection is dropped. Setting any of them enables
+	// kernel keepalives, the others keeping the system defaults. A negative KeepAlive
+	// disables them.
+	KeepAlive         time.Duration
+	KeepAliveInterval time.Duration
+	KeepAliveCount    int
+
+	// ReadBuffer and WriteBuffer, if set, are the sizes in bytes of the receive and send
+	// buffers of the socket in the kernel, SO_RCVBUF and SO_SNDBUF.
+	ReadBuffer  int
+	WriteBuffer int
+
+	// Control, if set, is called last with the connection, to set options not covered
+	// above, for example through its SyscallConn. An error fails the opening.
+	Control func(conn net.Conn) error
+}
+
+// Apply sets the options on conn, or on the connection it wraps if it is a TLS connection.
+// Only Control applies to connections that are not over TCP. Open applies WSOpener.Socket
+// to the connections it hijacks; Apply lets dialed connections be tuned the same way.
+func (o SocketOptions) Apply(conn net.Conn) error {
+	tcp, ok := underlyingConn(conn).(*net.TCPConn)
+	if ok {
+		err := o.applyTCP(tcp)
+		if err != nil {
+			return err
+		}
+	}
+
+	if o.Control != nil {
+		return o.Control(conn)
+	}
+
+	return nil
+}
+
+func (o SocketOptions) applyTCP(conn *net.TCPConn) error {
+	err := conn.SetNoDelay(!o.DelayWrites)
+	if err != nil {
+		return err
+	}
+
+	if o.KeepAlive < 0 {
+		err = conn.SetKeepAlive(false)
+	} else if o.KeepAlive > 0 || o.KeepAliveInterval > 0 || o.KeepAliveCount > 0 {
+		err = conn.SetKeepAliveConfig(net.KeepAliveConfig{
+			Enable:   true,
+			Idle:     unchangedIfZero(o.KeepAlive),
+			Interval: unchangedIfZero(o.KeepAliveInterval),
+			Count:    int(unchangedIfZero(time.Duration(o.KeepAliveCount))),
+		})
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if o.ReadBuffer > 0 {
+		err = conn.SetReadBuffer(o.ReadBuffer)
+		if err != nil {
+			return err
+		}
+	}
+
+	if o.WriteBuffer > 0 {
+		return conn.SetWriteBuffer(o.WriteBuffer)
+	}
+
+	return nil
+}
+
+// unchangedIfZero maps zero to the negative value leaving a keepalive setting unchanged.
+func unchangedIfZero(v time.Duration) time.Duration {
+	if v == 0 {
+		return -1
+	}
+
+	return v
+}
+
+// underlyingConn gives the connection a TLS connection wraps.
+func underlyingConn(conn net.Conn) net.Conn {
+	for {
+		wrapper, ok := conn.(interface{ NetConn() net.Conn })
+		if !ok {
+			return conn
+		}
+
+		conn = wrapper.NetConn()
+	}
+}
@@ -0,0 +1,135 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"net/http"
+)
+
+// ServeEvents serves the broadcasts of the hub as Server-Sent Events, for clients that
+// can only receive or cannot upgrade. The client is represented in the hub by a receive-only
+// connection, which setup, if set, adds to rooms and tags; otherwise it is only registered.
+// An error of setup rejects the request with 403 Forbidden. Applications can also queue
+// messages to the client with Enqueue.
+//
+// Text messages are sent as the data of unnamed events, one data line per line of text.
+// Binary messages are sent base64 encoded, as events named binary. The connection closes
+// when the client goes away. ServeEvents returns once it has.
+func (h *Hub) ServeEvents(w http.ResponseWriter, r *http.Request, setup func(ws *Websocket) error) error {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return FlushingNotSupported
+	}
+
+	ws := newEventStream(r)
+	defer ws.terminate(GoingAway, nil)
+
+	err := h.Register(ws)
+	if err == nil && setup != nil {
+		err = setup(ws)
+	}
+
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return err
+	}
+
+	header := w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	// keeps reverse proxies such as nginx from buffering the stream
+	header.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	queue := ws.sendQueue()
+	for {
+		msg, ok := queue.pop(ws.done)
+		if !ok {
+			return nil
+		}
+
+		var event []byte
+		if msg.prepared != nil {
+			event = msg.prepared.event()
+		} else {
+			event = encodeEvent(msg.Message)
+		}
+
+		_, err = w.Write(event)
+		if err != nil {
+			return err
+		}
+
+		flusher.Flush()
+	}
+}
+
+// newEventStream creates the receive-only connection standing for an event stream client.
+// It has no underlying connection: its send queue is drained by ServeEvents, and direct
+// reads and writes fail with ReceiveOnly.
+func newEventStream(r *http.Request) *Websocket {
+	ws := &Websocket{
+		t:           TextWebsocket,
+		request:     r,
+		eventStream: true,
+		reader:      bufio.NewReader(receiveOnly{}),
+		writer:      bufio.NewWriter(receiveOnly{}),
+		done:        make(chan struct{}),
+	}
+
+	// unlike websockets, the stream ends with the request
+	ws.ctx, ws.cancel = context.WithCancel(r.Context())
+	context.AfterFunc(ws.ctx, func() {
+		_ = ws.terminate(GoingAway, nil)
+	})
+
+	return ws
+}
+
+// receiveOnly is the transport of event stream connections, which cannot be read from
+// nor written to directly.
+type receiveOnly struct{}
+
+func (receiveOnly) Read(p []byte) (int, error) {
+	return 0, ReceiveOnly
+}
+
+func (receiveOnly) Write(p []byte) (int, error) {
+	return 0, ReceiveOnly
+}
+
+// event gives the message encoded as a Server-Sent Event, encoding it once for every
+// event stream it is sent to.
+func (pm *PreparedMessage) event() []byte {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	if pm.sse == nil {
+		pm.sse = encodeEvent(Message{Type: pm.t, Data: pm.data})
+	}
+
+	return pm.sse
+}
+
+// encodeEvent encodes a message as a Server-Sent Event.
+func encodeEvent(msg Message) []byte {
+	var buf bytes.Buffer
+	data := msg.Data
+	if msg.Type == BinaryMessage {
+		buf.WriteString("event: binary\n")
+		data = []byte(base64.StdEncoding.EncodeToString(data))
+	}
+
+	// the data of an event ends at a blank line, so every line is sent as a field of its own
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		buf.WriteString("data: ")
+		buf.Write(bytes.TrimSuffix(line, []byte("\r")))
+		buf.WriteByte('\n')
+	}
+
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
@@ -0,0 +1,156 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+)
+
+// ConnectionState is the stage of its lifetime a connection is in.
+type ConnectionState string
+
+var (
+	// StateOpen is the state of a connection after the handshake, when messages can be exchanged.
+	StateOpen ConnectionState = "open"
+
+	// StateClosing is the state of a connection once a Close frame was sent or received.
+	// No more messages can be sent, but the peer may still be read from.
+	StateClosing ConnectionState = "closing"
+
+	// StateClosed is the terminal state of a connection, once the underlying connection is closed.
+	StateClosed ConnectionState = "closed"
+)
+
+// State gives the current state of the connection.
+func (ws *Websocket) State() ConnectionState {
+	ws.stateMu.Lock()
+	defer ws.stateMu.Unlock()
+	if ws.state == "" {
+		return StateOpen
+	}
+
+	return ws.state
+}
+
+// OnClose registers a function called exactly once when the connection terminates,
+// whether by a clean close, a protocol error or an I/O failure. It is given the status
+// code of the closure, AbnormalClosure if there was no closing handshake, and the error
+// that caused it, nil if the connection was closed with Close or CloseWithCode.
+func (ws *Websocket) OnClose(f func(code CloseCode, err error)) {
+	ws.stateMu.Lock()
+	defer ws.stateMu.Unlock()
+	ws.onClose = f
+}
+
+// setState moves the connection forward to the given state; a connection never goes back
+// to an earlier state. It reports whether the state changed. The first transition out of
+// StateOpen records the status code and cause of the closure.
+func (ws *Websocket) setState(state ConnectionState, code CloseCode, cause error) bool {
+	ws.stateMu.Lock()
+	defer ws.stateMu.Unlock()
+	current := ws.state
+	if current == "" {
+		current = StateOpen
+	}
+
+	if current == state || current == StateClosed || (current == StateClosing && state == StateOpen) {
+		return false
+	}
+
+	if current == StateOpen {
+		ws.closeCode = code
+		ws.closeCause = cause
+	}
+
+	ws.state = state
+	return true
+}
+
+// terminate moves the connection to StateClosed and closes the underlying connection,
+// if that has not already happened, then calls the OnClose function.
+// The code and cause are only recorded if the connection was still open.
+func (ws *Websocket) terminate(code CloseCode, cause error) error {
+	if !ws.setState(StateClosed, code, cause) {
+		return nil
+	}
+
+	if ws.done != nil {
+		close(ws.done)
+	}
+
+	if ws.cancel != nil {
+		ws.cancel()
+	}
+
+	var err error
+	if ws.conn != nil {
+		err = ws.conn.Close()
+	}
+
+	if ws.capture != nil {
+		ws.capture.close()
+	}
+
+	ws.stateMu.Lock()
+	onClose, code, cause := ws.onClose, ws.closeCode, ws.closeCause
+	ws.stateMu.Unlock()
+	if onClose != nil {
+		onClose(code, cause)
+	}
+
+	if ws.metrics != nil {
+		ws.metrics.ConnectionClosed(ws, code, cause)
+	}
+
+	ws.logClose(code, cause)
+
+	return err
+}
+
+// checkError records the effect of a failed read or write on the state of the connection,
+// and replaces *err with ErrConnectionClosed if the connection was already closed.
+func (ws *Websocket) checkError(err *error) {
+	if *err == nil {
+		return
+	}
+
+	var closeErr *CloseError
+	switch {
+	case errors.As(*err, &closeErr):
+		// the peer closed and its Close frame has been answered, nothing more will be exchanged
+		_ = ws.terminate(closeErr.Code, closeErr)
+	case isConnectionFailure(*err) || ws.writeFailed.Load():
+		// a write cut off by a deadline or a cancellation leaves the connection as unusable
+		if ws.State() == StateClosed {
+			// the connection was closed under the call, by Close or an earlier failure
+			*err = ErrConnectionClosed
+			return
+		}
+
+		_ = ws.terminate(AbnormalClosure, *err)
+	}
+}
+
+// isCleanClose reports whether err only means the connection was closed, either by
+// a closing handshake with the peer or by a call to Close.
+func isCleanClose(err error) bool {
+	var closeErr *CloseError
+	return errors.As(err, &closeErr) || errors.Is(err, ErrConnectionClosed)
+}
+
+// isConnectionFailure reports whether err means the underlying connection is unusable,
+// as opposed to a timeout or cancellation after which the caller decides what to do.
+func isConnectionFailure(err error) bool {
+	if errors.Is(err, os.ErrDeadlineExceeded) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, net.ErrClosed) || errors.Is(err, io.ErrClosedPipe) {
+		return true
+	}
+
+	var opErr *net.OpError
+	return errors.As(err, &opErr)
+}
@@ -0,0 +1,476 @@
+package stomp
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"github.com/ajsqr/websocket"
+)
+
+// The acknowledgment modes of subscriptions.
+const (
+	// AckAuto considers messages acknowledged once they are sent.
+	AckAuto = "auto"
+
+	// AckClient expects ACK frames, each acknowledging all the messages of its
+	// subscription up to the one it names.
+	AckClient = "client"
+
+	// AckClientIndividual expects an ACK frame for every message.
+	AckClientIndividual = "client-individual"
+)
+
+// Broker relays the messages sent by the clients of its connections to the subscribers
+// of their destination. Destinations are plain names, created on first use.
+type Broker struct {
+	// Authenticate, if set, accepts or rejects a connection from the login and passcode
+	// headers of its CONNECT frame. An error is sent to the client in an ERROR frame.
+	Authenticate func(ctx context.Context, login, passcode string) error
+
+	// OnSend, if set, is called with every SEND frame before it is delivered, and may
+	// modify it. An error is sent to the client in an ERROR frame.
+	OnSend func(ctx context.Context, ws *websocket.Websocket, f *Frame) error
+
+	// OnNack, if set, is called with the messages clients do not acknowledge with a NACK
+	// frame. They are dropped otherwise.
+	OnNack func(ctx context.Context, ws *websocket.Websocket, msg *Frame)
+
+	mu            sync.RWMutex
+	subscriptions map[string]map[*subscription]struct{}
+	nextID        atomic.Uint64
+}
+
+// NewBroker returns a Broker without subscriptions.
+func NewBroker() *Broker {
+	return &Broker{
+		subscriptions: map[string]map[*subscription]struct{}{},
+	}
+}
+
+// Publish sends a MESSAGE frame with the body and headers to the subscribers of the
+// destination, as if a client had sent it.
+func (b *Broker) Publish(ctx context.Context, destination string, header map[string]string, body []byte) {
+	f := &Frame{Command: Send, Header: map[string]string{}, Body: body}
+	for name, value := range header {
+		f.Header[name] = value
+	}
+
+	f.Header["destination"] = destination
+	b.deliver(ctx, f)
+}
+
+// Serve runs the protocol on ws until the client disconnects, the connection closes or
+// ctx is done. The first frame must be a CONNECT or STOMP frame. Serve returns nil when
+// the client disconnected or the connection was closed cleanly.
+func (b *Broker) Serve(ctx context.Context, ws *websocket.Websocket) error {
+	s := &session{
+		broker:        b,
+		ws:            ws,
+		subscriptions: map[string]*subscription{},
+		transactions:  map[string][]*Frame{},
+	}
+
+	defer s.unsubscribeAll()
+	for msg, err := range ws.Messages(ctx) {
+		if err != nil {
+			return err
+		}
+
+		f, err := ParseFrame(msg.Data)
+		if errors.Is(err, EmptyFrame) {
+			// a heart-beat
+			continue
+		}
+
+		if err != nil {
+			return s.fail(ctx, err.Error(), nil)
+		}
+
+		done, err := s.handle(ctx, f)
+		if err != nil || done {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deliver sends a SEND frame to the subscribers of its destination.
+func (b *Broker) deliver(ctx context.Context, f *Frame) {
+	destination := f.Get("destination")
+	b.mu.RLock()
+	subscribers := make([]*subscription, 0, len(b.subscriptions[destination]))
+	for sub := range b.subscriptions[destination] {
+		subscribers = append(subscribers, sub)
+	}
+	b.mu.RUnlock()
+
+	for _, sub := range subscribers {
+		msg := NewFrame(Message)
+		for name, value := range f.Header {
+			switch name {
+			case "receipt", "transaction", "content-length":
+			default:
+				msg.Header[name] = value
+			}
+		}
+
+		msg.Body = f.Body
+		msg.Set("subscription", sub.id)
+		msg.Set("message-id", strconv.FormatUint(b.nextID.Add(1), 10))
+		sub.session.send(ctx, sub, msg)
+	}
+}
+
+func (b *Broker) subscribe(sub *subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subscriptions == nil {
+		b.subscriptions = map[string]map[*subscription]struct{}{}
+	}
+
+	if b.subscriptions[sub.destination] == nil {
+		b.subscriptions[sub.destination] = map[*subscription]struct{}{}
+	}
+
+	b.subscriptions[sub.destination][sub] = struct{}{}
+}
+
+func (b *Broker) unsubscribe(sub *subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.subscriptions[sub.destination], sub)
+	if len(b.subscriptions[sub.destination]) == 0 {
+		delete(b.subscriptions, sub.destination)
+	}
+}
+
+// subscription is a subscription of a client to a destination.
+type subscription struct {
+	session     *session
+	id          string
+	destination string
+	ack         string
+}
+
+// session is the state of the protocol on a connection.
+type session struct {
+	broker  *Broker
+	ws      *websocket.Websocket
+	version string
+
+	mu            sync.Mutex
+	subscriptions map[string]*subscription
+
+	// unacked are the messages sent to subscriptions expecting ACK frames, in order.
+	unacked []unacked
+
+	// transactions are the frames sent in the transactions begun by the client.
+	transactions map[string][]*Frame
+}
+
+// unacked is a message waiting for its ACK frame.
+type unacked struct {
+	sub *subscription
+	id  string
+	msg *Frame
+}
+
+// handle handles a frame from the client, and reports whether the client disconnected.
+func (s *session) handle(ctx context.Context, f *Frame) (bool, error) {
+	if s.version == "" {
+		if f.Command != Connect && f.Command != Stomp {
+			return true, s.fail(ctx, "expected a CONNECT frame", f)
+		}
+
+		return false, s.connect(ctx, f)
+	}
+
+	var err error
+	switch f.Command {
+	case Send, Ack, Nack:
+		if tx := f.Get("transaction"); tx != "" {
+			err = s.transact(tx, f)
+			break
+		}
+
+		err = s.apply(ctx, f)
+	case Subscribe:
+		err = s.subscribe(f)
+	case Unsubscribe:
+		err = s.unsubscribe(f.Get("id"))
+	case Begin:
+		err = s.begin(f.Get("transaction"))
+	case Commit, Abort:
+		var frames []*Frame
+		frames, err = s.end(f.Get("transaction"))
+		for i := 0; err == nil && f.Command == Commit && i < len(frames); i++ {
+			err = s.apply(ctx, frames[i])
+		}
+	case Disconnect:
+		if receipt := f.Get("receipt"); receipt != "" {
+			// sent right away: the connection is closed after it, queued frames or not
+			data, _ := NewFrame(Receipt, "receipt-id", receipt).MarshalBinary()
+			_ = s.ws.Send(ctx, data)
+		}
+
+		return true, s.ws.Close()
+	default:
+		err = errors.New("unknown command " + f.Command)
+	}
+
+	if err != nil {
+		return true, s.fail(ctx, err.Error(), f)
+	}
+
+	if receipt := f.Get("receipt"); receipt != "" {
+		s.reply(ctx, NewFrame(Receipt, "receipt-id", receipt))
+	}
+
+	return false, nil
+}
+
+// connect negotiates the version of the protocol and authenticates the client.
+func (s *session) connect(ctx context.Context, f *Frame) error {
+	versions := strings.Split(f.Get("accept-version"), ",")
+	for _, version := range []string{"1.2", "1.1"} {
+		for _, accepted := range versions {
+			if s.version == "" && strings.TrimSpace(accepted) == version {
+				s.version = version
+			}
+		}
+	}
+
+	if s.version == "" {
+		return s.fail(ctx, "supported protocol versions are 1.1,1.2", f)
+	}
+
+	if s.broker.Authenticate != nil {
+		err := s.broker.Authenticate(ctx, f.Get("login"), f.Get("passcode"))
+		if err != nil {
+			return s.fail(ctx, err.Error(), f)
+		}
+	}
+
+	s.reply(ctx, NewFrame(Connected, "version", s.version, "heart-beat", "0,0", "session", s.ws.ID()))
+	return nil
+}
+
+// apply carries out a SEND, ACK or NACK frame.
+func (s *session) apply(ctx context.Context, f *Frame) error {
+	if f.Command != Send {
+		return s.acknowledge(ctx, f)
+	}
+
+	if f.Get("destination") == "" {
+		return errors.New("missing destination header")
+	}
+
+	if s.broker.OnSend != nil {
+		err := s.broker.OnSend(ctx, s.ws, f)
+		if err != nil {
+			return err
+		}
+	}
+
+	s.broker.deliver(ctx, f)
+	return nil
+}
+
+func (s *session) subscribe(f *Frame) error {
+	sub := &subscription{
+		session:     s,
+		id:          f.Get("id"),
+		destination: f.Get("destination"),
+		ack:         f.Get("ack"),
+	}
+
+	if sub.id == "" || sub.destination == "" {
+		return errors.New("missing id or destination header")
+	}
+
+	switch sub.ack {
+	case "":
+		sub.ack = AckAuto
+	case AckAuto, AckClient, AckClientIndividual:
+	default:
+		return errors.New("invalid ack mode " + sub.ack)
+	}
+
+	s.mu.Lock()
+	_, exists := s.subscriptions[sub.id]
+	if !exists {
+		s.subscriptions[sub.id] = sub
+	}
+	s.mu.Unlock()
+	if exists {
+		return errors.New("duplicate subscription " + sub.id)
+	}
+
+	s.broker.subscribe(sub)
+	return nil
+}
+
+func (s *session) unsubscribe(id string) error {
+	s.mu.Lock()
+	sub, ok := s.subscriptions[id]
+	delete(s.subscriptions, id)
+	s.mu.Unlock()
+	if !ok {
+		return errors.New("unknown subscription " + id)
+	}
+
+	s.broker.unsubscribe(sub)
+	return nil
+}
+
+func (s *session) unsubscribeAll() {
+	s.mu.Lock()
+	subscriptions := s.subscriptions
+	s.subscriptions = map[string]*subscription{}
+	s.mu.Unlock()
+	for _, sub := range subscriptions {
+		s.broker.unsubscribe(sub)
+	}
+}
+
+// acknowledge removes the messages acknowledged by an ACK or NACK frame from those
+// waiting for one, handing those of a NACK frame to Broker.OnNack.
+func (s *session) acknowledge(ctx context.Context, f *Frame) error {
+	// STOMP 1.2 acknowledges the ack header of the message, 1.1 its message-id
+	id := f.Get("id")
+	if s.version == "1.1" {
+		id = f.Get("message-id")
+	}
+
+	s.mu.Lock()
+	i := 0
+	for i < len(s.unacked) && s.unacked[i].id != id {
+		i++
+	}
+
+	if i == len(s.unacked) {
+		s.mu.Unlock()
+		return errors.New("unknown message " + id)
+	}
+
+	sub := s.unacked[i].sub
+	var acked []unacked
+	if sub.ack == AckClientIndividual {
+		acked = append(acked, s.unacked[i])
+		s.unacked = append(s.unacked[:i], s.unacked[i+1:]...)
+	} else {
+		// cumulative: every earlier message of the subscription is acknowledged too
+		kept := s.unacked[:0]
+		for j, u := range s.unacked {
+			if u.sub == sub && j <= i {
+				acked = append(acked, u)
+				continue
+			}
+
+			kept = append(kept, u)
+		}
+
+		s.unacked = kept
+	}
+	s.mu.Unlock()
+
+	if f.Command == Nack && s.broker.OnNack != nil {
+		for _, u := range acked {
+			s.broker.OnNack(ctx, s.ws, u.msg)
+		}
+	}
+
+	return nil
+}
+
+func (s *session) begin(tx string) error {
+	if tx == "" {
+		return errors.New("missing transaction header")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.transactions[tx]; ok {
+		return errors.New("transaction " + tx + " already begun")
+	}
+
+	s.transactions[tx] = []*Frame{}
+	return nil
+}
+
+func (s *session) transact(tx string, f *Frame) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	frames, ok := s.transactions[tx]
+	if !ok {
+		return errors.New("unknown transaction " + tx)
+	}
+
+	s.transactions[tx] = append(frames, f)
+	return nil
+}
+
+// end ends a transaction and gives its frames.
+func (s *session) end(tx string) ([]*Frame, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	frames, ok := s.transactions[tx]
+	if !ok {
+		return nil, errors.New("unknown transaction " + tx)
+	}
+
+	delete(s.transactions, tx)
+	return frames, nil
+}
+
+// send sends a MESSAGE frame to a subscription, recording it until it is acknowledged
+// if the subscription expects it.
+func (s *session) send(ctx context.Context, sub *subscription, msg *Frame) {
+	if sub.ack != AckAuto {
+		id := msg.Get("message-id")
+		if s.version == "1.2" {
+			msg.Set("ack", id)
+		}
+
+		s.mu.Lock()
+		s.unacked = append(s.unacked, unacked{sub: sub, id: id, msg: msg})
+		s.mu.Unlock()
+	}
+
+	s.reply(ctx, msg)
+}
+
+// reply queues a frame to the client. Frames are dropped once the connection is closed.
+func (s *session) reply(ctx context.Context, f *Frame) {
+	data, _ := f.MarshalBinary()
+	_ = s.ws.Enqueue(ctx, websocket.Message{Type: messageType(f.Body), Data: data})
+}
+
+// fail sends an ERROR frame to the client and closes the connection, as the specification
+// requires. It returns the error reported to the client.
+func (s *session) fail(ctx context.Context, message string, f *Frame) error {
+	reply := NewFrame(Error, "message", message)
+	if f != nil && f.Get("receipt") != "" {
+		reply.Set("receipt-id", f.Get("receipt"))
+	}
+
+	data, _ := reply.MarshalBinary()
+	_ = s.ws.Send(ctx, data)
+	_ = s.ws.Close()
+	return errors.New("stomp: " + message)
+}
+
+// messageType sends frames as text messages, unless their body is not valid UTF-8.
+func messageType(body []byte) websocket.MessageType {
+	if utf8.Valid(body) {
+		return websocket.TextMessage
+	}
+
+	return websocket.BinaryMessage
+}
@@ -0,0 +1,236 @@
+// Package stomp serves STOMP 1.1 and 1.2 over websocket connections, for frontends
+// using Stomp.js and other STOMP clients, with a codec for its frames and a Broker
+// relaying messages between the subscriptions of its connections.
+//
+// Servers select the protocol by listing Subprotocols in WSOpener.Subprotocols.
+package stomp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Subprotocols are the names under which clients request STOMP in the
+// Sec-WebSocket-Protocol header, most recent version first.
+var Subprotocols = []string{"v12.stomp", "v11.stomp"}
+
+// The commands of client frames.
+const (
+	Connect     = "CONNECT"
+	Stomp       = "STOMP"
+	Send        = "SEND"
+	Subscribe   = "SUBSCRIBE"
+	Unsubscribe = "UNSUBSCRIBE"
+	Ack         = "ACK"
+	Nack        = "NACK"
+	Begin       = "BEGIN"
+	Commit      = "COMMIT"
+	Abort       = "ABORT"
+	Disconnect  = "DISCONNECT"
+)
+
+// The commands of server frames.
+const (
+	Connected = "CONNECTED"
+	Message   = "MESSAGE"
+	Receipt   = "RECEIPT"
+	Error     = "ERROR"
+)
+
+var (
+	EmptyFrame = errors.New("stomp: empty frame")
+
+	MissingNull = errors.New("stomp: frame not terminated by a null byte")
+
+	InvalidHeader = errors.New("stomp: invalid header")
+)
+
+// Frame is a STOMP frame. Only the first occurrence of a repeated header is kept,
+// as the specification requires.
+type Frame struct {
+	Command string
+	Header  map[string]string
+	Body    []byte
+}
+
+// NewFrame creates a frame of the command with the given headers, as name and value pairs.
+func NewFrame(command string, header ...string) *Frame {
+	f := &Frame{Command: command, Header: map[string]string{}}
+	for i := 0; i+1 < len(header); i += 2 {
+		f.Header[header[i]] = header[i+1]
+	}
+
+	return f
+}
+
+// Get gives the value of a header, and "" if the frame does not have it.
+func (f *Frame) Get(name string) string {
+	return f.Header[name]
+}
+
+// Set sets the value of a header.
+func (f *Frame) Set(name, value string) {
+	if f.Header == nil {
+		f.Header = map[string]string{}
+	}
+
+	f.Header[name] = value
+}
+
+// escapes reports whether header names and values of the frame are escaped;
+// they are not in CONNECT and CONNECTED frames.
+func (f *Frame) escapes() bool {
+	return f.Command != Connect && f.Command != Stomp && f.Command != Connected
+}
+
+// MarshalBinary encodes the frame, with a content-length header when it has a body.
+func (f *Frame) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(f.Command)
+	buf.WriteByte('\n')
+	escape := func(s string) string { return s }
+	if f.escapes() {
+		escape = headerEscaper.Replace
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(f.Header)) {
+		if name == "content-length" {
+			continue
+		}
+
+		buf.WriteString(escape(name))
+		buf.WriteByte(':')
+		buf.WriteString(escape(f.Header[name]))
+		buf.WriteByte('\n')
+	}
+
+	if len(f.Body) > 0 {
+		buf.WriteString("content-length:")
+		buf.WriteString(strconv.Itoa(len(f.Body)))
+		buf.WriteByte('\n')
+	}
+
+	buf.WriteByte('\n')
+	buf.Write(f.Body)
+	buf.WriteByte(0)
+	return buf.Bytes(), nil
+}
+
+// ParseFrame decodes a frame. Heart-beats, end of lines sent on their own, fail with EmptyFrame.
+func ParseFrame(data []byte) (*Frame, error) {
+	data = bytes.TrimLeft(data, "\r\n")
+	if len(data) == 0 {
+		return nil, EmptyFrame
+	}
+
+	line, data, ok := cutLine(data)
+	if !ok {
+		return nil, MissingNull
+	}
+
+	f := &Frame{Command: line, Header: map[string]string{}}
+	unescape := func(s string) (string, error) { return s, nil }
+	if f.escapes() {
+		unescape = unescapeHeader
+	}
+
+	for {
+		line, data, ok = cutLine(data)
+		if !ok {
+			return nil, MissingNull
+		}
+
+		if line == "" {
+			break
+		}
+
+		name, value, found := strings.Cut(line, ":")
+		if !found {
+			return nil, fmt.Errorf("%w: %q", InvalidHeader, line)
+		}
+
+		name, err := unescape(name)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err = unescape(value)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, repeated := f.Header[name]; !repeated {
+			f.Header[name] = value
+		}
+	}
+
+	if length, ok := f.Header["content-length"]; ok {
+		n, err := strconv.Atoi(length)
+		if err != nil || n < 0 || n >= len(data) || data[n] != 0 {
+			return nil, fmt.Errorf("%w: content-length %q", InvalidHeader, length)
+		}
+
+		f.Body = data[:n]
+		return f, nil
+	}
+
+	n := bytes.IndexByte(data, 0)
+	if n < 0 {
+		return nil, MissingNull
+	}
+
+	f.Body = data[:n]
+	return f, nil
+}
+
+// cutLine cuts the line, ended by LF or CRLF, at the start of data.
+func cutLine(data []byte) (string, []byte, bool) {
+	line, rest, ok := bytes.Cut(data, []byte("\n"))
+	if !ok {
+		return "", nil, false
+	}
+
+	return string(bytes.TrimSuffix(line, []byte("\r"))), rest, true
+}
+
+var headerEscaper = strings.NewReplacer(`\`, `\\`, "\r", `\r`, "\n", `\n`, ":", `\c`)
+
+// unescapeHeader decodes the escape sequences of a header name or value.
+func unescapeHeader(s string) (string, error) {
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		i++
+		if i == len(s) {
+			return "", fmt.Errorf("%w: undefined escape sequence", InvalidHeader)
+		}
+
+		switch s[i] {
+		case '\\':
+			b.WriteByte('\\')
+		case 'r':
+			b.WriteByte('\r')
+		case 'n':
+			b.WriteByte('\n')
+		case 'c':
+			b.WriteByte(':')
+		default:
+			return "", fmt.Errorf("%w: undefined escape sequence", InvalidHeader)
+		}
+	}
+
+	return b.String(), nil
+}
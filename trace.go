@@ -0,0 +1,107 @@
+package websocket
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// framePreviewSize is the number of payload bytes shown for each frame of a frame trace.
+const framePreviewSize = 64
+
+// frameTrace is the destination of the frame trace of a connection, boxed so it can be
+// swapped atomically.
+type frameTrace struct {
+	w io.Writer
+}
+
+// SetFrameTrace makes the connection write a decoded line for every frame it receives
+// and sends to w: its direction, FIN and reserved bits, opcode, payload length, masking
+// key and the start of its payload, unmasked. It is meant for diagnosing interoperability
+// problems with browsers and proxies, not for production traffic. Each line is written
+// with a single call to Write, from both the reading and the writing goroutines, so w
+// must be safe for concurrent use. A nil w stops the trace.
+//
+// Received frames are traced once their header was read, so their preview only holds
+// the part of the payload that arrived with it.
+func (ws *Websocket) SetFrameTrace(w io.Writer) {
+	if w == nil {
+		ws.trace.Store(nil)
+		return
+	}
+
+	ws.trace.Store(&frameTrace{w: w})
+}
+
+// traceReceived traces a frame whose header was just read, previewing the part of its
+// payload already buffered.
+func (ws *Websocket) traceReceived(f *Frame) {
+	trace := ws.trace.Load()
+	if trace == nil {
+		return
+	}
+
+	n := int(min(f.PayloadLength(), framePreviewSize, uint64(ws.reader.Buffered())))
+	// peeking at buffered bytes never blocks, and leaves them to the payload read
+	buffered, _ := ws.reader.Peek(n)
+	preview := append([]byte(nil), buffered...)
+	if f.Mask {
+		MaskBytes([4]byte(f.MaskingKey), 0, preview)
+	}
+
+	trace.write(ws, "recv", f, f.PayloadLength(), preview)
+}
+
+// traceSent traces a frame just written.
+func (ws *Websocket) traceSent(f *Frame) {
+	trace := ws.trace.Load()
+	if trace == nil {
+		return
+	}
+
+	preview := make([]byte, 0, framePreviewSize)
+	for _, data := range [][]byte{f.ExtensionData, f.ApplicationData} {
+		preview = append(preview, data[:min(len(data), framePreviewSize-len(preview))]...)
+	}
+
+	trace.write(ws, "send", f, uint64(len(f.ExtensionData)+len(f.ApplicationData)), preview)
+}
+
+// write writes the line of a frame with the given payload length.
+func (t *frameTrace) write(ws *Websocket, direction string, f *Frame, length uint64, preview []byte) {
+	line := make([]byte, 0, 128+4*len(preview))
+	line = time.Now().AppendFormat(line, "15:04:05.000000")
+	line = fmt.Appendf(line, " %s %s fin=%d rsv=%d%d%d opcode=%q len=%d",
+		ws.ID(), direction, bit(f.FIN), bit(f.RSV1), bit(f.RSV2), bit(f.RSV3),
+		f.Opcode, length)
+
+	if f.Mask {
+		line = append(line, " mask="...)
+		line = hex.AppendEncode(line, f.MaskingKey)
+	}
+
+	if f.Opcode == ConnectionClose && len(preview) >= 2 {
+		line = append(line, " code="...)
+		line = strconv.AppendUint(line, uint64(preview[0])<<8|uint64(preview[1]), 10)
+	}
+
+	line = append(line, " payload="...)
+	line = strconv.AppendQuote(line, string(preview))
+	if uint64(len(preview)) < length {
+		line = append(line, "..."...)
+	}
+
+	line = append(line, '\n')
+	_, _ = t.w.Write(line)
+}
+
+// bit gives 1 for a set bit and 0 otherwise.
+func bit(set bool) int {
+	if set {
+		return 1
+	}
+
+	return 0
+}
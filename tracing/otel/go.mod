@@ -0,0 +1,18 @@
+module github.com/ajsqr/websocket/tracing/otel
+
+go 1.23.4
+
+require (
+	github.com/ajsqr/websocket v0.0.0
+	go.opentelemetry.io/otel v1.34.0
+	go.opentelemetry.io/otel/trace v1.34.0
+)
+
+require (
+	github.com/go-logr/logr v1.4.2 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	go.opentelemetry.io/auto/sdk v1.1.0 // indirect
+	go.opentelemetry.io/otel/metric v1.34.0 // indirect
+)
+
+replace github.com/ajsqr/websocket => ../../
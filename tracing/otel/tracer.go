@@ -0,0 +1,191 @@
+// Package otel traces the handshakes and messages of websocket connections with
+// OpenTelemetry.
+//
+// It lives in its own module so the websocket package itself stays free of
+// external dependencies.
+package otel
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/ajsqr/websocket"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName names the tracer the spans are created with.
+const instrumentationName = "github.com/ajsqr/websocket/tracing/otel"
+
+var _ websocket.Metrics = (*Tracer)(nil)
+
+// Tracer records the handshakes of websocket connections, and optionally their messages,
+// as spans. Upgrade requests are traced by serving them through Handler, and the rest
+// by setting the Tracer as the Metrics of the WSOpener, possibly combined with others by
+// websocket.MultiMetrics.
+//
+// The trace context of the upgrade request, propagated in its headers, is the parent of
+// the handshake span, which is in turn the parent of the spans of the messages of the
+// connection: its context, given by Websocket.Context, carries the handshake span.
+type Tracer struct {
+	websocket.NopMetrics
+
+	// Provider provides the tracer creating the spans. It defaults to the global
+	// provider.
+	Provider trace.TracerProvider
+
+	// Propagator extracts the trace context from the headers of upgrade requests. It
+	// defaults to the global propagator.
+	Propagator propagation.TextMapPropagator
+
+	// Messages enables a span for every message received or sent. Received messages
+	// have spans without duration, marking their arrival; sent ones span their write.
+	Messages bool
+
+	// Attributes, if set, gives attributes added to the handshake span of a request,
+	// such as its route.
+	Attributes func(r *http.Request) []attribute.KeyValue
+}
+
+// handshakeKey is the context key of the handshake span, kept apart from the current
+// span so the spans of other instrumentation are never ended.
+type handshakeKey struct{}
+
+// Handler starts a handshake span for every request before serving it with next, which
+// is expected to upgrade it with a WSOpener whose Metrics include t. The span ends once
+// the connection is open or the upgrade failed, or at the latest when next returns.
+func (t *Tracer) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := t.propagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		attrs := []attribute.KeyValue{
+			semconv.NetworkProtocolName("websocket"),
+			semconv.HTTPRequestMethodKey.String(r.Method),
+			semconv.URLPath(r.URL.Path),
+		}
+
+		if t.Attributes != nil {
+			attrs = append(attrs, t.Attributes(r)...)
+		}
+
+		ctx, span := t.tracer().Start(ctx, "websocket.handshake",
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(attrs...),
+		)
+
+		// ending a span twice has no effect, so this only ends spans of requests not upgraded
+		defer span.End()
+		next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, handshakeKey{}, span)))
+	})
+}
+
+// ConnectionOpened ends the handshake span of the connection.
+func (t *Tracer) ConnectionOpened(ws *websocket.Websocket) {
+	r := ws.Request()
+	if r == nil {
+		return
+	}
+
+	span, ok := r.Context().Value(handshakeKey{}).(trace.Span)
+	if !ok {
+		return
+	}
+
+	span.SetAttributes(
+		semconv.HTTPResponseStatusCode(http.StatusSwitchingProtocols),
+		attribute.String("websocket.subprotocol", ws.Subprotocol()),
+	)
+
+	span.End()
+}
+
+// HandshakeFailed records the error of the upgrade request on its handshake span, and
+// ends it.
+func (t *Tracer) HandshakeFailed(r *http.Request, status int, err error) {
+	span, ok := r.Context().Value(handshakeKey{}).(trace.Span)
+	if !ok {
+		return
+	}
+
+	if status != 0 {
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+	}
+
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+	span.End()
+}
+
+// ConnectionClosed records the closure of the connection as a span without duration.
+func (t *Tracer) ConnectionClosed(ws *websocket.Websocket, code websocket.CloseCode, err error) {
+	_, span := t.tracer().Start(ws.Context(), "websocket.close",
+		trace.WithAttributes(attribute.Int("websocket.close.code", int(code))),
+	)
+
+	if err != nil {
+		span.RecordError(err)
+		if code != websocket.NormalClosure && code != websocket.GoingAway {
+			span.SetStatus(codes.Error, err.Error())
+		}
+	}
+
+	span.End()
+}
+
+// MessageReceived records the message as a span without duration, if Messages is set.
+func (t *Tracer) MessageReceived(ws *websocket.Websocket, mt websocket.MessageType, size int) {
+	if !t.Messages {
+		return
+	}
+
+	_, span := t.tracer().Start(ws.Context(), "websocket.receive",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(messageAttributes(mt, size)...),
+	)
+
+	span.End()
+}
+
+// MessageSent records the write of the message as a span, if Messages is set.
+func (t *Tracer) MessageSent(ws *websocket.Websocket, mt websocket.MessageType, size int, latency time.Duration) {
+	if !t.Messages {
+		return
+	}
+
+	end := time.Now()
+	_, span := t.tracer().Start(ws.Context(), "websocket.send",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithTimestamp(end.Add(-latency)),
+		trace.WithAttributes(messageAttributes(mt, size)...),
+	)
+
+	span.End(trace.WithTimestamp(end))
+}
+
+func (t *Tracer) tracer() trace.Tracer {
+	provider := t.Provider
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+
+	return provider.Tracer(instrumentationName)
+}
+
+func (t *Tracer) propagator() propagation.TextMapPropagator {
+	if t.Propagator != nil {
+		return t.Propagator
+	}
+
+	return otel.GetTextMapPropagator()
+}
+
+func messageAttributes(mt websocket.MessageType, size int) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("websocket.message.type", string(mt)),
+		attribute.Int("websocket.message.size", size),
+	}
+}
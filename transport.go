@@ -0,0 +1,51 @@
+package websocket
+
+import (
+	"context"
+	"net/http"
+)
+
+// Transport is a message connection to a client, carried by a websocket or by a fallback
+// such as long-polling, so handlers keep the same Send and Receive API whichever the
+// client managed to use.
+type Transport interface {
+	// Send sends a message to the client.
+	Send(ctx context.Context, data []byte) error
+
+	// Receive waits for the next message from the client.
+	Receive(ctx context.Context) ([]byte, error)
+
+	// Close closes the connection.
+	Close() error
+
+	// Context gives the context of the connection, cancelled once it is closed.
+	Context() context.Context
+
+	// ID gives the random identifier of the connection.
+	ID() string
+}
+
+var (
+	_ Transport = (*Websocket)(nil)
+	_ Transport = (*PollConn)(nil)
+)
+
+// TransportHandler returns an http.Handler that upgrades websocket requests with wso into
+// websockets of type t, and serves every other request with the long-polling fallback lp.
+// fn runs with each new connection, whichever its transport, and the connection is closed
+// when fn returns.
+func TransportHandler(wso *WSOpener, t WebsocketType, lp *LongPoll, fn func(ctx context.Context, tr Transport)) http.Handler {
+	websockets := wso.Handler(t, func(ctx context.Context, ws *Websocket) {
+		fn(ctx, ws)
+	})
+
+	polling := lp.Handler(fn)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if headerContainsToken(r.Header, "Upgrade", "websocket") {
+			websockets.ServeHTTP(w, r)
+			return
+		}
+
+		polling.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,45 @@
+package websocket
+
+import (
+	"context"
+)
+
+// Conn is a connection that sends and receives values of a single type T.
+type Conn[T any] struct {
+	ws    *Websocket
+	codec Codec
+}
+
+// Typed wraps ws into a connection carrying values of type T encoded by c.
+// If c is nil, the codec of the connection is used.
+func Typed[T any](ws *Websocket, c Codec) *Conn[T] {
+	return &Conn[T]{
+		ws:    ws,
+		codec: c,
+	}
+}
+
+// Send encodes v and sends it as a single message.
+func (c *Conn[T]) Send(ctx context.Context, v T) error {
+	return c.ws.sendValue(ctx, c.valueCodec(), v)
+}
+
+// Receive waits for the next message and decodes it into a T.
+func (c *Conn[T]) Receive(ctx context.Context) (T, error) {
+	var v T
+	err := c.ws.receiveValue(ctx, c.valueCodec(), &v)
+	return v, err
+}
+
+// Websocket gives the underlying connection.
+func (c *Conn[T]) Websocket() *Websocket {
+	return c.ws
+}
+
+func (c *Conn[T]) valueCodec() Codec {
+	if c.codec != nil {
+		return c.codec
+	}
+
+	return c.ws.valueCodec()
+}
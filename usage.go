@@ -0,0 +1,249 @@
+package websocket
+
+import (
+	"slices"
+	"sync/atomic"
+)
+
+// Usage is the traffic of an identity across its connections to a hub, counting every
+// data message the connections sent and received, whether broadcast by the hub or not.
+type Usage struct {
+	MessagesSent     uint64
+	MessagesReceived uint64
+
+	// BytesSent and BytesReceived are the payload bytes of the messages.
+	BytesSent     uint64
+	BytesReceived uint64
+}
+
+// reachedBy reports whether usage reached any of the limits set in l.
+func (l Usage) reachedBy(usage Usage) bool {
+	return l.MessagesSent > 0 && usage.MessagesSent >= l.MessagesSent ||
+		l.MessagesReceived > 0 && usage.MessagesReceived >= l.MessagesReceived ||
+		l.BytesSent > 0 && usage.BytesSent >= l.BytesSent ||
+		l.BytesReceived > 0 && usage.BytesReceived >= l.BytesReceived
+}
+
+// WithUsageAccounting makes the hub aggregate the Usage of each identity across all of
+// its connections, for billing and quotas. identify gives the identity of a connection
+// as it is registered, for example from a value WSOpener.Authorize put in its context;
+// connections it gives an empty identity for are not accounted. It is called with the
+// hub locked, so it must not use the hub. The usage is kept after the connections of
+// an identity left the hub, until ResetUsage.
+func WithUsageAccounting(identify func(ws *Websocket) string) HubOption {
+	return func(h *Hub) {
+		h.identify = identify
+	}
+}
+
+// WithUsageThreshold registers f to be called once the usage of an identity reaches any
+// of the limits set in limit, which are the fields that are not zero. f is called once
+// per identity, until ResetUsage rearms it, on the goroutine that sent or received the
+// message reaching the limit, so it must not block. It may close the connections of the
+// identity. It requires WithUsageAccounting.
+func WithUsageThreshold(limit Usage, f func(identity string, usage Usage)) HubOption {
+	return func(h *Hub) {
+		h.thresholds = append(h.thresholds, usageThreshold{limit: limit, f: f})
+	}
+}
+
+// usageThreshold is a threshold registered with WithUsageThreshold.
+type usageThreshold struct {
+	limit Usage
+	f     func(identity string, usage Usage)
+}
+
+// Usage gives the usage of the identity, zero if none of its connections was accounted
+// since it was last reset.
+func (h *Hub) Usage(identity string) Usage {
+	h.usageMu.Lock()
+	u := h.usage[identity]
+	h.usageMu.Unlock()
+	if u == nil {
+		return Usage{}
+	}
+
+	return u.load()
+}
+
+// UsageByIdentity gives the usage of every identity accounted since it was last reset.
+func (h *Hub) UsageByIdentity() map[string]Usage {
+	h.usageMu.Lock()
+	defer h.usageMu.Unlock()
+	usage := make(map[string]Usage, len(h.usage))
+	for identity, u := range h.usage {
+		usage[identity] = u.load()
+	}
+
+	return usage
+}
+
+// ResetUsage gives the usage of the identity and counts it from zero again, rearming the
+// thresholds. Identities without connections in the hub are forgotten.
+func (h *Hub) ResetUsage(identity string) Usage {
+	h.usageMu.Lock()
+	defer h.usageMu.Unlock()
+	u := h.usage[identity]
+	if u == nil {
+		return Usage{}
+	}
+
+	if u.conns == 0 {
+		delete(h.usage, identity)
+	}
+
+	for i := range u.fired {
+		u.fired[i].Store(false)
+	}
+
+	return Usage{
+		MessagesSent:     u.messagesSent.Swap(0),
+		MessagesReceived: u.messagesReceived.Swap(0),
+		BytesSent:        u.bytesSent.Swap(0),
+		BytesReceived:    u.bytesReceived.Swap(0),
+	}
+}
+
+// identityUsage counts the usage of an identity.
+type identityUsage struct {
+	identity   string
+	thresholds []usageThreshold
+
+	messagesSent     atomic.Uint64
+	messagesReceived atomic.Uint64
+	bytesSent        atomic.Uint64
+	bytesReceived    atomic.Uint64
+
+	// fired are set for the thresholds whose function was called.
+	fired []atomic.Bool
+
+	// conns is the number of connections of the identity in the hub, guarded by the
+	// usageMu of the hub.
+	conns int
+}
+
+func (u *identityUsage) load() Usage {
+	return Usage{
+		MessagesSent:     u.messagesSent.Load(),
+		MessagesReceived: u.messagesReceived.Load(),
+		BytesSent:        u.bytesSent.Load(),
+		BytesReceived:    u.bytesReceived.Load(),
+	}
+}
+
+// count counts a message of size bytes, and calls the thresholds it makes the usage reach.
+func (u *identityUsage) count(sent bool, size int) {
+	if sent {
+		u.messagesSent.Add(1)
+		u.bytesSent.Add(uint64(size))
+	} else {
+		u.messagesReceived.Add(1)
+		u.bytesReceived.Add(uint64(size))
+	}
+
+	if len(u.thresholds) == 0 {
+		return
+	}
+
+	usage := u.load()
+	for i, t := range u.thresholds {
+		if t.limit.reachedBy(usage) && u.fired[i].CompareAndSwap(false, true) {
+			t.f(u.identity, usage)
+		}
+	}
+}
+
+// account starts counting the usage of ws, which is being registered, towards its
+// identity, if the hub accounts usage. h.mu must be held.
+func (h *Hub) account(ws *Websocket, m *member) {
+	if h.identify == nil {
+		return
+	}
+
+	identity := h.identify(ws)
+	if identity == "" {
+		return
+	}
+
+	h.usageMu.Lock()
+	if h.usage == nil {
+		h.usage = map[string]*identityUsage{}
+	}
+
+	u, ok := h.usage[identity]
+	if !ok {
+		u = &identityUsage{
+			identity:   identity,
+			thresholds: h.thresholds,
+			fired:      make([]atomic.Bool, len(h.thresholds)),
+		}
+
+		h.usage[identity] = u
+	}
+
+	u.conns++
+	h.usageMu.Unlock()
+
+	m.usage = u
+	ws.addUsage(u)
+}
+
+// unaccount stops counting the usage of the member ws, which is leaving the hub.
+func (h *Hub) unaccount(ws *Websocket, m *member) {
+	if m.usage == nil {
+		return
+	}
+
+	ws.removeUsage(m.usage)
+	h.usageMu.Lock()
+	m.usage.conns--
+	h.usageMu.Unlock()
+}
+
+// addUsage makes the messages of the connection count towards u, besides the usages
+// of the other hubs accounting it.
+func (ws *Websocket) addUsage(u *identityUsage) {
+	for {
+		old := ws.usage.Load()
+		var usages []*identityUsage
+		if old != nil {
+			usages = slices.Clone(*old)
+		}
+
+		usages = append(usages, u)
+		if ws.usage.CompareAndSwap(old, &usages) {
+			return
+		}
+	}
+}
+
+// removeUsage stops the messages of the connection from counting towards u.
+func (ws *Websocket) removeUsage(u *identityUsage) {
+	for {
+		old := ws.usage.Load()
+		if old == nil {
+			return
+		}
+
+		usages := slices.DeleteFunc(slices.Clone(*old), func(v *identityUsage) bool {
+			return v == u
+		})
+
+		if ws.usage.CompareAndSwap(old, &usages) {
+			return
+		}
+	}
+}
+
+// countUsage counts a message sent or received towards the usages accounting the
+// connection.
+func (ws *Websocket) countUsage(sent bool, size int) {
+	usages := ws.usage.Load()
+	if usages == nil {
+		return
+	}
+
+	for _, u := range *usages {
+		u.count(sent, size)
+	}
+}
@@ -1,11 +1,18 @@
 package websocket
 
 import (
-	"io"
 	"bufio"
+	"io"
+	"log/slog"
 	"math"
 	"bytes"
 	"context"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
 	"encoding/binary"
 )
 
@@ -29,65 +36,387 @@ var (
 )
 
 type Websocket struct {
+	// id identifies the connection, generated on first use.
+	idOnce sync.Once
+	id string
+
+	conn net.Conn
 	reader *bufio.Reader 
 	writer *bufio.Writer
 	t WebsocketType 
+	subprotocol string
+	extensions []Extension
+	request *http.Request
+
+	// ctx is the context of the connection, cancelled once the connection is closed.
+	ctx context.Context
+	cancel context.CancelFunc
 	framingLimit int
+	repeatDataOpcode bool
+	jsonOptions JSONOptions
+	codec Codec
+
+	// frameLimiter, if set, bounds the rate at which the client may send frames.
+	frameLimiter *rateLimiter
+	onAbuse func(ws *Websocket, err error)
+
+	// sendLimiter, if set, bounds the rate in bytes per second at which data frames are sent.
+	sendLimiter atomic.Pointer[rateLimiter]
+
+	readDeadline *deadline
+	writeDeadline *deadline
+
+	stateMu sync.Mutex
+	state ConnectionState
+	closeCode CloseCode
+	closeCause error
+	onClose func(code CloseCode, err error)
+
+	// done is closed once the connection is closed, to stop its background goroutines.
+	done chan struct{}
+
+	// lastActivity is the time, in unix nanoseconds, application data was last sent or received.
+	lastActivity atomic.Int64
+
+	// usage are the usages of the identity of the connection in the hubs accounting it.
+	usage atomic.Pointer[[]*identityUsage]
+
+	// pingNonce is the payload of the last Ping sent by keepalive, and pongNonce that of
+	// the last Pong answering it, so a late or unsolicited Pong is not taken for an answer.
+	pingNonce atomic.Uint64
+	pongNonce atomic.Uint64
+
+	// pongs counts the Pongs received, whatever their payload, for PongMatchAny.
+	pongs atomic.Uint64
+
+	// messageMu serializes data messages, so the fragments of concurrent messages are not interleaved.
+	messageMu sync.Mutex
+
+	// writeMu serializes frame writes, so concurrent senders cannot corrupt the frame stream.
+	// It is only held for one frame at a time, so control frames can be sent between
+	// the fragments of a message.
+	writeMu sync.Mutex
+
+	// flushDelay and flushSize configure write coalescing, see SetWriteCoalescing;
+	// flushTimer flushes the coalesced frames, and flushPending is set while it is armed.
+	// They are guarded by writeMu.
+	flushDelay time.Duration
+	flushSize int
+	flushTimer *time.Timer
+	flushPending bool
+
+	pingHandler func(appData []byte) error
+	pongHandler func(appData []byte) error
+	closeHandler func(code CloseCode, reason string) error
+
+	// readLimit is the maximum size of a reassembled message, zero for no limit.
+	readLimit int64
+
+	// maxFrameSize is the largest payload length accepted in a frame, zero for the default.
+	maxFrameSize int64
+
+	// metrics, if set, receives the events of the connection.
+	metrics Metrics
+
+	// logger, if set, logs the protocol violations and the closure of the connection.
+	logger atomic.Pointer[slog.Logger]
+
+	// writeFailed is set once a write to the connection failed, a timeout included. The
+	// frame being written may have been cut off on the wire, and the write buffer keeps
+	// the error, so the connection can no longer be written to.
+	writeFailed atomic.Bool
+
+	// trace, if set, receives a decoded line for every frame. See SetFrameTrace.
+	trace atomic.Pointer[frameTrace]
+
+	// profileLabels is set when the context of the connection carries profiler labels,
+	// which the goroutines serving it are tagged with.
+	profileLabels bool
+
+	// capture, if set, records the bytes exchanged by the connection. See WSOpener.Capture.
+	capture *wireCapture
+
+	// messageReader is the reader handed out by the last NextReader call.
+	messageReader *messageReader
+
+	// writeStarted is the time, in unix nanoseconds, the message being written started
+	// to be written, moved forward by the time it waited for the send rate, zero when no
+	// message is; writeLatency is how long the last one took.
+	writeStarted atomic.Int64
+	writeLatency atomic.Int64
+
+	// queueSize and overflowPolicy configure the send queue, see SetQueue.
+	queueSize int
+	overflowPolicy OverflowPolicy
+
+	// pumps runs the channel-based API, once Incoming or Outgoing is first called.
+	pumps pumps
+
+	// replies are the envelopes awaited by SendEventAck and Call, by ID.
+	replies replies
+
+	// eventStream is set on the connections standing for Server-Sent Events clients,
+	// whose send queue is drained by Hub.ServeEvents instead of the write pump.
+	eventStream bool
 }
 
+// Close sends a Close frame with NormalClosure and closes the underlying connection.
+// It is safe to call concurrently with Send and Ping.
 func (ws *Websocket) Close() error {
-	return nil
+	return ws.CloseWithCode(NormalClosure, "")
 }
 
 // Send transports the message from the server to the the client.
+// The message is sent as text or binary depending on the WebsocketType
+// the connection was opened with.
+//
+// It is safe to call Send concurrently from multiple goroutines; messages are
+// written one at a time, each with all of its fragments.
 func (ws *Websocket) Send(ctx context.Context, data []byte) error {
-	frames, err := ws.fragment(ctx, data)
-	if err != nil{
-		return err
+	switch ws.t {
+	case TextWebsocket:
+		return ws.send(ctx, TextFrame, data)
+	case BinaryWebsocket:
+		return ws.send(ctx, BinaryFrame, data)
+	default:
+		return InvalidFrameType
 	}
+}
 
-	for _, frame := range frames {
-		err := ws.writeFrame(frame)
-		if err != nil{
-			return err
+// SendText transports a text message from the server to the client,
+// regardless of the WebsocketType the connection was opened with.
+func (ws *Websocket) SendText(ctx context.Context, data []byte) error {
+	return ws.send(ctx, TextFrame, data)
+}
+
+// SendBinary transports a binary message from the server to the client,
+// regardless of the WebsocketType the connection was opened with.
+func (ws *Websocket) SendBinary(ctx context.Context, data []byte) error {
+	return ws.send(ctx, BinaryFrame, data)
+}
+
+// send fragments the data and writes the frames as they are built, the first frame
+// carrying the given opcode.
+func (ws *Websocket) send(ctx context.Context, opcode Opcode, data []byte) (err error) {
+	if ws.State() != StateOpen {
+		return ErrConnectionClosed
+	}
+
+	if ws.eventStream {
+		return ReceiveOnly
+	}
+
+	defer ws.checkError(&err)
+	if opcode != TextFrame && opcode != BinaryFrame {
+		return InvalidFrameType
+	}
+
+	// the fragments of a message must not be interleaved with those of other messages
+	ws.messageMu.Lock()
+	defer ws.messageMu.Unlock()
+	ws.touch()
+	defer ws.timeWrite()()
+	started := time.Now()
+
+	// each fragment is written as soon as it is built, so the message is never held twice
+	written := 0
+	err = ws.fragments(opcode, data, func(frame *Frame) error {
+		// unlike those of prepared messages, the frames are only used once
+		defer putFrame(frame)
+		err := ws.writeDataFrame(ctx, frame)
+		if err == nil {
+			written++
 		}
 
+		return err
+	})
+
+	if err != nil{
+		ws.abandonMessage(written, err)
+		return err
 	}
 
+	ws.messageSent(opcode, len(data), started)
 	return nil
 }
 
+// writeDataFrame writes a frame of a data message once the send rate allows it.
+// Control frames may be written between two calls, so they never wait for the rest
+// of a message, however large.
+func (ws *Websocket) writeDataFrame(ctx context.Context, frame *Frame) (err error) {
+	err = ws.throttle(ctx, len(frame.ApplicationData))
+	if err != nil {
+		return err
+	}
+
+	unlock, err := ws.lockWrite(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer unlock(&err)
+	return ws.writeFrame(frame)
+}
+
+// abandonMessage fails the connection after a message could not be sent to the end,
+// unless none of its frames were written yet: the client would take the frames of the
+// next message for the rest of this one. A connection whose write failed is already
+// being dropped.
+func (ws *Websocket) abandonMessage(written int, err error) {
+	if written > 0 && !ws.writeFailed.Load() {
+		ws.fail(InternalServerError, err)
+	}
+}
+
 // Receive waits for a message from the client.
-func (ws *Websocket) Receive(ctx context.Context) ([]byte, error) {
-	message := make([]byte, 0)
-	for {
-		frame, err := ws.readFrame()
+// Ping, Pong and Close frames arriving before or between the fragments of the
+// message are passed to their handlers and never returned to the caller.
+func (ws *Websocket) Receive(ctx context.Context) (message []byte, err error) {
+	if ws.State() == StateClosed {
+		return nil, ErrConnectionClosed
+	}
+
+	defer ws.checkError(&err)
+	defer ws.readDeadline.bind(ctx)(&err)
+	// fragments are concatenated into a pooled buffer, only the final message is allocated
+	buf := assemblyPool.get()
+	defer assemblyPool.put(buf)
+	var t MessageType
+	for first := true; ; first = false {
+		frame, err := ws.nextFrameHeader()
+		if err != nil{
+			return bytes.Clone(buf.Bytes()), err
+		}
+
+		if first {
+			t, err = messageType(frame.Opcode)
+		} else if frame.Opcode != ContinuationFrame {
+			err = InvalidOpcode
+		}
+
 		if err != nil{
-			return message, err
+			return bytes.Clone(buf.Bytes()), err
 		}
 
-		umasked, err := frame.umask()
+		err = ws.checkReadLimit(uint64(buf.Len()) + frame.PayloadLength())
 		if err != nil{
-			return message, err
+			return bytes.Clone(buf.Bytes()), err
 		}
 
-		for _, um := range umasked{
-			message = append(message, um)
+		// the payload is read straight into the room it takes at the end of the message,
+		// made for it in chunks so a length declared but never sent is not allocated
+		pos := 0
+		for remaining := frame.PayloadLength(); remaining > 0; {
+			chunk := payloadGrowth(buf.Len(), remaining)
+			buf.Grow(chunk)
+			err = ws.readPayloadInto(frame, buf.AvailableBuffer()[:chunk])
+			if err != nil{
+				return bytes.Clone(buf.Bytes()), err
+			}
+
+			if frame.Mask {
+				// unmasked where it was read, so writing it only extends the buffer over it
+				pos = MaskBytes([4]byte(frame.MaskingKey), pos, frame.ApplicationData)
+			}
+
+			buf.Write(frame.ApplicationData)
+			remaining -= uint64(chunk)
 		}
-		
-		if frame.FIN {
+
+		ws.touch()
+		fin := frame.FIN
+		putFrame(frame)
+		if fin {
 			break
 		}
 	}
 	
-	return message, nil
+	ws.messageReceived(t, buf.Len())
+	return bytes.Clone(buf.Bytes()), nil
 
 }
 
 
 // readFrame reads a single frame from the response stream.
 func (ws *Websocket) readFrame() (*Frame, error){
-	f := Frame{}
+	f, err := ws.readFrameHeader()
+	if err != nil{
+		return nil, err
+	}
+
+	err = ws.readPayload(f)
+	if err != nil{
+		return nil, err
+	}
+
+	return f, nil
+
+}
+
+// readPayload reads the payload of a frame whose header has just been read. The payload
+// is allocated in chunks as it arrives, so a length declared but never sent is not.
+func (ws *Websocket) readPayload(f *Frame) error {
+	payload := make([]byte, 0, payloadGrowth(0, f.PayloadLength()))
+	for remaining := f.PayloadLength(); remaining > 0; {
+		chunk := payloadGrowth(len(payload), remaining)
+		payload = slices.Grow(payload, chunk)
+		err := ws.readPayloadInto(f, payload[len(payload):len(payload)+chunk])
+		if err != nil {
+			return err
+		}
+
+		payload = payload[:len(payload)+chunk]
+		remaining -= uint64(chunk)
+	}
+
+	f.ApplicationData = payload
+	return nil
+}
+
+// payloadGrowth gives how many bytes to make room for in a buffer holding size bytes of
+// a payload, of which remaining are still to be read: all of them up to payloadChunkSize,
+// beyond that as many as the buffer holds. Buffers thus never exceed twice the data
+// actually received, whatever length the peer declares.
+func payloadGrowth(size int, remaining uint64) int {
+	return int(min(remaining, uint64(max(size, payloadChunkSize))))
+}
+
+// checkFrameSize fails the connection with MessageTooBig if the payload length a frame
+// declares exceeds the frame size limit.
+func (ws *Websocket) checkFrameSize(f *Frame) error {
+	limit := ws.maxFrameSize
+	if limit <= 0 {
+		limit = defaultMaxFrameSize
+	}
+
+	if f.PayloadLength() <= uint64(limit) {
+		return nil
+	}
+
+	ws.fail(MessageTooBig, FrameTooLarge)
+	return FrameTooLarge
+}
+
+// readPayloadInto reads the payload of a frame whose header has just been read into
+// payload, which has the length of the payload, and makes it the data of the frame.
+// The payload is read in full, however the connection splits it.
+func (ws *Websocket) readPayloadInto(f *Frame, payload []byte) error {
+	// we assume here that there are no extensions
+	_, err := io.ReadFull(ws.reader, payload)
+		if err != nil{
+			return BadRequest
+		}
+
+		f.ApplicationData = payload
+
+
+	return nil
+}
+
+// readFrameHeader reads everything up to the payload of a single frame from the response stream.
+// The caller is responsible for consuming PayloadLength bytes of payload afterwards.
+func (ws *Websocket) readFrameHeader() (*Frame, error){
+	f := getFrame()
 	// The first byte contains a lot of metadata.
 	// |FIN |RSV1|RSV2|RSV3|     OPCODE     |
 	// We have to selectively read the bits to parse the metadata.
@@ -96,12 +425,21 @@ func (ws *Websocket) readFrame() (*Frame, error){
 		return nil, err
 	}
 
+	if ws.frameLimiter != nil && !ws.frameLimiter.allow(1) {
+		// many tiny frames cost as much to parse as few large ones, so they are limited on their own
+		ws.abuse(PolicyViolation, FrameRateExceeded)
+		return nil, FrameRateExceeded
+	}
+
 	if b&0x80 == 0x80{
 		// this selectively sets all except the MSB to 0
 		f.FIN = true
 	}
 
-	// we are ignoring RSV1 - RSV3 for now :(
+	// the reserved bits only carry meaning for extensions, the message level read path rejects them
+	f.RSV1 = b&0x40 == 0x40
+	f.RSV2 = b&0x20 == 0x20
+	f.RSV3 = b&0x10 == 0x10
 
 	opcode := b&0x0f
 	switch(opcode){
@@ -138,56 +476,77 @@ func (ws *Websocket) readFrame() (*Frame, error){
 	payloadLengthMetadata := payloadMetaData&0x7f
 
 	if 0<= payloadLengthMetadata && payloadLengthMetadata <= 125{
-		s := uint(payloadLengthMetadata)
-		f.payloadLengthInt = &s
+		f.lengths.short = uint(payloadLengthMetadata)
+		f.payloadLengthInt = &f.lengths.short
 	} else if payloadLengthMetadata == 126{
 		// the next two bytes is the length
-		length := make([]byte, 2)
-		_, err := ws.reader.Read(length)
+		var length [2]byte
+		_, err := io.ReadFull(ws.reader, length[:])
 		if err != nil{
 			return nil, err
 		}
-		s := binary.BigEndian.Uint16(length)
-		f.payloadLengthInt16 = &s
+		f.lengths.medium = binary.BigEndian.Uint16(length[:])
+		f.payloadLengthInt16 = &f.lengths.medium
 	} else if payloadLengthMetadata == 127 {
 		// the next four bytes is the length
-		length := make([]byte, 8)
-		_, err := ws.reader.Read(length)
+		var length [8]byte
+		_, err := io.ReadFull(ws.reader, length[:])
 		if err != nil{
 			return nil, err
 		}
 
-		s := binary.BigEndian.Uint64(length)
-		f.payloadLengthInt64 = &s
+		s := binary.BigEndian.Uint64(length[:])
+		if s > math.MaxInt64 {
+			// the most significant bit MUST be 0
+			return nil, InvalidLength
+		}
+
+		f.lengths.long = s
+		f.payloadLengthInt64 = &f.lengths.long
 	} else {
 		return nil, InvalidLength
 	}
 
 	if f.Mask {
 		// we infer that the frame is masked
-		maskingKey := make([]byte, 4)
-		_, err := ws.reader.Read(maskingKey)
+		_, err := io.ReadFull(ws.reader, f.key[:])
 		if err != nil{
 			return nil, BadRequest
 		}
 
-		f.MaskingKey = maskingKey
+		f.MaskingKey = f.key[:]
 	}
 
-	// we assume here that there are no extensions
-	payload := make([]byte, f.PayloadLength())
-	_, err = ws.reader.Read(payload)
-		if err != nil{
-			return nil, BadRequest
-		}
+	err = ws.checkFrameSize(f)
+	if err != nil{
+		return nil, err
+	}
 
-		f.ApplicationData = payload
+	ws.frameReceived(f)
+	return f, nil
+}
 
+const (
+	// maxHeaderSize is the size of the largest frame header: two bytes, an eight byte
+	// extended payload length and a masking key.
+	maxHeaderSize = 14
 
-	return &f, nil
+	// vectoredWriteSize is the payload length from which unmasked frames bypass the
+	// write buffer.
+	vectoredWriteSize = 4 << 10
 
-}
+	// payloadChunkSize is the most room made for a payload ahead of the data received.
+	payloadChunkSize = 1 << 20
+
+	// defaultMaxFrameSize is the largest payload length accepted in a frame when
+	// MaxFrameSize is not set.
+	defaultMaxFrameSize = 64 << 20
+)
 
+// writeFrame writes a single frame to the response stream and flushes it, unless write
+// coalescing leaves it buffered.
+// The payload length is taken from the extension and application data of the frame.
+// Masked frames have their payload masked on the wire, the frame itself is left untouched.
 func (ws *Websocket) writeFrame(frame *Frame) error {
 	frameIdentifier := 0x00 // a byte with all the bits unset
 	if frame.FIN {
@@ -195,7 +554,18 @@ func (ws *Websocket) writeFrame(frame *Frame) error {
 		frameIdentifier |= 0x80 
 	}
 
-	// ignoring all RSV bits for now
+	if frame.RSV1 {
+		frameIdentifier |= 0x40
+	}
+
+	if frame.RSV2 {
+		frameIdentifier |= 0x20
+	}
+
+	if frame.RSV3 {
+		frameIdentifier |= 0x10
+	}
+
 	switch(frame.Opcode){
 	// the last 4 bits of the first byte has the opcode
 	// depending on the opcode of the frame, we have to selectively set 
@@ -216,123 +586,187 @@ func (ws *Websocket) writeFrame(frame *Frame) error {
 		return InvalidOpcode
 	}
 
-	err := ws.writer.WriteByte(byte(frameIdentifier))
-	if err != nil{
-		return err
+	if frame.Mask && len(frame.MaskingKey) != 4 {
+		return InvalidMaskingKey
 	}
 
-	var payloadLength uint64  // masking is not required for frames from server
-	if 0 <= frame.PayloadLength() && frame.PayloadLength ()<= 125 {
-		// binLength := binary.LittleEndian.PutUint32
-		payloadLength = frame.PayloadLength()
-	} else if frame.PayloadLength() <= math.MaxUint16 {
+	// the header is assembled on the stack, so it can be written with the payload at once
+	var buf [maxHeaderSize]byte
+	header := append(buf[:0], byte(frameIdentifier))
+
+	maskBit := byte(0)
+	if frame.Mask {
+		maskBit = 0x80
+	}
+
+	length := uint64(len(frame.ExtensionData) + len(frame.ApplicationData))
+	if length <= 125 {
+		header = append(header, maskBit | byte(length))
+	} else if length <= math.MaxUint16 {
 		// If 126, the following 2 bytes interpreted as a
 		// 16-bit unsigned integer are the payload length
-		payloadLength = 126
-	} else if frame.PayloadLength() <= math.MaxUint64 {
+		header = append(header, maskBit | 126)
+		header = binary.BigEndian.AppendUint16(header, uint16(length))
+	} else {
 		// If 127, the following 8 bytes interpreted as a 64-bit 
 		// unsigned integer (the most significant bit MUST be 0) are the payload length
-		payloadLength = 127
-	} else {
-		return InvalidLength
+		header = append(header, maskBit | 127)
+		header = binary.BigEndian.AppendUint64(header, length)
+	}
+
+	if frame.Mask {
+		header = append(header, frame.MaskingKey...)
+	}
+
+	err := ws.writeEncoded(header, length, frame)
+	if err != nil{
+		// the frame may be cut off on the wire, and the write buffer keeps failing anyway
+		ws.writeFailed.Store(true)
+		return err
 	}
 
-	err = ws.writer.WriteByte(byte(payloadLength))
+	ws.frameSent(frame)
+	return nil
+}
+
+// writeEncoded writes the encoded header of a frame of the given payload length, then its
+// payload, and flushes them unless write coalescing leaves them buffered. writeMu must
+// be held.
+func (ws *Websocket) writeEncoded(header []byte, length uint64, frame *Frame) error {
+	if !frame.Mask && ws.conn != nil && ws.capture == nil && length >= vectoredWriteSize {
+		return ws.writeVectored(header, frame)
+	}
+
+	_, err := ws.writer.Write(header)
 	if err != nil{
 		return err
 	}
 
-	if payloadLength == 126 {
-		// write the lenth as Uint16 (2 bytes)
-		payloadLength := make([]byte, 2)
-		binary.BigEndian.PutUint16(payloadLength, *frame.payloadLengthInt16)
-		_, err := ws.writer.Write(payloadLength)
+	if frame.Mask {
+		pos := 0
+		for _, data := range [][]byte{frame.ExtensionData, frame.ApplicationData} {
+			// masked in a pooled copy, the frame itself is left untouched
+			masked := getPayload(len(data))
+			copy(*masked, data)
+			pos = MaskBytes([4]byte(frame.MaskingKey), pos, *masked)
+			_, err = ws.writer.Write(*masked)
+			putPayload(masked)
+			if err != nil{
+				return err
+			}
+		}
+	} else {
+		_, err = ws.writer.Write(frame.ExtensionData)
 		if err != nil{
 			return err
 		}
 
-	} else if payloadLength == 127 {
-		// write the lenth as Uint64 (8 bytes)
-		payloadLength := make([]byte, 8)
-		binary.BigEndian.PutUint64(payloadLength, *frame.payloadLengthInt64)
-		_, err := ws.writer.Write(payloadLength)
+		_, err = ws.writer.Write(frame.ApplicationData)
 		if err != nil{
 			return err
 		}
-	} 
-
-	_, err = ws.writer.Write(frame.ApplicationData)
-	if err != nil{
-		return err
 	}
 
-	err = ws.writer.Flush()
-	if err != nil{
+	return ws.flushFrame(frame)
+}
+
+// writeVectored writes the header and payload of an unmasked frame straight to the
+// connection in a single vectored write, writev where the connection supports it,
+// rather than copying a large payload through the write buffer. Whatever is buffered
+// is flushed first, so the frame follows it on the wire.
+// Captured connections never write this way, so the buffer records every frame.
+func (ws *Websocket) writeVectored(header []byte, frame *Frame) error {
+	err := ws.flush()
+	if err != nil {
 		return err
 	}
 
-	return nil
-
+	buffers := net.Buffers{header, frame.ExtensionData, frame.ApplicationData}
+	_, err = buffers.WriteTo(ws.conn)
+	return err
 }
 
 
-// fragment will fragment the payload based on the fragmentation settings
-func (ws *Websocket) fragment(ctx context.Context, data []byte) ([]*Frame, error) {
+// fragment gives the frames of the payload based on the fragmentation settings, for
+// messages written many times, such as prepared messages.
+func (ws *Websocket) fragment(opcode Opcode, data []byte) ([]*Frame, error) {
 	frames := make([]*Frame, 0)
-	fragmentReader := bytes.NewReader(data)
-	payloadLength := len(data)
-	for {
-		chunkSize := payloadLength
-		if ws.framingLimit < chunkSize{
-			chunkSize = ws.framingLimit
-		}
+	err := ws.fragments(opcode, data, func(frame *Frame) error {
+		frames = append(frames, frame)
+		return nil
+	})
 
-		chunk := make([]byte, chunkSize)
-		read, err := fragmentReader.Read(chunk)
-		
-		if err == io.EOF {
-			break
-		}
+	return frames, err
+}
+
+// fragments splits the payload based on the fragmentation settings and passes each
+// fragment to write in turn, which owns the frame from then on. The first frame carries
+// the given opcode, text or binary, the rest are continuation frames. The fragments are
+// slices of data, and each is built only once the previous one was written, so sending
+// a message never copies its payload. Without a framing limit the message is sent in a
+// single frame.
+func (ws *Websocket) fragments(opcode Opcode, data []byte, write func(frame *Frame) error) error {
+	chunkSize := ws.framingLimit
+	if chunkSize <= 0 {
+		chunkSize = len(data)
+	}
 
-		frame := Frame{
-			ApplicationData: chunk, 
-			Opcode: ContinuationFrame,
+	for {
+		// an empty message is still sent, as a single empty frame
+		chunk := data[:min(chunkSize, len(data))]
+		data = data[len(chunk):]
+
+		// the frame may be reused once written
+		last := len(data) == 0
+		frame := getFrame()
+		frame.FIN = last
+		frame.Opcode = opcode
+		frame.ApplicationData = chunk
+		err := frame.setPayloadLength(len(chunk))
+		if err != nil {
+			putFrame(frame)
+			return err
 		}
 
-		chunkLength := len(chunk)
-		if chunkLength < 126{
-			convertedLength := uint(chunkLength)
-			frame.payloadLengthInt = &convertedLength
-		} else if uint16(chunkLength) >= 126 && uint16(chunkLength) < math.MaxUint16{
-			convertedLength := uint16(chunkLength)
-			frame.payloadLengthInt16 = &convertedLength
-		} else if uint64(chunkLength) >= math.MaxUint16 && uint64(chunkLength) < math.MaxUint64{
-			convertedLength := uint64(chunkLength)
-			frame.payloadLengthInt64 = &convertedLength
-		} else{
-			return frames, InvalidLength
+		err = write(frame)
+		if err != nil || last {
+			return err
 		}
 
-		frames = append(frames, &frame)
+		if !ws.repeatDataOpcode {
+			// unless in the compatibility mode for peers that expect the data opcode on every fragment
+			opcode = ContinuationFrame
+		}
+	}
+}
 
-		payloadLength -= read
+// checkReadLimit fails the connection with MessageTooBig if a message of the given
+// size exceeds the read limit.
+func (ws *Websocket) checkReadLimit(size uint64) error {
+	if ws.readLimit <= 0 || size <= uint64(ws.readLimit) {
+		return nil
 	}
 
-	if len(frames) > 0 {
-		// set the fin flag for the last frame 
-		frames[len(frames)-1].FIN = true
+	ws.fail(MessageTooBig, ReadLimitExceeded)
+	return ReadLimitExceeded
+}
 
-		switch(ws.t) {
-		case TextWebsocket:
-			frames[0].Opcode = TextFrame 
-		case BinaryWebsocket:
-			frames[0].Opcode = BinaryFrame
-		default:
-			return frames, InvalidFrameType
-		}
+// abuse closes the connection with the given code after the client misbehaved,
+// and reports the misbehaviour to the abuse handler.
+func (ws *Websocket) abuse(code CloseCode, err error) {
+	ws.fail(code, err)
+	if ws.onAbuse != nil {
+		ws.onAbuse(ws, err)
+	}
+}
 
+// fail drops the connection after telling the client why with a Close frame.
+func (ws *Websocket) fail(code CloseCode, err error) {
+	if ws.setState(StateClosing, code, err) {
+		ws.logFailure(code, err)
+		// the client is being dropped either way, a failure to tell it why changes nothing
+		_ = ws.writeClose(code, err.Error())
 	}
 
-	return frames, nil
+	_ = ws.terminate(code, err)
 }
-
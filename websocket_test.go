@@ -0,0 +1,105 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"testing"
+	"testing/iotest"
+)
+
+// maskedFrame encodes a masked frame, as a client sends it.
+func maskedFrame(fin bool, opcode Opcode, payload []byte) []byte {
+	b := []byte{0}
+	if fin {
+		b[0] = 0x80
+	}
+
+	switch opcode {
+	case TextFrame:
+		b[0] |= 0x1
+	case BinaryFrame:
+		b[0] |= 0x2
+	case Pong:
+		b[0] |= 0xa
+	}
+
+	switch n := len(payload); {
+	case n < 126:
+		b = append(b, 0x80|byte(n))
+	case n <= 0xffff:
+		b = append(b, 0x80|126)
+		b = binary.BigEndian.AppendUint16(b, uint16(n))
+	default:
+		b = append(b, 0x80|127)
+		b = binary.BigEndian.AppendUint64(b, uint64(n))
+	}
+
+	key := [4]byte{0x12, 0x34, 0x56, 0x78}
+	b = append(b, key[:]...)
+	masked := bytes.Clone(payload)
+	MaskBytes(key, 0, masked)
+	return append(b, masked...)
+}
+
+// TestReceiveDribblingReader reads frames from a connection delivering a single byte per
+// read, so that every field of a frame, the extended lengths and the masking key included,
+// arrives in pieces.
+func TestReceiveDribblingReader(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload []byte
+	}{
+		{"7-bit length", bytes.Repeat([]byte("a"), 100)},
+		{"16-bit length", bytes.Repeat([]byte("b"), 300)},
+		{"64-bit length", bytes.Repeat([]byte("c"), 70000)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var wire bytes.Buffer
+			wire.Write(maskedFrame(true, BinaryFrame, tt.payload))
+			wire.Write(maskedFrame(true, TextFrame, []byte("next")))
+			ws := &Websocket{t: TextWebsocket, reader: bufio.NewReaderSize(iotest.OneByteReader(&wire), 16)}
+
+			got, err := ws.Receive(context.Background())
+			if err != nil {
+				t.Fatalf("Receive() failed: %v", err)
+			}
+
+			if !bytes.Equal(got, tt.payload) {
+				t.Fatalf("Receive() gave %d bytes, want %d", len(got), len(tt.payload))
+			}
+
+			got, err = ws.Receive(context.Background())
+			if err != nil {
+				t.Fatalf("Receive() of the next message failed: %v", err)
+			}
+
+			if string(got) != "next" {
+				t.Fatalf("Receive() of the next message gave %q, want %q", got, "next")
+			}
+		})
+	}
+}
+
+// TestReceiveDribblingFragments reads a message fragmented over frames delivered a single
+// byte per read.
+func TestReceiveDribblingFragments(t *testing.T) {
+	var wire bytes.Buffer
+	wire.Write(maskedFrame(false, BinaryFrame, bytes.Repeat([]byte("x"), 200)))
+	wire.Write(maskedFrame(false, ContinuationFrame, bytes.Repeat([]byte("y"), 70000)))
+	wire.Write(maskedFrame(true, ContinuationFrame, []byte("z")))
+	ws := &Websocket{t: TextWebsocket, reader: bufio.NewReaderSize(iotest.OneByteReader(&wire), 16)}
+
+	got, err := ws.Receive(context.Background())
+	if err != nil {
+		t.Fatalf("Receive() failed: %v", err)
+	}
+
+	want := append(append(bytes.Repeat([]byte("x"), 200), bytes.Repeat([]byte("y"), 70000)...), 'z')
+	if !bytes.Equal(got, want) {
+		t.Fatalf("Receive() gave %d bytes, want %d", len(got), len(want))
+	}
+}
@@ -0,0 +1,132 @@
+package websocket
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+const (
+	// defaultFramingLimit is the fragment size used when streaming a message
+	// to a connection that has no framing limit configured.
+	defaultFramingLimit = 4096
+)
+
+// SendFrom transports a message read from r until EOF, fragmenting it on the fly
+// using the framing limit of the connection. Only two fragments are held in memory
+// at a time, so payloads of any size can be streamed.
+func (ws *Websocket) SendFrom(ctx context.Context, t MessageType, r io.Reader) (err error) {
+	if ws.State() != StateOpen {
+		return ErrConnectionClosed
+	}
+
+	defer ws.checkError(&err)
+	opcode, err := t.opcode()
+	if err != nil {
+		return err
+	}
+
+	chunkSize := ws.framingLimit
+	if chunkSize <= 0 {
+		chunkSize = defaultFramingLimit
+	}
+
+	ws.messageMu.Lock()
+	defer ws.messageMu.Unlock()
+	ws.touch()
+	defer ws.timeWrite()()
+	started := time.Now()
+
+	current := make([]byte, chunkSize)
+	next := make([]byte, chunkSize)
+	n, err := readChunk(r, current)
+	if err != nil {
+		return err
+	}
+
+	first, size, written := opcode, 0, 0
+	for {
+		// read one chunk ahead, since only an empty read tells us the current chunk is the last one
+		m, err := readChunk(r, next)
+		if err != nil {
+			ws.abandonMessage(written, err)
+			return err
+		}
+
+		frame := getFrame()
+		frame.FIN = m == 0
+		frame.Opcode = opcode
+		frame.ApplicationData = current[:n]
+		err = frame.setPayloadLength(n)
+		if err == nil {
+			err = ws.writeDataFrame(ctx, frame)
+		}
+
+		putFrame(frame)
+		if err != nil {
+			ws.abandonMessage(written, err)
+			return err
+		}
+
+		written++
+		size += n
+		if m == 0 {
+			ws.messageSent(first, size, started)
+			return nil
+		}
+
+		if !ws.repeatDataOpcode {
+			opcode = ContinuationFrame
+		}
+
+		current, next = next, current
+		n = m
+	}
+}
+
+// SetSendRate limits the rate at which messages are sent on the connection to
+// bytesPerSecond, so a connection streaming large payloads cannot starve the others
+// sharing the uplink. Up to burst bytes may be sent at once; a burst of zero or less
+// allows one second worth of bytes. Sends wait for the limit, within their context; a
+// send whose context is done after part of its message was written closes the connection.
+// Control frames are not limited. A rate of zero or less removes the limit.
+func (ws *Websocket) SetSendRate(bytesPerSecond float64, burst int) {
+	if bytesPerSecond <= 0 {
+		ws.sendLimiter.Store(nil)
+		return
+	}
+
+	if burst <= 0 {
+		burst = int(bytesPerSecond)
+	}
+
+	ws.sendLimiter.Store(newRateLimiter(bytesPerSecond, burst))
+}
+
+// throttle waits until n bytes may be sent under the send rate of the connection. The
+// wait does not count towards the write latency of the message being written, which
+// measures the client and not the send rate.
+func (ws *Websocket) throttle(ctx context.Context, n int) error {
+	limiter := ws.sendLimiter.Load()
+	if limiter == nil {
+		return nil
+	}
+
+	started := time.Now()
+	err := limiter.wait(ctx, float64(n))
+	if ws.writeStarted.Load() != 0 {
+		ws.writeStarted.Add(int64(time.Since(started)))
+	}
+
+	return err
+}
+
+// readChunk fills b from r, returning fewer bytes only when r is exhausted.
+func readChunk(r io.Reader, b []byte) (int, error) {
+	n, err := io.ReadFull(r, b)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return n, nil
+	}
+
+	return n, err
+}